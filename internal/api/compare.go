@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// MaxCompareServers caps the number of nameservers in a single comparison.
+const MaxCompareServers = 16
+
+// CompareServer identifies one nameserver taking part in a comparison.
+type CompareServer struct {
+	Nameserver string `json:"nameserver"`
+	Transport  string `json:"transport"`
+}
+
+// CompareRequest defines the body accepted by the HTTP `/compare` endpoint.
+type CompareRequest struct {
+	Servers []CompareServer `json:"servers"`
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	DNSSEC  bool            `json:"dnssec"`
+}
+
+// CompareResponse defines the JSON response of the `/compare` endpoint.
+type CompareResponse struct {
+	Status    int             `json:"status"`
+	Success   bool            `json:"success"`
+	Timestamp string          `json:"timestamp"`
+	Request   CompareRequest  `json:"request"`
+	Results   []CompareResult `json:"results,omitempty"`
+	Diff      *CompareDiff    `json:"diff,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// CompareResult holds the outcome of the query against a single server.
+//
+// Answers contains the normalized answer set ("name TYPE rdata", lowercased
+// owner names, no TTL) so results of different servers can be compared.
+type CompareResult struct {
+	Nameserver string   `json:"nameserver"`
+	Transport  string   `json:"transport"`
+	Command    string   `json:"command"`
+	Success    bool     `json:"success"`
+	Error      string   `json:"error,omitempty"`
+	Rcode      string   `json:"rcode,omitempty"`
+	Answers    []string `json:"answers"`
+	MinTTL     uint32   `json:"min_ttl"`
+	MaxTTL     uint32   `json:"max_ttl"`
+	RTTMillis  float64  `json:"rtt_ms"`
+	// Overloaded reports that the execution pool refused the query.
+	Overloaded bool `json:"-"`
+}
+
+// CompareDiff summarizes how the successful results differ.
+type CompareDiff struct {
+	// Agree is true when all successful servers returned the same rcode and
+	// answer set.
+	Agree bool `json:"agree"`
+	// Consensus lists answers returned by every successful server.
+	Consensus []string `json:"consensus"`
+	// Differences lists, per server, answers missing compared to the union of
+	// all answers and answers not shared by every server.
+	Differences []ServerDiff `json:"differences,omitempty"`
+	// Rcodes maps each returned rcode to the servers that returned it.
+	Rcodes map[string][]string `json:"rcodes"`
+	// RcodeMismatch is true when servers disagree on the rcode.
+	RcodeMismatch bool `json:"rcode_mismatch"`
+	// TTL describes the spread of answer TTLs across servers.
+	TTL TTLSpread `json:"ttl"`
+}
+
+// ServerDiff lists the answer differences of one server.
+type ServerDiff struct {
+	Nameserver string   `json:"nameserver"`
+	Missing    []string `json:"missing,omitempty"`
+	Extra      []string `json:"extra,omitempty"`
+}
+
+// TTLSpread describes minimum and maximum TTLs observed across servers.
+type TTLSpread struct {
+	Min    uint32 `json:"min"`
+	Max    uint32 `json:"max"`
+	Spread uint32 `json:"spread"`
+}
+
+// QueryFor returns the single-server query corresponding to server.
+func (r CompareRequest) QueryFor(server CompareServer) RequestPayload {
+	return RequestPayload{
+		Nameserver:       server.Nameserver,
+		Short:            false,
+		DNSSEC:           r.DNSSEC,
+		Type:             r.Type,
+		Transport:        server.Transport,
+		Name:             r.Name,
+		AsJSON:           false,
+		Structured:       true,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+}
+
+// ValidateCompare checks a comparison request and returns (ok, httpStatus,
+// errorMessage). Every server is validated like a single `/query` request.
+func ValidateCompare(req CompareRequest) (bool, int, string) {
+	if len(req.Servers) == 0 {
+		return false, http.StatusBadRequest, `"servers" must not be empty`
+	}
+	if len(req.Servers) > MaxCompareServers {
+		return false, http.StatusBadRequest, `"servers" must not contain more than ` +
+			strconv.Itoa(MaxCompareServers) + ` entries`
+	}
+	for i, server := range req.Servers {
+		if ok, status, msg := Validate(req.QueryFor(server)); !ok {
+			return false, status, "servers[" + strconv.Itoa(i) + "]: " + msg
+		}
+	}
+	return true, http.StatusOK, ""
+}
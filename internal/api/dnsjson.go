@@ -0,0 +1,33 @@
+package api
+
+// DNSJSONResponse is the `application/dns-json` answer format used by the
+// Google and Cloudflare JSON DoH APIs and served at `/resolve`.
+type DNSJSONResponse struct {
+	// Status is the numeric DNS response code (0 = NOERROR, 3 = NXDOMAIN).
+	Status     int               `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []DNSJSONQuestion `json:"Question"`
+	Answer     []DNSJSONRecord   `json:"Answer,omitempty"`
+	Authority  []DNSJSONRecord   `json:"Authority,omitempty"`
+	Additional []DNSJSONRecord   `json:"Additional,omitempty"`
+	// Comment carries diagnostics such as upstream errors.
+	Comment string `json:"Comment,omitempty"`
+}
+
+// DNSJSONQuestion is a question entry of a DNSJSONResponse.
+type DNSJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+// DNSJSONRecord is a resource record of a DNSJSONResponse.
+type DNSJSONRecord struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
@@ -1,7 +1,12 @@
 // Package api contains request/response types and validation for the wdns HTTP API.
 package api
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/exiguus/wdns/internal/dnssec"
+	"github.com/exiguus/wdns/internal/rrtype"
+)
 
 // RequestPayload defines the structure of the incoming JSON request.
 //
@@ -14,6 +19,21 @@ type RequestPayload struct {
 	Transport  string `json:"transport"`
 	Name       string `json:"name"`
 	AsJSON     bool   `json:"json"`
+	// Structured requests the answer as a parsed, kdig-version independent
+	// message instead of raw text. It cannot be combined with Short or AsJSON.
+	Structured bool `json:"structured"`
+	// ValidateDNSSEC requests in-process DNSSEC validation of the answer. The
+	// chain-of-trust report is returned in ResponsePayload.DNSSEC.
+	ValidateDNSSEC bool `json:"validate"`
+	// Trace requests iterative resolution from the root servers instead of a
+	// query to Nameserver, returning every delegation hop.
+	Trace bool `json:"trace"`
+	// CheckingDisabled sets the CD bit, asking a validating upstream to
+	// return data even when its own DNSSEC validation fails.
+	CheckingDisabled bool `json:"cd"`
+	// NoCache bypasses the response cache lookup; the fresh answer still
+	// replaces the cached one.
+	NoCache bool `json:"no_cache"`
 }
 
 // ResponsePayload defines the structure of the JSON responses.
@@ -28,23 +48,71 @@ type ResponsePayload struct {
 	Command string      `json:"command,omitempty"`
 	Answer  interface{} `json:"answer,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	// Note carries a per-type hint from the record type registry, if any.
+	Note string `json:"note,omitempty"`
+	// Index is the position of the item in a /batch request.
+	Index *int `json:"index,omitempty"`
+	// DNSSEC is the validation report when the request set "validate".
+	DNSSEC *dnssec.Report `json:"dnssec,omitempty"`
+	// Cached reports that the answer was served from the response cache.
+	Cached bool `json:"cached,omitempty"`
+	// TTL is the remaining time to live in seconds of a cached answer.
+	TTL *uint32 `json:"ttl,omitempty"`
+	// Shared reports that the answer came from an upstream execution shared
+	// with other identical in-flight requests.
+	Shared bool `json:"shared,omitempty"`
+	// TraceID is the OpenTelemetry trace the query was executed in, when
+	// tracing is enabled or the caller sent a traceparent header.
+	TraceID string `json:"trace_id,omitempty"`
 }
 
 // Validate checks request parameters and returns (ok, httpStatus, errorMessage).
 //
 // It applies basic presence and allowed-value checks for incoming requests.
 func Validate(req RequestPayload) (bool, int, string) {
-	if req.Nameserver == "" {
+	if req.Nameserver == "" && !req.Trace {
 		return false, http.StatusBadRequest, `"nameserver" must not be empty`
 	}
 	if req.Name == "" {
 		return false, http.StatusBadRequest, `"name" must not be empty`
 	}
-	if req.Type != "AAAA" && req.Type != "A" {
-		return false, http.StatusBadRequest, `"type" must be "AAAA" or "A"`
+	rrType, known := rrtype.Lookup(req.Type)
+	if !known {
+		return false, http.StatusBadRequest, `"type" must be a known record type (e.g. "A", "MX") or "TYPE<n>"`
+	}
+	if rrType.Meta {
+		return false, http.StatusBadRequest, `"type" ` + rrType.Name + ` is a pseudo-record and cannot be queried`
 	}
 	if req.Transport != "tls" && req.Transport != "https" && req.Transport != "tcp" && req.Transport != "" {
 		return false, http.StatusBadRequest, `"transport" must be empty or "tcp" or "tls" or "https"`
 	}
+	if req.Structured && (req.Short || req.AsJSON) {
+		return false, http.StatusBadRequest, `"structured" cannot be combined with "short" or "json"`
+	}
+	if rrType.ZoneTransfer && req.Transport != "tcp" && req.Transport != "tls" {
+		return false, http.StatusBadRequest, `"type" ` + rrType.Name + ` requires "transport" "tcp" or "tls"`
+	}
+	if rrType.ZoneTransfer && req.ValidateDNSSEC {
+		return false, http.StatusBadRequest, `"validate" cannot be used with zone transfers`
+	}
+	if req.Trace {
+		return validateTrace(req, rrType)
+	}
+	return true, http.StatusOK, ""
+}
+
+// validateTrace checks the options allowed with "trace". Iterative
+// resolution talks to authoritative servers directly, so only UDP and TCP are
+// supported and the answer is always the structured hop list.
+func validateTrace(req RequestPayload, rrType rrtype.Type) (bool, int, string) {
+	if req.Transport != "" && req.Transport != "tcp" {
+		return false, http.StatusBadRequest, `"trace" requires "transport" empty or "tcp"`
+	}
+	if req.Short || req.AsJSON || req.Structured || req.ValidateDNSSEC {
+		return false, http.StatusBadRequest, `"trace" cannot be combined with "short", "json", "structured" or "validate"`
+	}
+	if rrType.ZoneTransfer {
+		return false, http.StatusBadRequest, `"trace" cannot be used with zone transfers`
+	}
 	return true, http.StatusOK, ""
 }
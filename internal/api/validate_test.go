@@ -16,65 +16,252 @@ func TestValidate(t *testing.T) {
 		{
 			"empty nameserver",
 			api.RequestPayload{
-				Nameserver: "",
-				Name:       "example.com",
-				Type:       "A",
-				Short:      false,
-				DNSSEC:     false,
-				Transport:  "",
-				AsJSON:     false,
+				Nameserver:       "",
+				Name:             "example.com",
+				Type:             "A",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
 			},
 			false,
 		},
 		{
 			"empty name",
 			api.RequestPayload{
-				Nameserver: "1.1.1.1",
-				Name:       "",
-				Type:       "A",
-				Short:      false,
-				DNSSEC:     false,
-				Transport:  "",
-				AsJSON:     false,
+				Nameserver:       "1.1.1.1",
+				Name:             "",
+				Type:             "A",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
 			},
 			false,
 		},
 		{
 			"bad type",
 			api.RequestPayload{
-				Nameserver: "1.1.1.1",
-				Name:       "example.com",
-				Type:       "TXT",
-				Short:      false,
-				DNSSEC:     false,
-				Transport:  "",
-				AsJSON:     false,
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "BOGUS",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
 			},
 			false,
 		},
+		{
+			"pseudo type",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "OPT",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			false,
+		},
+		{
+			"zone transfer over udp",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "AXFR",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			false,
+		},
+		{
+			"zone transfer over tcp",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "AXFR",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "tcp",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			true,
+		},
+		{
+			"lowercase mx",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "mx",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			true,
+		},
+		{
+			"generic type",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "TYPE65534",
+				Short:            false,
+				DNSSEC:           false,
+				Transport:        "",
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			true,
+		},
 		{
 			"bad transport",
 			api.RequestPayload{
-				Nameserver: "1.1.1.1",
-				Name:       "example.com",
-				Type:       "A",
-				Transport:  "bad",
-				Short:      false,
-				DNSSEC:     false,
-				AsJSON:     false,
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "A",
+				Transport:        "bad",
+				Short:            false,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			false,
+		},
+		{
+			"validate with zone transfer",
+			api.RequestPayload{
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "AXFR",
+				Transport:        "tcp",
+				Short:            false,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   true,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			false,
+		},
+		{
+			"trace without nameserver",
+			api.RequestPayload{
+				Nameserver:       "",
+				Name:             "example.com",
+				Type:             "A",
+				Transport:        "",
+				Short:            false,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            true,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			true,
+		},
+		{
+			"trace over tls",
+			api.RequestPayload{
+				Nameserver:       "",
+				Name:             "example.com",
+				Type:             "A",
+				Transport:        "tls",
+				Short:            false,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            true,
+				CheckingDisabled: false,
+				NoCache:          false,
+			},
+			false,
+		},
+		{
+			"trace with short",
+			api.RequestPayload{
+				Nameserver:       "",
+				Name:             "example.com",
+				Type:             "A",
+				Transport:        "",
+				Short:            true,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            true,
+				CheckingDisabled: false,
+				NoCache:          false,
 			},
 			false,
 		},
 		{
 			"ok",
 			api.RequestPayload{
-				Nameserver: "1.1.1.1",
-				Name:       "example.com",
-				Type:       "A",
-				Transport:  "",
-				Short:      false,
-				DNSSEC:     false,
-				AsJSON:     false,
+				Nameserver:       "1.1.1.1",
+				Name:             "example.com",
+				Type:             "A",
+				Transport:        "",
+				Short:            false,
+				DNSSEC:           false,
+				AsJSON:           false,
+				Structured:       false,
+				ValidateDNSSEC:   false,
+				Trace:            false,
+				CheckingDisabled: false,
+				NoCache:          false,
 			},
 			true,
 		},
@@ -0,0 +1,230 @@
+// Package auth authenticates API keys passed as bearer tokens and enforces
+// their scopes, per-key rate limits and daily quotas.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	dayLayout  = "2006-01-02"
+	hoursInDay = 24
+)
+
+// Limits is the rate limit applied to keys that do not set their own.
+type Limits struct {
+	RPS   float64
+	Burst int
+}
+
+// Decision is the outcome of charging a request against a key.
+type Decision struct {
+	// Allowed is false when the request exceeds the rate limit or quota.
+	Allowed bool
+	// Reason explains a refusal.
+	Reason string
+	// RetryAfter is how long the client should wait after a refusal.
+	RetryAfter time.Duration
+	// QuotaLimit is the daily quota of the key, 0 when unlimited.
+	QuotaLimit int
+	// QuotaRemaining is the number of requests left today.
+	QuotaRemaining int
+	// QuotaReset is the time until the quota resets at midnight UTC.
+	QuotaReset time.Duration
+}
+
+type usage struct {
+	limiter *rate.Limiter
+	day     string
+	used    int
+}
+
+// Keyring holds the configured API keys and their usage.
+type Keyring struct {
+	mu       sync.Mutex
+	keys     []Key
+	byHash   map[string]Key
+	byDN     map[string]Key
+	byCN     map[string]Key
+	usage    map[string]*usage
+	defaults Limits
+	// Now returns the current time; it is replaceable in tests.
+	Now func() time.Time
+}
+
+// NewKeyring returns a keyring for keys, which must have been validated by
+// ParseKeys. Keys without their own rate limit use defaults.
+func NewKeyring(keys []Key, defaults Limits) *Keyring {
+	byHash := make(map[string]Key, len(keys))
+	byDN, byCN := make(map[string]Key), make(map[string]Key)
+	for _, k := range keys {
+		if k.Hash != "" {
+			byHash[k.Hash] = k
+		}
+		for _, subject := range k.Subjects {
+			switch prefix, name, _ := splitSubject(subject); prefix {
+			case subjectDN:
+				byDN[name] = k
+			case subjectCN:
+				byCN[name] = k
+			default:
+			}
+		}
+	}
+	return &Keyring{
+		mu:       sync.Mutex{},
+		keys:     keys,
+		byHash:   byHash,
+		byDN:     byDN,
+		byCN:     byCN,
+		usage:    make(map[string]*usage, len(keys)),
+		defaults: defaults,
+		Now:      time.Now,
+	}
+}
+
+// Authenticate resolves the key presented in an Authorization header value of
+// the form "Bearer <secret>".
+func (k *Keyring) Authenticate(authorization string) (Key, bool) {
+	scheme, secret, ok := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(secret) == "" {
+		var zero Key
+		return zero, false
+	}
+	key, found := k.byHash[HashSecret(strings.TrimSpace(secret))]
+	return key, found
+}
+
+// AuthenticateCertificate resolves the key mapped to a verified client
+// certificate, matching its subject distinguished name against "dn:"
+// subjects first and then its common name against "cn:" subjects. The two
+// never match each other, so a common name that looks like a distinguished
+// name cannot stand in for one.
+func (k *Keyring) AuthenticateCertificate(cert *x509.Certificate) (Key, bool) {
+	if key, found := k.byDN[cert.Subject.String()]; found {
+		return key, true
+	}
+	if cn := cert.Subject.CommonName; cn != "" {
+		key, found := k.byCN[cn]
+		return key, found
+	}
+	var zero Key
+	return zero, false
+}
+
+// Allow charges one request against key's rate limit and daily quota.
+func (k *Keyring) Allow(key Key) Decision {
+	return k.charge(key, 1)
+}
+
+// AllowN charges n requests against key's rate limit and daily quota, or
+// none if they do not all fit.
+func (k *Keyring) AllowN(key Key, n int) Decision {
+	return k.charge(key, n)
+}
+
+// Status reports key's quota without charging a request.
+func (k *Keyring) Status(key Key) Decision {
+	return k.charge(key, 0)
+}
+
+// SetDefaults changes the rate limit of keys that do not set their own,
+// keeping their accumulated tokens and daily usage.
+func (k *Keyring) SetDefaults(defaults Limits) {
+	now := k.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.defaults = defaults
+	for _, key := range k.keys {
+		u, ok := k.usage[key.ID]
+		if !ok {
+			continue
+		}
+		rps, burst := k.limitsOf(key)
+		u.limiter.SetLimitAt(now, rate.Limit(rps))
+		u.limiter.SetBurstAt(now, burst)
+	}
+}
+
+func (k *Keyring) charge(key Key, n int) Decision {
+	now := k.Now().UTC()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	u := k.usageOf(key, now)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(hoursInDay * time.Hour)
+	decision := Decision{
+		Allowed:        true,
+		Reason:         "",
+		RetryAfter:     0,
+		QuotaLimit:     key.DailyQuota,
+		QuotaRemaining: max(key.DailyQuota-u.used, 0),
+		QuotaReset:     midnight.Sub(now),
+	}
+	if n == 0 {
+		return decision
+	}
+	if key.DailyQuota > 0 && u.used+n > key.DailyQuota {
+		decision.Allowed = false
+		decision.Reason = "daily quota exceeded"
+		decision.RetryAfter = decision.QuotaReset
+		return decision
+	}
+	if !u.limiter.AllowN(now, n) {
+		decision.Allowed = false
+		decision.Reason = "rate limit exceeded"
+		decision.RetryAfter = time.Second
+		return decision
+	}
+	u.used += n
+	if key.DailyQuota > 0 {
+		decision.QuotaRemaining -= n
+	}
+	return decision
+}
+
+// limitsOf returns the rate limit of key. The caller must hold k.mu.
+func (k *Keyring) limitsOf(key Key) (float64, int) {
+	rps, burst := key.RPS, key.Burst
+	if rps == 0 {
+		rps = k.defaults.RPS
+	}
+	if burst == 0 {
+		burst = k.defaults.Burst
+	}
+	return rps, burst
+}
+
+// usageOf returns the usage record of key, resetting the daily counter on a
+// new UTC day. The caller must hold k.mu.
+func (k *Keyring) usageOf(key Key, now time.Time) *usage {
+	u, ok := k.usage[key.ID]
+	if !ok {
+		rps, burst := k.limitsOf(key)
+		u = &usage{limiter: rate.NewLimiter(rate.Limit(rps), burst), day: "", used: 0}
+		k.usage[key.ID] = u
+	}
+	if day := now.Format(dayLayout); u.day != day {
+		u.day, u.used = day, 0
+	}
+	return u
+}
+
+type keyContextKey struct{}
+
+// WithKey returns a context carrying the authenticated key.
+func WithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// KeyFromContext returns the key attached by WithKey.
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(Key)
+	return key, ok
+}
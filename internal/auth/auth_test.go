@@ -0,0 +1,194 @@
+package auth_test
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/auth"
+)
+
+func newKeyring(t *testing.T, quota int) (*auth.Keyring, *time.Time) {
+	t.Helper()
+	keys, err := auth.ParseKeys(strings.NewReader(`[
+		{"id": "ci", "hash": "` + auth.HashSecret("s3cret") + `", "scopes": ["query"], "rps": 1000, "burst": 1000, "daily_quota": ` + strconv.Itoa(quota) + `}
+	]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 23, 59, 0, 0, time.UTC)
+	ring := auth.NewKeyring(keys, auth.Limits{RPS: 1, Burst: 1})
+	ring.Now = func() time.Time { return now }
+	return ring, &now
+}
+
+func TestAuthenticate(t *testing.T) {
+	ring, _ := newKeyring(t, 0)
+	cases := map[string]bool{
+		"Bearer s3cret":   true,
+		"bearer  s3cret":  true,
+		"Bearer wrong":    false,
+		"Basic s3cret":    false,
+		"Bearer":          false,
+		"":                false,
+		"s3cret":          false,
+		"Bearer s3cret x": false,
+	}
+	for header, want := range cases {
+		key, ok := ring.Authenticate(header)
+		if ok != want {
+			t.Errorf("Authenticate(%q) = %v, want %v", header, ok, want)
+		}
+		if ok && (key.ID != "ci" || !key.HasScope(auth.ScopeQuery) || key.HasScope(auth.ScopeAdmin)) {
+			t.Errorf("Authenticate(%q) returned %+v", header, key)
+		}
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	keys, err := auth.ParseKeys(strings.NewReader(`[
+		{"id": "ci", "subjects": ["cn:ci.example"], "scopes": ["query"]},
+		{"id": "ops", "subjects": ["dn:CN=ops,O=Example"], "scopes": ["admin"]}
+	]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	ring := auth.NewKeyring(keys, auth.Limits{RPS: 1, Burst: 1})
+	cases := []struct {
+		subject pkix.Name
+		want    string
+	}{
+		{pkix.Name{CommonName: "ci.example", Organization: []string{"Example"}}, "ci"},
+		{pkix.Name{CommonName: "ci.example", Organization: []string{"Other"}}, "ci"},
+		{pkix.Name{CommonName: "ops", Organization: []string{"Example"}}, "ops"},
+		// a DN entry does not match the common name of another organization
+		{pkix.Name{CommonName: "ops", Organization: []string{"Other"}}, ""},
+		{pkix.Name{CommonName: "ops"}, ""},
+		// nor a common name spelling out the distinguished name
+		{pkix.Name{CommonName: "CN=ops,O=Example"}, ""},
+		{pkix.Name{CommonName: "CN=ops,O=Example", Organization: []string{"Example"}}, ""},
+	}
+	for _, tc := range cases {
+		//nolint:exhaustruct // only the subject is matched
+		key, ok := ring.AuthenticateCertificate(&x509.Certificate{Subject: tc.subject})
+		if ok != (tc.want != "") || key.ID != tc.want {
+			t.Errorf("%s: got %q %v, want %q", tc.subject, key.ID, ok, tc.want)
+		}
+	}
+	if _, ok := ring.Authenticate("Bearer "); ok {
+		t.Fatal("certificate-only keys must not match an empty secret")
+	}
+}
+
+func TestDailyQuotaResetsAtMidnight(t *testing.T) {
+	ring, now := newKeyring(t, 2)
+	key, _ := ring.Authenticate("Bearer s3cret")
+
+	for i := range 2 {
+		if d := ring.Allow(key); !d.Allowed || d.QuotaRemaining != 1-i {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	d := ring.Allow(key)
+	if d.Allowed || d.Reason != "daily quota exceeded" || d.RetryAfter != time.Minute {
+		t.Fatalf("expected quota refusal retrying in a minute, got %+v", d)
+	}
+
+	*now = now.Add(time.Minute)
+	if d := ring.Allow(key); !d.Allowed || d.QuotaRemaining != 1 || d.QuotaLimit != 2 {
+		t.Fatalf("expected quota reset, got %+v", d)
+	}
+}
+
+func TestAllowNChargesAllOrNothing(t *testing.T) {
+	ring, _ := newKeyring(t, 5)
+	key, _ := ring.Authenticate("Bearer s3cret")
+
+	if d := ring.AllowN(key, 3); !d.Allowed || d.QuotaRemaining != 2 {
+		t.Fatalf("first charge: %+v", d)
+	}
+	if d := ring.AllowN(key, 3); d.Allowed || d.Reason != "daily quota exceeded" {
+		t.Fatalf("expected quota refusal, got %+v", d)
+	}
+	if d := ring.AllowN(key, 2); !d.Allowed || d.QuotaRemaining != 0 {
+		t.Fatalf("a refused charge must not use quota: %+v", d)
+	}
+}
+
+func TestDefaultRateLimit(t *testing.T) {
+	keys, err := auth.ParseKeys(strings.NewReader(`[{"id": "a", "hash": "` + auth.HashSecret("x") + `", "scopes": ["batch"]}]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	ring := auth.NewKeyring(keys, auth.Limits{RPS: 1, Burst: 1})
+	ring.Now = func() time.Time { return time.Unix(0, 0) }
+
+	if d := ring.Allow(keys[0]); !d.Allowed || d.QuotaLimit != 0 {
+		t.Fatalf("first request: %+v", d)
+	}
+	if d := ring.Allow(keys[0]); d.Allowed || d.Reason != "rate limit exceeded" {
+		t.Fatalf("expected rate limit refusal, got %+v", d)
+	}
+	if d := ring.Status(keys[0]); !d.Allowed {
+		t.Fatalf("Status must not charge or refuse: %+v", d)
+	}
+}
+
+func TestSetDefaultsKeepsUsage(t *testing.T) {
+	keys, err := auth.ParseKeys(strings.NewReader(`[{"id": "a", "hash": "` + auth.HashSecret("x") + `", "scopes": ["query"]}]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	now := time.Unix(0, 0)
+	ring := auth.NewKeyring(keys, auth.Limits{RPS: 1, Burst: 1})
+	ring.Now = func() time.Time { return now }
+
+	if d := ring.Allow(keys[0]); !d.Allowed {
+		t.Fatalf("first request: %+v", d)
+	}
+	ring.SetDefaults(auth.Limits{RPS: 1000, Burst: 5})
+	if d := ring.Allow(keys[0]); d.Allowed {
+		t.Fatalf("the exhausted bucket must be kept, got %+v", d)
+	}
+	now = now.Add(10 * time.Millisecond)
+	for i := range 5 {
+		if d := ring.Allow(keys[0]); !d.Allowed {
+			t.Fatalf("request %d after refill: %+v", i, d)
+		}
+	}
+	if d := ring.Allow(keys[0]); d.Allowed {
+		t.Fatalf("expected the new burst of 5 to be enforced, got %+v", d)
+	}
+}
+
+func TestParseKeysInvalid(t *testing.T) {
+	hash := auth.HashSecret("x")
+	cases := map[string]string{
+		"missing id":     `[{"hash": "` + hash + `", "scopes": ["query"]}]`,
+		"bad hash":       `[{"id": "a", "hash": "sha256:zz", "scopes": ["query"]}]`,
+		"no scopes":      `[{"id": "a", "hash": "` + hash + `"}]`,
+		"unknown scope":  `[{"id": "a", "hash": "` + hash + `", "scopes": ["root"]}]`,
+		"negative":       `[{"id": "a", "hash": "` + hash + `", "scopes": ["query"], "daily_quota": -1}]`,
+		"duplicate":      `[{"id": "a", "hash": "` + hash + `", "scopes": ["query"]}, {"id": "a", "hash": "` + hash + `", "scopes": ["batch"]}]`,
+		"no credential":  `[{"id": "a", "scopes": ["query"]}]`,
+		"empty subject":  `[{"id": "a", "subjects": [""], "scopes": ["query"]}]`,
+		"no prefix":      `[{"id": "a", "subjects": ["ci.example"], "scopes": ["query"]}]`,
+		"empty name":     `[{"id": "a", "subjects": ["cn:"], "scopes": ["query"]}]`,
+		"shared subject": `[{"id": "a", "subjects": ["cn:ci"], "scopes": ["query"]}, {"id": "b", "subjects": ["cn:ci"], "scopes": ["batch"]}]`,
+	}
+	for name, input := range cases {
+		if _, err := auth.ParseKeys(strings.NewReader(input)); !errors.Is(err, auth.ErrInvalidKey) {
+			t.Errorf("%s: expected ErrInvalidKey, got %v", name, err)
+		}
+	}
+
+	bare := strings.TrimPrefix(strings.ToUpper(hash), "SHA256:")
+	keys, err := auth.ParseKeys(strings.NewReader(`[{"id": "a", "hash": "` + bare + `", "scopes": ["query"]}]`))
+	if err != nil || keys[0].Hash != hash {
+		t.Fatalf("bare digest: %v %+v", err, keys)
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Scopes granted to API keys.
+const (
+	ScopeQuery = "query"
+	ScopeBatch = "batch"
+	ScopeAdmin = "admin"
+)
+
+const hashPrefix = "sha256:"
+
+// Prefixes of Key.Subjects entries.
+const (
+	subjectDN = "dn:"
+	subjectCN = "cn:"
+)
+
+// ErrInvalidKey is wrapped by every validation error of a key definition.
+var ErrInvalidKey = errors.New("invalid api key")
+
+// Key defines one API key. Only the SHA-256 hash of the secret is stored.
+type Key struct {
+	// ID identifies the key in logs and rate-limit headers.
+	ID string `json:"id"`
+	// Hash is "sha256:" followed by the hex SHA-256 digest of the secret. It
+	// may be empty for keys only presented as client certificates.
+	Hash string `json:"hash,omitempty"`
+	// Subjects lists client certificate subjects authenticated as this key,
+	// either the full distinguished name ("dn:CN=ci,O=Example") or the
+	// common name alone ("cn:ci.example"), which matches certificates with
+	// that common name whatever the rest of their subject.
+	Subjects []string `json:"subjects,omitempty"`
+	// Scopes lists the granted scopes: "query", "batch" and/or "admin".
+	Scopes []string `json:"scopes"`
+	// RPS and Burst override the default per-key rate limit.
+	RPS   float64 `json:"rps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+	// DailyQuota caps the requests per UTC day; 0 means unlimited.
+	DailyQuota int `json:"daily_quota,omitempty"`
+}
+
+// HasScope reports whether the key grants scope.
+func (k Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// HashSecret returns the stored form of an API key secret.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// LoadKeys reads a JSON array of key definitions from path.
+func LoadKeys(path string) ([]Key, error) {
+	f, err := os.Open(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("open api keys file: %w", err)
+	}
+	defer f.Close()
+	keys, err := ParseKeys(f)
+	if err != nil {
+		return nil, fmt.Errorf("api keys file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// ParseKeys reads a JSON array of key definitions and validates them.
+func ParseKeys(r io.Reader) ([]Key, error) {
+	var keys []Key
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&keys); err != nil {
+		return nil, fmt.Errorf("decode api keys: %w", err)
+	}
+	seen := make(map[string]bool, len(keys))
+	subjects := make(map[string]bool)
+	for i := range keys {
+		if err := normalizeKey(&keys[i]); err != nil {
+			return nil, err
+		}
+		if seen[keys[i].ID] {
+			return nil, fmt.Errorf("%w %q: duplicate id", ErrInvalidKey, keys[i].ID)
+		}
+		seen[keys[i].ID] = true
+		for _, subject := range keys[i].Subjects {
+			if subjects[subject] {
+				return nil, fmt.Errorf("%w %q: subject %q is used by another key", ErrInvalidKey, keys[i].ID, subject)
+			}
+			subjects[subject] = true
+		}
+	}
+	return keys, nil
+}
+
+// normalizeKey validates k and lowercases its hash. A bare 64 character hex
+// digest is accepted without the "sha256:" prefix.
+func normalizeKey(k *Key) error {
+	invalid := func(msg string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidKey, k.ID, msg)
+	}
+	if k.ID == "" {
+		return invalid("id must not be empty")
+	}
+	if k.Hash == "" && len(k.Subjects) == 0 {
+		return invalid("hash or subjects is required")
+	}
+	if k.Hash != "" {
+		digest := strings.TrimPrefix(strings.ToLower(k.Hash), hashPrefix)
+		if raw, err := hex.DecodeString(digest); err != nil || len(raw) != sha256.Size {
+			return invalid(`hash must be "sha256:" followed by 64 hex digits`)
+		}
+		k.Hash = hashPrefix + digest
+	}
+	for _, subject := range k.Subjects {
+		if _, _, ok := splitSubject(subject); !ok {
+			return invalid(fmt.Sprintf(`subject %q must be "dn:" or "cn:" followed by a name`, subject))
+		}
+	}
+	if len(k.Scopes) == 0 {
+		return invalid("at least one scope is required")
+	}
+	for _, scope := range k.Scopes {
+		if scope != ScopeQuery && scope != ScopeBatch && scope != ScopeAdmin {
+			return invalid(fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+	if k.RPS < 0 || k.Burst < 0 || k.DailyQuota < 0 {
+		return invalid("rps, burst and daily_quota must not be negative")
+	}
+	return nil
+}
+
+// splitSubject splits a Key.Subjects entry into its prefix and name.
+func splitSubject(subject string) (string, string, bool) {
+	for _, prefix := range []string{subjectDN, subjectCN} {
+		if name, ok := strings.CutPrefix(subject, prefix); ok && name != "" {
+			return prefix, name, true
+		}
+	}
+	return "", "", false
+}
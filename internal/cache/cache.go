@@ -0,0 +1,233 @@
+// Package cache provides a TTL-aware, memory-bounded response cache that
+// wraps a resolver.Resolver.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+// Defaults applied by New to zero Config values.
+const (
+	DefaultMaxEntries = 10000
+	DefaultMaxBytes   = 32 << 20
+	DefaultMaxTTL     = time.Hour
+)
+
+// Config bounds the cache.
+type Config struct {
+	// MaxEntries is the maximum number of cached responses (default 10000).
+	MaxEntries int
+	// MaxBytes bounds the approximate memory used by cached responses
+	// (default 32 MiB).
+	MaxBytes int
+	// MaxTTL caps the lifetime of an entry regardless of the record TTLs
+	// (default 1h).
+	MaxTTL time.Duration
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Bypassed  uint64 `json:"bypassed"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Bytes     int    `json:"bytes"`
+}
+
+type entry struct {
+	key     string
+	out     []byte
+	cmd     string
+	expires time.Time
+}
+
+func (e *entry) size() int {
+	return len(e.key) + len(e.out) + len(e.cmd)
+}
+
+// Cache is a resolver.Resolver that serves repeated queries from memory for
+// as long as the answer's TTL allows. Entries are evicted least recently used
+// first when MaxEntries or MaxBytes is exceeded.
+type Cache struct {
+	next resolver.Resolver
+	cfg  Config
+	// Now returns the current time; tests replace it to expire entries.
+	Now func() time.Time
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+	bytes int
+	stats Stats
+}
+
+// New wraps next with a cache bounded by cfg.
+func New(next resolver.Resolver, cfg Config) *Cache {
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultMaxEntries
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxTTL <= 0 {
+		cfg.MaxTTL = DefaultMaxTTL
+	}
+	return &Cache{
+		next:  next,
+		cfg:   cfg,
+		Now:   time.Now,
+		mu:    sync.Mutex{},
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+		bytes: 0,
+		stats: Stats{Hits: 0, Misses: 0, Bypassed: 0, Evictions: 0, Entries: 0, Bytes: 0},
+	}
+}
+
+// QueryTimeout reports the timeout of the wrapped resolver.
+func (c *Cache) QueryTimeout() time.Duration {
+	return c.next.QueryTimeout()
+}
+
+// Run serves req from the cache when possible. Requests with NoCache skip
+// the lookup but still refresh the entry. Cache hits are reported through the
+// resolver.Meta attached to ctx.
+func (c *Cache) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
+	key, err := cacheKey(req)
+	if err != nil {
+		return c.next.Run(ctx, req)
+	}
+	if !req.NoCache {
+		if out, cmd, remaining, ok := c.get(key); ok {
+			if meta := resolver.MetaFromContext(ctx); meta != nil {
+				meta.Cached = true
+				meta.TTL = remaining
+			}
+			return out, cmd, nil
+		}
+	} else {
+		c.mu.Lock()
+		c.stats.Bypassed++
+		c.mu.Unlock()
+	}
+
+	out, cmd, ttl, err := c.fetch(ctx, req)
+	if err == nil && ttl > 0 {
+		c.put(key, out, cmd, ttl)
+	}
+	return out, cmd, err
+}
+
+// fetch runs req on the wrapped resolver and determines the cache lifetime of
+// the output. Short output carries no TTLs, so short requests are resolved in
+// text mode and rendered like kdig +short here. Text output that cannot be
+// parsed, such as kdig's warnings when no answer arrived, has no short
+// rendering and is returned as is without being cached.
+func (c *Cache) fetch(ctx context.Context, req api.RequestPayload) ([]byte, string, uint32, error) {
+	if req.Short && !req.AsJSON {
+		full := req
+		full.Short = false
+		out, cmd, err := c.next.Run(ctx, full)
+		if err != nil {
+			return out, cmd, 0, err
+		}
+		msg, perr := resolver.ParseKdigOutput(out)
+		if perr != nil {
+			return out, cmd, 0, nil
+		}
+		ttl, _ := textTTL(msg)
+		return shortFromText(msg), cmd + " +short", ttl, nil
+	}
+
+	out, cmd, err := c.next.Run(ctx, req)
+	if err != nil {
+		return out, cmd, 0, err
+	}
+	var ttl uint32
+	if req.AsJSON {
+		ttl, _ = jsonTTL(out)
+	} else if msg, perr := resolver.ParseKdigOutput(out); perr == nil {
+		ttl, _ = textTTL(msg)
+	}
+	return out, cmd, ttl, nil
+}
+
+func (c *Cache) get(key string) ([]byte, string, uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, "", 0, false
+	}
+	e, _ := elem.Value.(*entry)
+	remaining := e.expires.Sub(c.Now())
+	if remaining <= 0 {
+		c.remove(elem)
+		c.stats.Misses++
+		return nil, "", 0, false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return e.out, e.cmd, uint32((remaining + time.Second - 1) / time.Second), true
+}
+
+func (c *Cache) put(key string, out []byte, cmd string, ttl uint32) {
+	lifetime := min(time.Duration(ttl)*time.Second, c.cfg.MaxTTL)
+	e := &entry{key: key, out: out, cmd: cmd, expires: c.Now().Add(lifetime)}
+	if e.size() > c.cfg.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	c.items[key] = c.lru.PushFront(e)
+	c.bytes += e.size()
+	for c.lru.Len() > c.cfg.MaxEntries || c.bytes > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove drops elem; the caller must hold c.mu.
+func (c *Cache) remove(elem *list.Element) {
+	e, _ := elem.Value.(*entry)
+	c.lru.Remove(elem)
+	delete(c.items, e.key)
+	c.bytes -= e.size()
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Bytes = c.bytes
+	return stats
+}
+
+// cacheKey identifies a request by all of its fields except NoCache, with
+// the record type in its canonical spelling.
+func cacheKey(req api.RequestPayload) (string, error) {
+	req.NoCache = false
+	req.Type = rrtype.Canonical(req.Type)
+	key, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("cache key: %w", err)
+	}
+	return string(key), nil
+}
@@ -0,0 +1,233 @@
+package cache_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/cache"
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+const positiveOutput = `;; ->>HEADER<<- opcode: QUERY; status: NOERROR; id: 1
+;; Flags: qr rd ra; QUERY: 1; ANSWER: 2; AUTHORITY: 0; ADDITIONAL: 0
+
+;; QUESTION SECTION:
+;; example.com.        		IN	A
+
+;; ANSWER SECTION:
+example.com.        	300	IN	A	192.0.2.1
+example.com.        	60	IN	A	192.0.2.2
+`
+
+const nxdomainOutput = `;; ->>HEADER<<- opcode: QUERY; status: NXDOMAIN; id: 1
+;; Flags: qr rd ra; QUERY: 1; ANSWER: 0; AUTHORITY: 1; ADDITIONAL: 0
+
+;; QUESTION SECTION:
+;; nope.example.com.        		IN	A
+
+;; AUTHORITY SECTION:
+example.com.        	3600	IN	SOA	ns.example.com. admin.example.com. 1 7200 3600 1209600 120
+`
+
+const servfailOutput = `;; ->>HEADER<<- opcode: QUERY; status: SERVFAIL; id: 1
+;; Flags: qr rd ra; QUERY: 1; ANSWER: 0; AUTHORITY: 0; ADDITIONAL: 0
+`
+
+const jsonOutput = `{"RCODE":0,"answerRRs":[{"NAME":"example.com.","TYPEname":"A","TTL":30,"rdataA":"192.0.2.1"}]}`
+
+// countingResolver returns fixed output and counts calls.
+type countingResolver struct {
+	out   string
+	calls int
+	last  api.RequestPayload
+}
+
+func (r *countingResolver) Run(_ context.Context, req api.RequestPayload) ([]byte, string, error) {
+	r.calls++
+	r.last = req
+	return []byte(r.out), "kdig @" + req.Nameserver + " " + req.Name, nil
+}
+
+func (r *countingResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func request(name string) api.RequestPayload {
+	return api.RequestPayload{
+		Nameserver:       "1.1.1.1",
+		Short:            false,
+		DNSSEC:           false,
+		Type:             "A",
+		Transport:        "",
+		Name:             name,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+}
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newCache(next resolver.Resolver, cfg cache.Config) (*cache.Cache, *clock) {
+	c := cache.New(next, cfg)
+	clk := &clock{now: time.Unix(1700000000, 0)}
+	c.Now = clk.Now
+	return c, clk
+}
+
+func TestCacheHitUsesMinimumTTL(t *testing.T) {
+	next := &countingResolver{out: positiveOutput, calls: 0, last: request("")}
+	c, clk := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	if _, _, err := c.Run(context.Background(), request("example.com")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clk.now = clk.now.Add(20 * time.Second)
+	ctx, meta := resolver.WithMeta(context.Background())
+	out, _, err := c.Run(ctx, request("example.com"))
+	if err != nil || string(out) != positiveOutput {
+		t.Fatalf("unexpected cached result: %q %v", out, err)
+	}
+	if next.calls != 1 || !meta.Cached || meta.TTL != 40 {
+		t.Fatalf("expected hit with 40s left, got calls=%d meta=%+v", next.calls, meta)
+	}
+
+	clk.now = clk.now.Add(41 * time.Second)
+	ctx, meta = resolver.WithMeta(context.Background())
+	if _, _, err := c.Run(ctx, request("example.com")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next.calls != 2 || meta.Cached {
+		t.Fatalf("expected expiry after min TTL, got calls=%d meta=%+v", next.calls, meta)
+	}
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheNegativeUsesSOAMinimum(t *testing.T) {
+	next := &countingResolver{out: nxdomainOutput, calls: 0, last: request("")}
+	c, clk := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	_, _, _ = c.Run(context.Background(), request("nope.example.com"))
+	clk.now = clk.now.Add(119 * time.Second)
+	_, _, _ = c.Run(context.Background(), request("nope.example.com"))
+	if next.calls != 1 {
+		t.Fatalf("expected NXDOMAIN to be cached, got %d calls", next.calls)
+	}
+	clk.now = clk.now.Add(2 * time.Second)
+	_, _, _ = c.Run(context.Background(), request("nope.example.com"))
+	if next.calls != 2 {
+		t.Fatalf("expected expiry after SOA minimum, got %d calls", next.calls)
+	}
+}
+
+func TestCacheSkipsUncacheable(t *testing.T) {
+	next := &countingResolver{out: servfailOutput, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	_, _, _ = c.Run(context.Background(), request("example.com"))
+	_, _, _ = c.Run(context.Background(), request("example.com"))
+	if next.calls != 2 {
+		t.Fatalf("expected SERVFAIL not to be cached, got %d calls", next.calls)
+	}
+}
+
+func TestCacheNoCacheBypassesLookup(t *testing.T) {
+	next := &countingResolver{out: positiveOutput, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	_, _, _ = c.Run(context.Background(), request("example.com"))
+	bypass := request("example.com")
+	bypass.NoCache = true
+	_, _, _ = c.Run(context.Background(), bypass)
+	_, _, _ = c.Run(context.Background(), request("example.com"))
+	if next.calls != 2 {
+		t.Fatalf("expected bypass to query once more, got %d calls", next.calls)
+	}
+	if stats := c.Stats(); stats.Bypassed != 1 || stats.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheShortDerivedFromText(t *testing.T) {
+	next := &countingResolver{out: positiveOutput, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	req := request("example.com")
+	req.Short = true
+	out, cmd, err := c.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next.last.Short {
+		t.Fatalf("expected the backend to be queried in text mode")
+	}
+	if string(out) != "192.0.2.1\n192.0.2.2\n" || !strings.HasSuffix(cmd, " +short") {
+		t.Fatalf("unexpected short output %q / %q", out, cmd)
+	}
+	_, _, _ = c.Run(context.Background(), req)
+	if next.calls != 1 {
+		t.Fatalf("expected short answer to be cached, got %d calls", next.calls)
+	}
+}
+
+func TestCacheShortUnparsableRunsOnce(t *testing.T) {
+	const warning = ";; WARNING: failed to query server 192.0.2.53@53(UDP)\n"
+	next := &countingResolver{out: warning, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	req := request("example.com")
+	req.Short = true
+	out, _, err := c.Run(context.Background(), req)
+	if err != nil || string(out) != warning {
+		t.Fatalf("expected the output as is, got %q %v", out, err)
+	}
+	if next.calls != 1 {
+		t.Fatalf("expected a single backend run, got %d", next.calls)
+	}
+	_, _, _ = c.Run(context.Background(), req)
+	if next.calls != 2 {
+		t.Fatalf("expected unparsable output not to be cached, got %d calls", next.calls)
+	}
+}
+
+func TestCacheKeyIgnoresTypeCase(t *testing.T) {
+	next := &countingResolver{out: positiveOutput, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 10, MaxBytes: 0, MaxTTL: 0})
+
+	lower := request("example.com")
+	lower.Type = "a"
+	_, _, _ = c.Run(context.Background(), request("example.com"))
+	ctx, meta := resolver.WithMeta(context.Background())
+	_, _, _ = c.Run(ctx, lower)
+	if next.calls != 1 || !meta.Cached {
+		t.Fatalf("expected type a to hit the entry of A, got calls=%d meta=%+v", next.calls, meta)
+	}
+}
+
+func TestCacheJSONAndEviction(t *testing.T) {
+	next := &countingResolver{out: jsonOutput, calls: 0, last: request("")}
+	c, _ := newCache(next, cache.Config{MaxEntries: 1, MaxBytes: 0, MaxTTL: 0})
+
+	a, b := request("a.example.com"), request("b.example.com")
+	a.AsJSON, b.AsJSON = true, true
+	_, _, _ = c.Run(context.Background(), a)
+	_, _, _ = c.Run(context.Background(), b)
+	_, _, _ = c.Run(context.Background(), b)
+	_, _, _ = c.Run(context.Background(), a)
+	if next.calls != 3 {
+		t.Fatalf("expected a to be evicted by b, got %d calls", next.calls)
+	}
+	if stats := c.Stats(); stats.Evictions != 2 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
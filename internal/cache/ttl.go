@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+// rrTTL is the subset of a record needed to compute cache lifetimes.
+type rrTTL struct {
+	Type  string
+	TTL   uint32
+	RData string
+}
+
+// answerTTL returns how long a response may be cached: the minimum TTL of the
+// answer records for positive answers, and for NXDOMAIN/NODATA the minimum of
+// the SOA TTL and SOA MINIMUM field (RFC 2308 section 5). Other responses,
+// and negative answers without SOA, are not cacheable.
+func answerTTL(rcode string, answer, authority []rrTTL) (uint32, bool) {
+	switch {
+	case rcode == "NOERROR" && len(answer) > 0:
+		lowest := answer[0].TTL
+		for _, rr := range answer[1:] {
+			lowest = min(lowest, rr.TTL)
+		}
+		return lowest, true
+	case rcode == "NOERROR" || rcode == "NXDOMAIN":
+		for _, rr := range authority {
+			if rr.Type != "SOA" {
+				continue
+			}
+			fields := strings.Fields(rr.RData)
+			if len(fields) == 0 {
+				return 0, false
+			}
+			minimum, err := strconv.ParseUint(fields[len(fields)-1], 10, 32)
+			if err != nil {
+				return 0, false
+			}
+			return min(rr.TTL, uint32(minimum)), true
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
+
+// textTTL computes the cache lifetime of kdig-style text output.
+func textTTL(msg *resolver.Message) (uint32, bool) {
+	convert := func(records []resolver.Record) []rrTTL {
+		out := make([]rrTTL, 0, len(records))
+		for _, rec := range records {
+			out = append(out, rrTTL{Type: rec.Type, TTL: rec.TTL, RData: rec.RData})
+		}
+		return out
+	}
+	return answerTTL(msg.Header.Rcode, convert(msg.Answer), convert(msg.Authority))
+}
+
+// jsonTTL computes the cache lifetime of RFC 8427 output (kdig +json).
+func jsonTTL(out []byte) (uint32, bool) {
+	type jsonRR map[string]interface{}
+	var msg struct {
+		RCODE     int      `json:"RCODE"`
+		Answer    []jsonRR `json:"answerRRs"`
+		Authority []jsonRR `json:"authorityRRs"`
+	}
+	if err := json.Unmarshal(out, &msg); err != nil {
+		return 0, false
+	}
+	convert := func(records []jsonRR) []rrTTL {
+		result := make([]rrTTL, 0, len(records))
+		for _, rec := range records {
+			typeName, _ := rec["TYPEname"].(string)
+			ttl, _ := rec["TTL"].(float64)
+			rdata, _ := rec["rdata"+typeName].(string)
+			result = append(result, rrTTL{Type: typeName, TTL: uint32(ttl), RData: rdata})
+		}
+		return result
+	}
+	return answerTTL(dns.RcodeToString[msg.RCODE], convert(msg.Answer), convert(msg.Authority))
+}
+
+// shortFromText renders the answer section like kdig +short: one RDATA per
+// line.
+func shortFromText(msg *resolver.Message) []byte {
+	var b strings.Builder
+	for _, rec := range msg.Answer {
+		b.WriteString(rec.RData)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
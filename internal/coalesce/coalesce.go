@@ -0,0 +1,117 @@
+// Package coalesce deduplicates identical in-flight resolver queries so that
+// concurrent callers share a single upstream execution.
+package coalesce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+// Stats is a snapshot of the coalescing counters.
+type Stats struct {
+	// Requests is the number of queries received.
+	Requests uint64 `json:"requests"`
+	// Executions is the number of queries actually sent upstream.
+	Executions uint64 `json:"executions"`
+	// Coalesced is the number of queries answered by another caller's
+	// execution.
+	Coalesced uint64 `json:"coalesced"`
+	// Ratio is Coalesced / Requests.
+	Ratio float64 `json:"ratio"`
+}
+
+type result struct {
+	out []byte
+	cmd string
+}
+
+// Coalescer is a resolver.Resolver that shares one execution of next among
+// all concurrent callers issuing an identical RequestPayload.
+type Coalescer struct {
+	next  resolver.Resolver
+	group singleflight.Group
+
+	requests   atomic.Uint64
+	executions atomic.Uint64
+}
+
+// New wraps next with request coalescing.
+func New(next resolver.Resolver) *Coalescer {
+	return &Coalescer{
+		next:       next,
+		group:      singleflight.Group{},
+		requests:   atomic.Uint64{},
+		executions: atomic.Uint64{},
+	}
+}
+
+// QueryTimeout reports the timeout of the wrapped resolver.
+func (c *Coalescer) QueryTimeout() time.Duration {
+	return c.next.QueryTimeout()
+}
+
+// Run executes req, joining an identical in-flight execution if there is one.
+// The shared execution is detached from the first caller's cancellation so
+// that one client going away does not fail the others, and bounded by the
+// query timeout of next instead; each caller still stops waiting when its
+// own ctx is done. Sharing is reported through the
+// resolver.Meta attached to ctx.
+func (c *Coalescer) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
+	c.requests.Add(1)
+	key, err := coalesceKey(req)
+	if err != nil {
+		c.executions.Add(1)
+		return c.next.Run(ctx, req)
+	}
+
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.next.QueryTimeout()+1*time.Second)
+		defer cancel()
+		c.executions.Add(1)
+		out, cmd, runErr := c.next.Run(runCtx, req)
+		return result{out: out, cmd: cmd}, runErr
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, "", fmt.Errorf("waiting for query: %w", ctx.Err())
+	case res := <-ch:
+		if meta := resolver.MetaFromContext(ctx); meta != nil && res.Shared {
+			meta.Shared = true
+		}
+		r, _ := res.Val.(result)
+		return r.out, r.cmd, res.Err //nolint:wrapcheck // errors of the wrapped resolver are passed through
+	}
+}
+
+// Stats returns a snapshot of the coalescing counters.
+func (c *Coalescer) Stats() Stats {
+	requests, executions := c.requests.Load(), c.executions.Load()
+	stats := Stats{Requests: requests, Executions: executions, Coalesced: 0, Ratio: 0}
+	if requests > executions {
+		stats.Coalesced = requests - executions
+	}
+	if requests > 0 {
+		stats.Ratio = float64(stats.Coalesced) / float64(requests)
+	}
+	return stats
+}
+
+// coalesceKey identifies a request by all fields except NoCache, which only
+// affects the cache layer above.
+func coalesceKey(req api.RequestPayload) (string, error) {
+	req.NoCache = false
+	key, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("coalesce key: %w", err)
+	}
+	return string(key), nil
+}
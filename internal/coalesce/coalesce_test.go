@@ -0,0 +1,183 @@
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/coalesce"
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+// gatedResolver blocks every Run until release is closed.
+type gatedResolver struct {
+	release chan struct{}
+	mu      sync.Mutex
+	calls   int
+}
+
+func (r *gatedResolver) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
+	r.mu.Lock()
+	r.calls++
+	r.mu.Unlock()
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	return []byte("192.0.2.1\n"), "kdig " + req.Name, nil
+}
+
+func (r *gatedResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+// stuckResolver never answers and waits for its context instead.
+type stuckResolver struct{}
+
+func (stuckResolver) Run(ctx context.Context, _ api.RequestPayload) ([]byte, string, error) {
+	<-ctx.Done()
+	return nil, "", ctx.Err()
+}
+
+func (stuckResolver) QueryTimeout() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func request() api.RequestPayload {
+	return api.RequestPayload{
+		Nameserver:       "1.1.1.1",
+		Short:            true,
+		DNSSEC:           false,
+		Type:             "A",
+		Transport:        "",
+		Name:             "example.com",
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+}
+
+// waitForRequests polls until n requests have reached the coalescer and gives
+// them a moment to join the in-flight execution.
+func waitForRequests(t *testing.T, c *coalesce.Coalescer, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Stats().Requests < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d requests", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestCoalescerSharesExecution(t *testing.T) {
+	next := &gatedResolver{release: make(chan struct{}), mu: sync.Mutex{}, calls: 0}
+	c := coalesce.New(next)
+
+	const callers = 8
+	var wg sync.WaitGroup
+	metas := make([]*resolver.Meta, callers)
+	outs := make([]string, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, meta := resolver.WithMeta(context.Background())
+			out, _, err := c.Run(ctx, request())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			metas[i], outs[i] = meta, string(out)
+		}()
+	}
+	waitForRequests(t, c, callers)
+	close(next.release)
+	wg.Wait()
+
+	if next.calls != 1 {
+		t.Fatalf("expected one upstream execution, got %d", next.calls)
+	}
+	for i := range callers {
+		if outs[i] != "192.0.2.1\n" || !metas[i].Shared {
+			t.Fatalf("caller %d: unexpected result %q shared=%v", i, outs[i], metas[i].Shared)
+		}
+	}
+	stats := c.Stats()
+	if stats.Requests != callers || stats.Executions != 1 || stats.Coalesced != callers-1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCoalescerCallerCancellation(t *testing.T) {
+	next := &gatedResolver{release: make(chan struct{}), mu: sync.Mutex{}, calls: 0}
+	c := coalesce.New(next)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, _, err := c.Run(leaderCtx, request())
+		leaderErr <- err
+	}()
+	waitForRequests(t, c, 1)
+
+	followerOut := make(chan string, 1)
+	go func() {
+		out, _, _ := c.Run(context.Background(), request())
+		followerOut <- string(out)
+	}()
+	waitForRequests(t, c, 2)
+
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected leader to be canceled, got %v", err)
+	}
+	close(next.release)
+	if out := <-followerOut; out != "192.0.2.1\n" {
+		t.Fatalf("follower should still get the shared answer, got %q", out)
+	}
+}
+
+func TestCoalescerBoundsSharedExecution(t *testing.T) {
+	c := coalesce.New(stuckResolver{})
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.Run(context.Background(), request())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the shared execution to time out, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared execution was not bounded by the query timeout")
+	}
+}
+
+func TestCoalescerSequentialNotShared(t *testing.T) {
+	next := &gatedResolver{release: make(chan struct{}), mu: sync.Mutex{}, calls: 0}
+	close(next.release)
+	c := coalesce.New(next)
+
+	for range 2 {
+		ctx, meta := resolver.WithMeta(context.Background())
+		if _, _, err := c.Run(ctx, request()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if meta.Shared {
+			t.Fatalf("sequential queries must not be reported as shared")
+		}
+	}
+	if next.calls != 2 {
+		t.Fatalf("expected two executions, got %d", next.calls)
+	}
+}
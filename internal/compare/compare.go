@@ -0,0 +1,162 @@
+// Package compare runs one query against several nameservers concurrently and
+// computes a normalized diff of their answers.
+package compare
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/pool"
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+// Run queries every server of req concurrently through res and returns one
+// result per server, in request order.
+func Run(ctx context.Context, res resolver.Resolver, req api.CompareRequest) []api.CompareResult {
+	results := make([]api.CompareResult, len(req.Servers))
+	var wg sync.WaitGroup
+	for i, server := range req.Servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = runOne(ctx, res, req, server)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runOne(ctx context.Context, res resolver.Resolver, req api.CompareRequest, server api.CompareServer) api.CompareResult {
+	result := api.CompareResult{
+		Nameserver: server.Nameserver,
+		Transport:  server.Transport,
+		Command:    "",
+		Success:    false,
+		Error:      "",
+		Rcode:      "",
+		Answers:    []string{},
+		MinTTL:     0,
+		MaxTTL:     0,
+		RTTMillis:  0,
+		Overloaded: false,
+	}
+	out, cmd, err := res.Run(ctx, req.QueryFor(server))
+	result.Command = cmd
+	if err != nil {
+		result.Error = err.Error()
+		result.Overloaded = pool.Overloaded(err)
+		return result
+	}
+	msg, err := resolver.ParseKdigOutput(out)
+	if err != nil {
+		result.Error = fmt.Sprintf("parse answer: %v", err)
+		return result
+	}
+
+	result.Success = true
+	result.Rcode = msg.Header.Rcode
+	if msg.Timing != nil {
+		result.RTTMillis = msg.Timing.RTTMillis
+	}
+	for i, rec := range msg.Answer {
+		result.Answers = append(result.Answers, Normalize(rec))
+		if i == 0 || rec.TTL < result.MinTTL {
+			result.MinTTL = rec.TTL
+		}
+		if rec.TTL > result.MaxTTL {
+			result.MaxTTL = rec.TTL
+		}
+	}
+	slices.Sort(result.Answers)
+	result.Answers = slices.Compact(result.Answers)
+	return result
+}
+
+// Normalize renders rec as "name TYPE rdata" without TTL and class. Owner
+// names, and RDATA of types whose RDATA consists of domain names, are
+// lowercased since DNS names compare case-insensitively.
+func Normalize(rec resolver.Record) string {
+	rdata := strings.Join(strings.Fields(rec.RData), " ")
+	switch strings.ToUpper(rec.Type) {
+	case "NS", "CNAME", "PTR", "DNAME", "MX", "SRV":
+		rdata = strings.ToLower(rdata)
+	default:
+	}
+	return strings.ToLower(rec.Name) + " " + strings.ToUpper(rec.Type) + " " + rdata
+}
+
+// Diff compares the successful results. Failed results are ignored.
+func Diff(results []api.CompareResult) *api.CompareDiff {
+	diff := &api.CompareDiff{
+		Agree:         false,
+		Consensus:     []string{},
+		Differences:   nil,
+		Rcodes:        make(map[string][]string),
+		RcodeMismatch: false,
+		TTL:           api.TTLSpread{Min: 0, Max: 0, Spread: 0},
+	}
+
+	var ok []api.CompareResult
+	for _, r := range results {
+		if r.Success {
+			ok = append(ok, r)
+		}
+	}
+	if len(ok) == 0 {
+		return diff
+	}
+
+	counts := make(map[string]int)
+	ttlSeen := false
+	for _, r := range ok {
+		diff.Rcodes[r.Rcode] = append(diff.Rcodes[r.Rcode], r.Nameserver)
+		for _, a := range r.Answers {
+			counts[a]++
+		}
+		if len(r.Answers) == 0 {
+			continue
+		}
+		if !ttlSeen || r.MinTTL < diff.TTL.Min {
+			diff.TTL.Min = r.MinTTL
+		}
+		if r.MaxTTL > diff.TTL.Max {
+			diff.TTL.Max = r.MaxTTL
+		}
+		ttlSeen = true
+	}
+	diff.TTL.Spread = diff.TTL.Max - diff.TTL.Min
+	diff.RcodeMismatch = len(diff.Rcodes) > 1
+
+	union := make([]string, 0, len(counts))
+	for a, n := range counts {
+		union = append(union, a)
+		if n == len(ok) {
+			diff.Consensus = append(diff.Consensus, a)
+		}
+	}
+	slices.Sort(union)
+	slices.Sort(diff.Consensus)
+
+	for _, r := range ok {
+		sd := api.ServerDiff{Nameserver: r.Nameserver, Missing: nil, Extra: nil}
+		for _, a := range union {
+			has := slices.Contains(r.Answers, a)
+			switch {
+			case !has:
+				sd.Missing = append(sd.Missing, a)
+			case counts[a] < len(ok):
+				sd.Extra = append(sd.Extra, a)
+			default:
+			}
+		}
+		if len(sd.Missing) > 0 || len(sd.Extra) > 0 {
+			diff.Differences = append(diff.Differences, sd)
+		}
+	}
+	diff.Agree = !diff.RcodeMismatch && len(diff.Differences) == 0
+	return diff
+}
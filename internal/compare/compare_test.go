@@ -0,0 +1,105 @@
+package compare_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/compare"
+)
+
+// stubResolver returns canned kdig output keyed by nameserver.
+type stubResolver struct {
+	outputs map[string]string
+}
+
+func (s stubResolver) Run(_ context.Context, req api.RequestPayload) ([]byte, string, error) {
+	out, ok := s.outputs[req.Nameserver]
+	if !ok {
+		return nil, "kdig @" + req.Nameserver, errors.New("connection refused")
+	}
+	return []byte(out), "kdig @" + req.Nameserver, nil
+}
+
+func (s stubResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func kdigOutput(rcode string, answers ...string) string {
+	var b strings.Builder
+	b.WriteString(";; ->>HEADER<<- opcode: QUERY; status: " + rcode + "; id: 1\n")
+	b.WriteString(";; Flags: qr rd ra; QUERY: 1; ANSWER: 1; AUTHORITY: 0; ADDITIONAL: 0\n\n")
+	b.WriteString(";; ANSWER SECTION:\n")
+	for _, a := range answers {
+		b.WriteString(a + "\n")
+	}
+	b.WriteString("\n;; From 1.1.1.1@53(UDP) in 2.0 ms\n")
+	return b.String()
+}
+
+func TestRunAndDiff(t *testing.T) {
+	res := stubResolver{outputs: map[string]string{
+		"a": kdigOutput("NOERROR", "Example.com.\t300\tIN\tA\t192.0.2.1", "example.com.\t300\tIN\tA\t192.0.2.2"),
+		"b": kdigOutput("NOERROR", "example.com.\t60\tIN\tA\t192.0.2.1"),
+		"c": kdigOutput("SERVFAIL"),
+	}}
+	req := api.CompareRequest{
+		Servers: []api.CompareServer{
+			{Nameserver: "a", Transport: ""},
+			{Nameserver: "b", Transport: "tcp"},
+			{Nameserver: "c", Transport: ""},
+			{Nameserver: "down", Transport: ""},
+		},
+		Name:   "example.com",
+		Type:   "A",
+		DNSSEC: false,
+	}
+
+	results := compare.Run(context.Background(), res, req)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if results[3].Success || results[3].Error == "" {
+		t.Fatalf("expected failure for unreachable server, got %+v", results[3])
+	}
+	if got := results[0].Answers; len(got) != 2 || got[0] != "example.com. A 192.0.2.1" {
+		t.Fatalf("unexpected normalized answers: %v", got)
+	}
+
+	diff := compare.Diff(results)
+	if diff.Agree {
+		t.Fatalf("expected disagreement")
+	}
+	if !diff.RcodeMismatch || len(diff.Rcodes["NOERROR"]) != 2 || len(diff.Rcodes["SERVFAIL"]) != 1 {
+		t.Fatalf("unexpected rcodes: %+v", diff.Rcodes)
+	}
+	if len(diff.Consensus) != 0 {
+		t.Fatalf("expected empty consensus, got %v", diff.Consensus)
+	}
+	if diff.TTL.Min != 60 || diff.TTL.Max != 300 || diff.TTL.Spread != 240 {
+		t.Fatalf("unexpected ttl spread: %+v", diff.TTL)
+	}
+	if len(diff.Differences) != 3 {
+		t.Fatalf("expected 3 differing servers, got %+v", diff.Differences)
+	}
+}
+
+func TestDiffAgree(t *testing.T) {
+	results := []api.CompareResult{
+		{
+			Nameserver: "a", Transport: "", Command: "", Success: true, Error: "", Rcode: "NOERROR",
+			Answers: []string{"example.com. A 192.0.2.1"}, MinTTL: 30, MaxTTL: 30, RTTMillis: 1, Overloaded: false,
+		},
+		{
+			Nameserver: "b", Transport: "", Command: "", Success: true, Error: "", Rcode: "NOERROR",
+			Answers: []string{"example.com. A 192.0.2.1"}, MinTTL: 20, MaxTTL: 20, RTTMillis: 1, Overloaded: false,
+		},
+	}
+	diff := compare.Diff(results)
+	if !diff.Agree || len(diff.Consensus) != 1 || diff.TTL.Spread != 10 {
+		t.Fatalf("unexpected diff: %+v", diff)
+	}
+}
@@ -0,0 +1,309 @@
+// Package config loads the service configuration from defaults, an optional
+// YAML or TOML file, environment variables and command-line flags, in that
+// order of precedence, and validates it before anything is started.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/exiguus/wdns/internal/cache"
+	"github.com/exiguus/wdns/internal/pool"
+)
+
+// Defaults of the /batch settings, also applied by the handlers to zero
+// values.
+const (
+	DefaultBatchConcurrency = 4
+	DefaultBatchMaxItems    = 1000
+)
+
+const (
+	defaultMaxOutput = 32 * 1024
+	defaultRPS       = 10
+	defaultBurst     = 20
+	defaultSample    = 1.0
+)
+
+// ErrInvalid is wrapped by every validation error.
+var ErrInvalid = errors.New("invalid configuration")
+
+// Config is the complete service configuration.
+type Config struct {
+	Server    Server    `toml:"server"     yaml:"server"`
+	TLS       TLS       `toml:"tls"        yaml:"tls"`
+	Resolver  Resolver  `toml:"resolver"   yaml:"resolver"`
+	RateLimit RateLimit `toml:"rate_limit" yaml:"rate_limit"`
+	Batch     Batch     `toml:"batch"      yaml:"batch"`
+	Cache     Cache     `toml:"cache"      yaml:"cache"`
+	Policy    Policy    `toml:"policy"     yaml:"policy"`
+	DoH       DoH       `toml:"doh"        yaml:"doh"`
+	DNSSEC    DNSSEC    `toml:"dnssec"     yaml:"dnssec"`
+	Trace     Trace     `toml:"trace"      yaml:"trace"`
+	Auth      Auth      `toml:"auth"       yaml:"auth"`
+	Metrics   Metrics   `toml:"metrics"    yaml:"metrics"`
+	Tracing   Tracing   `toml:"tracing"    yaml:"tracing"`
+	Health    Health    `toml:"health"     yaml:"health"`
+}
+
+// Server configures the HTTP listener and client identification.
+type Server struct {
+	// Listen is the host:port, or "unix:" followed by a socket path, the
+	// server listens on.
+	Listen string `toml:"listen" yaml:"listen"`
+	// AdminListen moves /stats, /metrics and /admin/reload to a separate
+	// listener when set.
+	AdminListen string `toml:"admin_listen" yaml:"admin_listen"`
+	// SocketMode, SocketUser and SocketGroup set the permissions and owner
+	// of Unix domain sockets.
+	SocketMode  string `toml:"socket_mode"  yaml:"socket_mode"`
+	SocketUser  string `toml:"socket_user"  yaml:"socket_user"`
+	SocketGroup string `toml:"socket_group" yaml:"socket_group"`
+	// ProxyProtocol is "off", "optional" or "required".
+	ProxyProtocol string `toml:"proxy_protocol" yaml:"proxy_protocol"`
+	// TrustedProxies are CIDRs allowed to set forwarding headers and send
+	// PROXY protocol headers.
+	TrustedProxies []string `toml:"trusted_proxies" yaml:"trusted_proxies"`
+	// ClientIPHeaders are the forwarding headers trusted proxies set.
+	ClientIPHeaders []string `toml:"client_ip_headers" yaml:"client_ip_headers"`
+	// DrainDelay is how long the server keeps serving, while reporting
+	// unready, between a shutdown signal and closing its listener.
+	DrainDelay time.Duration `toml:"drain_delay" yaml:"drain_delay"`
+	// ShutdownTimeout bounds the wait for in-flight requests after draining.
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+// TLS configures HTTPS. The server speaks plain HTTP unless CertFile and
+// KeyFile are set.
+type TLS struct {
+	CertFile string `toml:"cert_file" yaml:"cert_file"`
+	KeyFile  string `toml:"key_file"  yaml:"key_file"`
+	// ReloadInterval is how often the files are checked for changes.
+	ReloadInterval time.Duration `toml:"reload_interval" yaml:"reload_interval"`
+	// MinVersion is the lowest accepted TLS version: 1.0, 1.1, 1.2 or 1.3.
+	MinVersion string `toml:"min_version" yaml:"min_version"`
+	// CipherSuites restricts the TLS 1.2 cipher suites by name.
+	CipherSuites []string `toml:"cipher_suites" yaml:"cipher_suites"`
+	// HTTP2 offers HTTP/2 through ALPN.
+	HTTP2 bool `toml:"http2" yaml:"http2"`
+	// ClientCAFile enables client certificate authentication against the
+	// CA bundle it names.
+	ClientCAFile string `toml:"client_ca_file" yaml:"client_ca_file"`
+	// ClientAuth is "optional" or "require".
+	ClientAuth string `toml:"client_auth" yaml:"client_auth"`
+	// AdminClientAuth is the client certificate mode of the admin
+	// listener, "optional" or "require".
+	AdminClientAuth string `toml:"admin_client_auth" yaml:"admin_client_auth"`
+}
+
+// Resolver configures the query backend and execution pool.
+type Resolver struct {
+	Backend        string        `toml:"backend"         yaml:"backend"`
+	Timeout        time.Duration `toml:"timeout"         yaml:"timeout"`
+	MaxOutput      int           `toml:"max_output"      yaml:"max_output"`
+	MaxConcurrency int           `toml:"max_concurrency" yaml:"max_concurrency"`
+	MaxQueue       int           `toml:"max_queue"       yaml:"max_queue"`
+	QueueTimeout   time.Duration `toml:"queue_timeout"   yaml:"queue_timeout"`
+	UpstreamsFile  string        `toml:"upstreams_file"  yaml:"upstreams_file"`
+}
+
+// RateLimit configures the per-client token bucket, which is also the
+// default limit of API keys.
+type RateLimit struct {
+	RPS   float64 `toml:"rps"   yaml:"rps"`
+	Burst int     `toml:"burst" yaml:"burst"`
+}
+
+// Batch configures /batch.
+type Batch struct {
+	Concurrency int `toml:"concurrency" yaml:"concurrency"`
+	MaxItems    int `toml:"max_items"   yaml:"max_items"`
+}
+
+// Cache configures the response cache; MaxEntries 0 disables it.
+type Cache struct {
+	MaxEntries int           `toml:"max_entries" yaml:"max_entries"`
+	MaxBytes   int           `toml:"max_bytes"   yaml:"max_bytes"`
+	MaxTTL     time.Duration `toml:"max_ttl"     yaml:"max_ttl"`
+}
+
+// Policy configures the nameserver policy.
+type Policy struct {
+	AllowCIDRs   []string `toml:"allow_cidrs"   yaml:"allow_cidrs"`
+	DenyCIDRs    []string `toml:"deny_cidrs"    yaml:"deny_cidrs"`
+	AllowHosts   []string `toml:"allow_hosts"   yaml:"allow_hosts"`
+	DenyHosts    []string `toml:"deny_hosts"    yaml:"deny_hosts"`
+	AllowedPorts []int    `toml:"allowed_ports" yaml:"allowed_ports"`
+}
+
+// DoH configures the /dns-query and /resolve upstream.
+type DoH struct {
+	Upstream  string `toml:"upstream"  yaml:"upstream"`
+	Transport string `toml:"transport" yaml:"transport"`
+}
+
+// DNSSEC configures validation.
+type DNSSEC struct {
+	TrustAnchorFile string `toml:"trust_anchor_file" yaml:"trust_anchor_file"`
+}
+
+// Trace configures delegation traces.
+type Trace struct {
+	RootHints []string `toml:"root_hints" yaml:"root_hints"`
+}
+
+// Auth configures API keys; either KeysFile or the inline JSON Keys.
+type Auth struct {
+	KeysFile string `toml:"api_keys_file" yaml:"api_keys_file"`
+	Keys     string `toml:"api_keys"      yaml:"api_keys"`
+}
+
+// Metrics configures GET /metrics.
+type Metrics struct {
+	Enabled bool `toml:"enabled" yaml:"enabled"`
+}
+
+// Tracing configures OpenTelemetry trace export.
+type Tracing struct {
+	Exporter     string  `toml:"exporter"      yaml:"exporter"`
+	OTLPEndpoint string  `toml:"otlp_endpoint" yaml:"otlp_endpoint"`
+	File         string  `toml:"file"          yaml:"file"`
+	SampleRatio  float64 `toml:"sample_ratio"  yaml:"sample_ratio"`
+}
+
+// Health configures the readiness checks of /readyz. The canary query is
+// only sent when CanaryNameserver is set.
+type Health struct {
+	CanaryNameserver string        `toml:"canary_nameserver" yaml:"canary_nameserver"`
+	CanaryTransport  string        `toml:"canary_transport"  yaml:"canary_transport"`
+	CanaryName       string        `toml:"canary_name"       yaml:"canary_name"`
+	CanaryInterval   time.Duration `toml:"canary_interval"   yaml:"canary_interval"`
+}
+
+// Default returns the built-in configuration.
+func Default() Config {
+	var cfg Config
+	cfg.Server.Listen = ":8080"
+	cfg.Server.ProxyProtocol = "off"
+	cfg.Server.DrainDelay = 5 * time.Second
+	cfg.Server.ShutdownTimeout = 10 * time.Second
+	cfg.TLS.ReloadInterval = 10 * time.Second
+	cfg.TLS.MinVersion = "1.2"
+	cfg.TLS.HTTP2 = true
+	cfg.TLS.ClientAuth = "optional"
+	cfg.TLS.AdminClientAuth = "optional"
+	cfg.Resolver.Backend = "kdig"
+	cfg.Resolver.Timeout = 5 * time.Second
+	cfg.Resolver.MaxOutput = defaultMaxOutput
+	cfg.Resolver.MaxConcurrency = pool.DefaultMaxRunning
+	cfg.Resolver.MaxQueue = pool.DefaultMaxQueued
+	cfg.Resolver.QueueTimeout = pool.DefaultQueueTimeout
+	cfg.RateLimit.RPS = defaultRPS
+	cfg.RateLimit.Burst = defaultBurst
+	cfg.Batch.Concurrency = DefaultBatchConcurrency
+	cfg.Batch.MaxItems = DefaultBatchMaxItems
+	cfg.Cache.MaxBytes = cache.DefaultMaxBytes
+	cfg.Cache.MaxTTL = cache.DefaultMaxTTL
+	cfg.Policy.AllowedPorts = []int{53, 443, 853}
+	cfg.Tracing.Exporter = "none"
+	cfg.Tracing.SampleRatio = defaultSample
+	cfg.Health.CanaryName = "example.com"
+	cfg.Health.CanaryInterval = 30 * time.Second
+	return cfg
+}
+
+// Load builds the configuration from the defaults, the file named by the
+// -config flag or WDNS_CONFIG, the environment (read through lookupEnv) and
+// the remaining flags in args, then validates it. printConfig reports whether
+// -print-config was given.
+func Load(args []string, lookupEnv func(string) (string, bool)) (cfg Config, printConfig bool, err error) {
+	cfg = Default()
+	fields := cfg.fields()
+
+	fs := flag.NewFlagSet("wdns", flag.ContinueOnError)
+	path := fs.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) configuration file (env WDNS_CONFIG)")
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	// flags are recorded first and applied after the file and environment
+	var set []func() error
+	for _, f := range fields {
+		fs.Func(f.name, f.usage+" (env "+f.env+")", func(v string) error {
+			set = append(set, func() error { return f.value.Set(v) })
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, fmt.Errorf("parse flags: %w", err)
+	}
+	if *path == "" {
+		*path, _ = lookupEnv("WDNS_CONFIG")
+	}
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return cfg, false, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		if v, ok := lookupEnv(f.env); ok && v != "" {
+			if err := f.value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.env, err))
+			}
+		}
+	}
+	for _, apply := range set {
+		errs = append(errs, apply())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, false, err
+	}
+	return cfg, printConfig, cfg.Validate()
+}
+
+// loadFile decodes the YAML or TOML file at path into cfg, rejecting unknown
+// keys.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("config file %s: unknown key %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format, use .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Print writes the configuration as YAML.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2) //nolint:mnd // conventional YAML indentation
+	if err := enc.Encode(c); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	return enc.Close() //nolint:wrapcheck // flushes the buffered document
+}
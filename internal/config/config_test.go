@@ -0,0 +1,284 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/config"
+)
+
+// env returns a lookup function backed by vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, printConfig, err := config.Load(nil, env(nil))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if printConfig {
+		t.Fatal("printConfig set without -print-config")
+	}
+	if cfg.Server.Listen != ":8080" || cfg.Resolver.Timeout != 5*time.Second || cfg.RateLimit.Burst != 20 {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "wdns.yaml", `
+rate_limit:
+  rps: 1
+  burst: 2
+batch:
+  max_items: 10
+`)
+	cfg, _, err := config.Load(
+		[]string{"-config", path, "-rate_limit.burst", "5"},
+		env(map[string]string{"RATE_LIMIT_RPS": "3", "RATE_LIMIT_BURST": "4"}),
+	)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Batch.MaxItems != 10 {
+		t.Errorf("file value: got %d, want 10", cfg.Batch.MaxItems)
+	}
+	if cfg.RateLimit.RPS != 3 {
+		t.Errorf("env over file: got %g, want 3", cfg.RateLimit.RPS)
+	}
+	if cfg.RateLimit.Burst != 5 {
+		t.Errorf("flag over env: got %d, want 5", cfg.RateLimit.Burst)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "wdns.toml", `
+[server]
+listen = "127.0.0.1:9000"
+trusted_proxies = ["10.0.0.0/8"]
+
+[resolver]
+queue_timeout = "750ms"
+`)
+	cfg, _, err := config.Load(nil, env(map[string]string{"WDNS_CONFIG": path}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Server.Listen != "127.0.0.1:9000" || len(cfg.Server.TrustedProxies) != 1 {
+		t.Errorf("server: %+v", cfg.Server)
+	}
+	if cfg.Resolver.QueueTimeout != 750*time.Millisecond {
+		t.Errorf("queue_timeout: got %s", cfg.Resolver.QueueTimeout)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	for name, content := range map[string]string{
+		"wdns.yaml": "rate_limit:\n  rsp: 1\n",
+		"wdns.toml": "[rate_limit]\nrsp = 1\n",
+	} {
+		path := writeFile(t, name, content)
+		if _, _, err := config.Load([]string{"-config", path}, env(nil)); err == nil ||
+			!strings.Contains(err.Error(), "rsp") {
+			t.Errorf("%s: got %v, want unknown key error", name, err)
+		}
+	}
+}
+
+func TestLoadLegacyUnits(t *testing.T) {
+	cfg, _, err := config.Load(nil, env(map[string]string{
+		"RESOLVER_QUEUE_TIMEOUT_MS": "1500",
+		"CACHE_MAX_TTL":             "60",
+		"RESOLVER_TIMEOUT":          "2s",
+	}))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Resolver.QueueTimeout != 1500*time.Millisecond {
+		t.Errorf("queue timeout: got %s", cfg.Resolver.QueueTimeout)
+	}
+	if cfg.Cache.MaxTTL != time.Minute {
+		t.Errorf("cache ttl: got %s", cfg.Cache.MaxTTL)
+	}
+	if cfg.Resolver.Timeout != 2*time.Second {
+		t.Errorf("resolver timeout: got %s", cfg.Resolver.Timeout)
+	}
+}
+
+func TestLoadListenAddress(t *testing.T) {
+	cfg, _, err := config.Load(nil, env(map[string]string{"PORT": "9090"}))
+	if err != nil || cfg.Server.Listen != ":9090" {
+		t.Fatalf("PORT: got %q, %v", cfg.Server.Listen, err)
+	}
+	cfg, _, err = config.Load(nil, env(map[string]string{"PORT": "9090", "LISTEN_ADDR": "127.0.0.1:7070"}))
+	if err != nil || cfg.Server.Listen != "127.0.0.1:7070" {
+		t.Fatalf("LISTEN_ADDR: got %q, %v", cfg.Server.Listen, err)
+	}
+	cfg, _, err = config.Load(nil, env(map[string]string{"LISTEN_ADDR": "unix:/run/wdns/api.sock", "ADMIN_LISTEN_ADDR": ":9091"}))
+	if err != nil || cfg.Server.Listen != "unix:/run/wdns/api.sock" || cfg.Server.AdminListen != ":9091" {
+		t.Fatalf("unix socket: got %q %q, %v", cfg.Server.Listen, cfg.Server.AdminListen, err)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	tests := map[string]map[string]string{
+		"malformed number":  {"RATE_LIMIT_RPS": "fast"},
+		"zero rps":          {"RATE_LIMIT_RPS": "0"},
+		"negative timeout":  {"RESOLVER_TIMEOUT": "-1s"},
+		"zero max output":   {"RESOLVER_MAX_OUTPUT": "0"},
+		"negative queue":    {"RESOLVER_MAX_QUEUE": "-1"},
+		"negative cache":    {"CACHE_MAX_BYTES": "-1"},
+		"bad listen":        {"LISTEN_ADDR": "localhost"},
+		"bad port":          {"PORT": "99999"},
+		"empty socket path": {"LISTEN_ADDR": "unix:"},
+		"same admin listen": {"ADMIN_LISTEN_ADDR": ":8080"},
+		"bad socket mode":   {"SOCKET_MODE": "rw-rw----"},
+		"bad trusted proxy": {"TRUSTED_PROXIES": "10.0.0.0/33"},
+		"bad policy cidr":   {"NAMESERVER_DENY_CIDRS": "nope"},
+		"bad policy port":   {"NAMESERVER_ALLOWED_PORTS": "0"},
+		"proxy untrusted":   {"PROXY_PROTOCOL": "required"},
+		"bad backend":       {"RESOLVER_BACKEND": "bind"},
+		"bad exporter":      {"TRACING_EXPORTER": "zipkin"},
+		"bad sample ratio":  {"TRACING_SAMPLE_RATIO": "2"},
+		"bad inline keys":   {"API_KEYS": "{"},
+		"negative drain":    {"SHUTDOWN_DRAIN_DELAY": "-1s"},
+		"cert without key":  {"TLS_CERT_FILE": "cert.pem"},
+		"bad tls version":   {"TLS_MIN_VERSION": "1.4"},
+		"tls 1.3 suite":     {"TLS_CIPHER_SUITES": "TLS_AES_128_GCM_SHA256"},
+		"insecure suite":    {"TLS_CIPHER_SUITES": "TLS_RSA_WITH_RC4_128_SHA"},
+		"ca without tls":    {"TLS_CLIENT_CA_FILE": "ca.pem"},
+		"bad client auth":   {"TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem", "TLS_CLIENT_AUTH": "maybe"},
+		"bad admin auth":    {"TLS_CERT_FILE": "c.pem", "TLS_KEY_FILE": "k.pem", "TLS_ADMIN_CLIENT_AUTH": "maybe"},
+	}
+	for name, vars := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := config.Load(nil, env(vars)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadZeroSelectsDefault(t *testing.T) {
+	vars := map[string]string{
+		"RESOLVER_MAX_CONCURRENCY":  "0",
+		"RESOLVER_MAX_QUEUE":        "0",
+		"RESOLVER_QUEUE_TIMEOUT_MS": "0",
+		"BATCH_CONCURRENCY":         "0",
+		"BATCH_MAX_ITEMS":           "0",
+		"CACHE_MAX_BYTES":           "0",
+		"CACHE_MAX_TTL":             "0",
+	}
+	if _, _, err := config.Load(nil, env(vars)); err != nil {
+		t.Fatalf("zero bounds should select the defaults: %v", err)
+	}
+}
+
+func TestLoadReportsAllErrors(t *testing.T) {
+	_, _, err := config.Load(nil, env(map[string]string{"RATE_LIMIT_RPS": "0", "RESOLVER_MAX_OUTPUT": "0"}))
+	if !errors.Is(err, config.ErrInvalid) {
+		t.Fatalf("got %v, want ErrInvalid", err)
+	}
+	for _, want := range []string{"rate_limit.rps", "resolver.max_output"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadHelp(t *testing.T) {
+	if _, _, err := config.Load([]string{"-h"}, env(nil)); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("got %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	cfg, printConfig, err := config.Load([]string{"-print-config", "-cache.max_entries", "100"}, env(nil))
+	if err != nil || !printConfig {
+		t.Fatalf("Load: %v, printConfig %v", err, printConfig)
+	}
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatalf("Print: %v", err)
+	}
+	path := writeFile(t, "printed.yaml", out.String())
+	reloaded, _, err := config.Load([]string{"-config", path}, env(nil))
+	if err != nil {
+		t.Fatalf("reload printed config: %v", err)
+	}
+	if reloaded.Cache.MaxEntries != 100 || reloaded.Resolver.Timeout != cfg.Resolver.Timeout {
+		t.Fatalf("round trip: got %+v", reloaded.Cache)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	prev := config.Default()
+	next := config.Default()
+	next.Server.Listen = ":9090"
+	next.RateLimit.RPS = 5
+	next.Policy.DenyCIDRs = []string{"0.0.0.0/0"}
+
+	changes := config.Diff(prev, next)
+	want := []string{
+		`server.listen: ":8080" -> ":9090"`,
+		`rate_limit.rps: "10" -> "5"`,
+		`policy.deny_cidrs: "" -> "0.0.0.0/0"`,
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("got %v, want %v", changes, want)
+	}
+	for i, change := range changes {
+		if change.String() != want[i] {
+			t.Errorf("change %d: got %s, want %s", i, change, want[i])
+		}
+	}
+	if config.Reloadable(changes[0].Setting) || !config.Reloadable(changes[1].Setting) ||
+		!config.Reloadable(changes[2].Setting) {
+		t.Errorf("unexpected reloadability of %v", changes)
+	}
+
+	applied := prev.Apply(next)
+	if applied.Server.Listen != ":8080" || applied.RateLimit.RPS != 5 || len(applied.Policy.DenyCIDRs) != 1 {
+		t.Errorf("Apply: got %+v", applied)
+	}
+}
+
+func TestDiffRedactsSecrets(t *testing.T) {
+	prev := config.Default()
+	next := config.Default()
+	next.Auth.Keys = `[{"key":"s3cret"}]`
+	next.Auth.KeysFile = "/etc/wdns/keys.json"
+	next.TLS.KeyFile = "/etc/wdns/key.pem"
+
+	changes := config.Diff(prev, next)
+	want := []string{"tls.key_file: changed", "auth.api_keys_file: changed", "auth.api_keys: changed"}
+	if len(changes) != len(want) {
+		t.Fatalf("got %v, want %v", changes, want)
+	}
+	for i, change := range changes {
+		if change.String() != want[i] {
+			t.Errorf("change %d: got %s, want %s", i, change, want[i])
+		}
+		if change.Old != "" || change.New != "" {
+			t.Errorf("change %d: values not redacted: %+v", i, change)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Change is a setting that differs between two configurations.
+type Change struct {
+	// Setting is the path of the setting, e.g. "rate_limit.rps".
+	Setting string
+	// Old and New are empty for secret settings, see Secret.
+	Old string
+	New string
+}
+
+// String formats the change for logs. Secret settings are reported as
+// changed without their values.
+func (c Change) String() string {
+	if Secret(c.Setting) {
+		return c.Setting + ": changed"
+	}
+	return fmt.Sprintf("%s: %q -> %q", c.Setting, c.Old, c.New)
+}
+
+// Diff returns the settings that differ between prev and next. The values of
+// secret settings are left out.
+func Diff(prev, next Config) []Change {
+	var changes []Change
+	prevFields, nextFields := prev.fields(), next.fields()
+	for i, f := range prevFields {
+		if _, alias := f.value.(portValue); alias {
+			// reported as server.listen
+			continue
+		}
+		old, cur := f.value.String(), nextFields[i].value.String()
+		switch {
+		case old == cur:
+		case Secret(f.name):
+			changes = append(changes, Change{Setting: f.name, Old: "", New: ""})
+		default:
+			changes = append(changes, Change{Setting: f.name, Old: old, New: cur})
+		}
+	}
+	return changes
+}
+
+// Reloadable reports whether setting can be changed without a restart:
+// trusted proxies and client IP headers, rate limits, the nameserver policy
+// and the upstream profiles.
+func Reloadable(setting string) bool {
+	switch setting {
+	case "server.trusted_proxies", "server.client_ip_headers", "resolver.upstreams_file":
+		return true
+	default:
+		return strings.HasPrefix(setting, "rate_limit.") || strings.HasPrefix(setting, "policy.")
+	}
+}
+
+// Secret reports whether setting holds, or names a file holding, API keys or
+// a private key, whose values must not appear in logs or responses.
+func Secret(setting string) bool {
+	switch setting {
+	case "auth.api_keys", "auth.api_keys_file", "tls.key_file":
+		return true
+	default:
+		return false
+	}
+}
+
+// Apply returns c with the reloadable settings taken from next. Every other
+// setting keeps its current value.
+func (c Config) Apply(next Config) Config {
+	c.Server.TrustedProxies = next.Server.TrustedProxies
+	c.Server.ClientIPHeaders = next.Server.ClientIPHeaders
+	c.Resolver.UpstreamsFile = next.Resolver.UpstreamsFile
+	c.RateLimit = next.RateLimit
+	c.Policy = next.Policy
+	return c
+}
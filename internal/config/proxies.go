@@ -3,25 +3,13 @@ package config
 import (
 	"fmt"
 	"net"
-	"os"
-	"strings"
 )
 
-// LoadTrustedProxies reads the TRUSTED_PROXIES environment variable, which is a
-// comma-separated list of CIDRs (e.g. "10.0.0.0/8,192.168.0.0/16"). It returns
-// a slice of parsed *net.IPNet. An empty value returns an empty slice and no error.
-func LoadTrustedProxies() ([]*net.IPNet, error) {
-	raw := os.Getenv("TRUSTED_PROXIES")
-	if strings.TrimSpace(raw) == "" {
-		return nil, nil
-	}
-	parts := strings.Split(raw, ",")
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8" into the networks
+// trusted to set forwarding headers. An empty list returns nil.
+func ParseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
 	var out []*net.IPNet
-	for _, p := range parts {
-		cidr := strings.TrimSpace(p)
-		if cidr == "" {
-			continue
-		}
+	for _, cidr := range cidrs {
 		_, ipnet, err := net.ParseCIDR(cidr)
 		if err != nil {
 			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
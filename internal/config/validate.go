@@ -0,0 +1,138 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/listener"
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/telemetry"
+)
+
+const maxPort = 65535
+
+// Validate checks every setting and returns all problems at once, each
+// wrapping ErrInvalid.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, setting, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s: %s", ErrInvalid, setting, fmt.Sprintf(format, args...)))
+		}
+	}
+	checkErr := func(setting string, err error) {
+		check(err == nil, setting, "%v", err)
+	}
+
+	checkErr("server.listen", validateListen(c.Server.Listen))
+	if c.Server.AdminListen != "" {
+		checkErr("server.admin_listen", validateListen(c.Server.AdminListen))
+		check(c.Server.AdminListen != c.Server.Listen, "server.admin_listen", "must differ from server.listen")
+	}
+	_, err := listener.ParseSocketMode(c.Server.SocketMode)
+	checkErr("server.socket_mode", err)
+	mode, err := listener.ParseProxyMode(c.Server.ProxyProtocol)
+	checkErr("server.proxy_protocol", err)
+	_, err = ParseTrustedProxies(c.Server.TrustedProxies)
+	checkErr("server.trusted_proxies", err)
+	check(mode == listener.ProxyOff || len(c.Server.TrustedProxies) > 0,
+		"server.proxy_protocol", "%q requires server.trusted_proxies", mode)
+	check(c.Server.DrainDelay >= 0, "server.drain_delay", "must not be negative, got %s", c.Server.DrainDelay)
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive, got %s", c.Server.ShutdownTimeout)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "set both cert_file and key_file or neither")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.client_ca_file", "requires tls.cert_file")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive, got %s", c.TLS.ReloadInterval)
+	_, err = listener.ParseTLSVersion(c.TLS.MinVersion)
+	checkErr("tls.min_version", err)
+	_, err = listener.ParseCipherSuites(c.TLS.CipherSuites)
+	checkErr("tls.cipher_suites", err)
+	_, err = listener.ParseClientAuth(c.TLS.ClientAuth)
+	checkErr("tls.client_auth", err)
+	_, err = listener.ParseClientAuth(c.TLS.AdminClientAuth)
+	checkErr("tls.admin_client_auth", err)
+
+	backend := strings.ToLower(c.Resolver.Backend)
+	check(backend == "" || backend == resolver.BackendKdig || backend == resolver.BackendNative,
+		"resolver.backend", "%q is not %q or %q", c.Resolver.Backend, resolver.BackendKdig, resolver.BackendNative)
+	check(c.Resolver.Timeout > 0, "resolver.timeout", "must be positive, got %s", c.Resolver.Timeout)
+	check(c.Resolver.MaxOutput > 0, "resolver.max_output", "must be positive, got %d", c.Resolver.MaxOutput)
+	// zero pool, batch and cache bounds select the package defaults, as they
+	// always have
+	check(c.Resolver.MaxConcurrency >= 0, "resolver.max_concurrency", "must not be negative, got %d", c.Resolver.MaxConcurrency)
+	check(c.Resolver.MaxQueue >= 0, "resolver.max_queue", "must not be negative, got %d", c.Resolver.MaxQueue)
+	check(c.Resolver.QueueTimeout >= 0, "resolver.queue_timeout", "must not be negative, got %s", c.Resolver.QueueTimeout)
+
+	check(c.RateLimit.RPS > 0, "rate_limit.rps", "must be positive, got %g", c.RateLimit.RPS)
+	check(c.RateLimit.Burst > 0, "rate_limit.burst", "must be positive, got %d", c.RateLimit.Burst)
+	check(c.Batch.Concurrency >= 0, "batch.concurrency", "must not be negative, got %d", c.Batch.Concurrency)
+	check(c.Batch.MaxItems >= 0, "batch.max_items", "must not be negative, got %d", c.Batch.MaxItems)
+	check(c.Cache.MaxEntries >= 0, "cache.max_entries", "must not be negative, got %d", c.Cache.MaxEntries)
+	check(c.Cache.MaxBytes >= 0, "cache.max_bytes", "must not be negative, got %d", c.Cache.MaxBytes)
+	check(c.Cache.MaxTTL >= 0, "cache.max_ttl", "must not be negative, got %s", c.Cache.MaxTTL)
+
+	_, err = policy.ParseCIDRs(strings.Join(c.Policy.AllowCIDRs, ","))
+	checkErr("policy.allow_cidrs", err)
+	_, err = policy.ParseCIDRs(strings.Join(c.Policy.DenyCIDRs, ","))
+	checkErr("policy.deny_cidrs", err)
+	for _, port := range c.Policy.AllowedPorts {
+		check(port > 0 && port <= maxPort, "policy.allowed_ports", "port %d is out of range", port)
+	}
+
+	check(validTransport(c.DoH.Transport), "doh.transport", "%q is not empty, tcp, tls or https", c.DoH.Transport)
+	check(c.Auth.KeysFile == "" || c.Auth.Keys == "", "auth", "set api_keys_file or api_keys, not both")
+	if c.Auth.Keys != "" {
+		_, err = auth.ParseKeys(strings.NewReader(c.Auth.Keys))
+		checkErr("auth.api_keys", err)
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case "", telemetry.ExporterNone, telemetry.ExporterOTLP, telemetry.ExporterStdout:
+	case telemetry.ExporterFile:
+		check(c.Tracing.File != "", "tracing.file", "required by the file exporter")
+	default:
+		check(false, "tracing.exporter", "%q is not none, otlp, stdout or file", c.Tracing.Exporter)
+	}
+	check(c.Tracing.SampleRatio > 0 && c.Tracing.SampleRatio <= 1,
+		"tracing.sample_ratio", "must be in (0, 1], got %g", c.Tracing.SampleRatio)
+
+	check(validTransport(c.Health.CanaryTransport), "health.canary_transport",
+		"%q is not empty, tcp, tls or https", c.Health.CanaryTransport)
+	check(c.Health.CanaryNameserver == "" || c.Health.CanaryName != "", "health.canary_name",
+		"required by health.canary_nameserver")
+	check(c.Health.CanaryInterval > 0, "health.canary_interval", "must be positive, got %s", c.Health.CanaryInterval)
+
+	return errors.Join(errs...)
+}
+
+// validateListen checks a host:port or "unix:/path.sock" listen address.
+func validateListen(addr string) error {
+	if path, ok := listener.SocketPath(addr); ok {
+		if path == "" {
+			return fmt.Errorf("%q has no socket path", addr)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not host:port: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > maxPort {
+		return fmt.Errorf("%q has an invalid port", addr)
+	}
+	return nil
+}
+
+func validTransport(transport string) bool {
+	switch strings.ToLower(transport) {
+	case "", "tcp", "tls", "https":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,216 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field binds one setting to its environment variable and flag. The flag is
+// named after the setting's path in the configuration file.
+type field struct {
+	name  string
+	env   string
+	usage string
+	value flag.Value
+}
+
+// fields lists every setting of c that can be set from the environment or
+// the command line.
+func (c *Config) fields() []field {
+	return []field{
+		{"server.port", "PORT", "port to listen on, shorthand for server.listen=:PORT", portValue{&c.Server.Listen}},
+		{"server.listen", "LISTEN_ADDR", "address to listen on, host:port or unix:/path.sock", (*stringValue)(&c.Server.Listen)},
+		{"server.admin_listen", "ADMIN_LISTEN_ADDR", "separate address for the admin endpoints", (*stringValue)(&c.Server.AdminListen)},
+		{"server.socket_mode", "SOCKET_MODE", "octal permissions of Unix sockets, e.g. 0660", (*stringValue)(&c.Server.SocketMode)},
+		{"server.socket_user", "SOCKET_USER", "owner of Unix sockets, name or uid", (*stringValue)(&c.Server.SocketUser)},
+		{"server.socket_group", "SOCKET_GROUP", "group of Unix sockets, name or gid", (*stringValue)(&c.Server.SocketGroup)},
+		{"server.proxy_protocol", "PROXY_PROTOCOL", "accept PROXY protocol headers: off, optional or required", (*stringValue)(&c.Server.ProxyProtocol)},
+		{"server.trusted_proxies", "TRUSTED_PROXIES", "comma-separated CIDRs of trusted proxies", (*listValue)(&c.Server.TrustedProxies)},
+		{"server.client_ip_headers", "CLIENT_IP_HEADERS", "comma-separated forwarding headers set by trusted proxies", (*listValue)(&c.Server.ClientIPHeaders)},
+		{"server.drain_delay", "SHUTDOWN_DRAIN_DELAY", "time to keep serving while unready after a shutdown signal", durationValue{&c.Server.DrainDelay, time.Second}},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "longest wait for in-flight requests on shutdown", durationValue{&c.Server.ShutdownTimeout, time.Second}},
+		{"tls.cert_file", "TLS_CERT_FILE", "PEM certificate file, enables HTTPS with tls.key_file", (*stringValue)(&c.TLS.CertFile)},
+		{"tls.key_file", "TLS_KEY_FILE", "PEM private key file", (*stringValue)(&c.TLS.KeyFile)},
+		{"tls.reload_interval", "TLS_RELOAD_INTERVAL", "how often the certificate files are checked for changes", durationValue{&c.TLS.ReloadInterval, time.Second}},
+		{"tls.min_version", "TLS_MIN_VERSION", "lowest accepted TLS version: 1.0, 1.1, 1.2 or 1.3", (*stringValue)(&c.TLS.MinVersion)},
+		{"tls.cipher_suites", "TLS_CIPHER_SUITES", "comma-separated TLS 1.2 cipher suite names", (*listValue)(&c.TLS.CipherSuites)},
+		{"tls.http2", "TLS_HTTP2", "offer HTTP/2 over TLS", (*boolValue)(&c.TLS.HTTP2)},
+		{"tls.client_ca_file", "TLS_CLIENT_CA_FILE", "CA bundle verifying client certificates, enables mTLS", (*stringValue)(&c.TLS.ClientCAFile)},
+		{"tls.client_auth", "TLS_CLIENT_AUTH", "client certificate mode: optional or require", (*stringValue)(&c.TLS.ClientAuth)},
+		{"tls.admin_client_auth", "TLS_ADMIN_CLIENT_AUTH", "client certificate mode of the admin listener: optional or require", (*stringValue)(&c.TLS.AdminClientAuth)},
+		{"resolver.backend", "RESOLVER_BACKEND", "resolver backend: kdig or native", (*stringValue)(&c.Resolver.Backend)},
+		{"resolver.timeout", "RESOLVER_TIMEOUT", "per-query timeout", durationValue{&c.Resolver.Timeout, time.Second}},
+		{"resolver.max_output", "RESOLVER_MAX_OUTPUT", "maximum bytes of resolver output", (*intValue)(&c.Resolver.MaxOutput)},
+		{"resolver.max_concurrency", "RESOLVER_MAX_CONCURRENCY", "maximum queries executed at once", (*intValue)(&c.Resolver.MaxConcurrency)},
+		{"resolver.max_queue", "RESOLVER_MAX_QUEUE", "maximum queries waiting for an execution slot", (*intValue)(&c.Resolver.MaxQueue)},
+		{"resolver.queue_timeout", "RESOLVER_QUEUE_TIMEOUT_MS", "longest wait for an execution slot", durationValue{&c.Resolver.QueueTimeout, time.Millisecond}},
+		{"resolver.upstreams_file", "UPSTREAMS_FILE", "JSON file with upstream profiles", (*stringValue)(&c.Resolver.UpstreamsFile)},
+		{"rate_limit.rps", "RATE_LIMIT_RPS", "requests per second per client", (*floatValue)(&c.RateLimit.RPS)},
+		{"rate_limit.burst", "RATE_LIMIT_BURST", "burst capacity per client", (*intValue)(&c.RateLimit.Burst)},
+		{"batch.concurrency", "BATCH_CONCURRENCY", "/batch items executed concurrently per request", (*intValue)(&c.Batch.Concurrency)},
+		{"batch.max_items", "BATCH_MAX_ITEMS", "maximum items per /batch request", (*intValue)(&c.Batch.MaxItems)},
+		{"cache.max_entries", "CACHE_MAX_ENTRIES", "response cache entries, 0 disables the cache", (*intValue)(&c.Cache.MaxEntries)},
+		{"cache.max_bytes", "CACHE_MAX_BYTES", "approximate response cache memory bound", (*intValue)(&c.Cache.MaxBytes)},
+		{"cache.max_ttl", "CACHE_MAX_TTL", "longest time an answer is cached", durationValue{&c.Cache.MaxTTL, time.Second}},
+		{"policy.allow_cidrs", "NAMESERVER_ALLOW_CIDRS", "comma-separated nameserver CIDRs allowed inside blocked ranges", (*listValue)(&c.Policy.AllowCIDRs)},
+		{"policy.deny_cidrs", "NAMESERVER_DENY_CIDRS", "comma-separated nameserver CIDRs refused", (*listValue)(&c.Policy.DenyCIDRs)},
+		{"policy.allow_hosts", "NAMESERVER_ALLOW_HOSTS", "comma-separated nameserver hostnames allowed", (*listValue)(&c.Policy.AllowHosts)},
+		{"policy.deny_hosts", "NAMESERVER_DENY_HOSTS", "comma-separated nameserver hostnames refused", (*listValue)(&c.Policy.DenyHosts)},
+		{"policy.allowed_ports", "NAMESERVER_ALLOWED_PORTS", "comma-separated nameserver ports allowed", (*intListValue)(&c.Policy.AllowedPorts)},
+		{"doh.upstream", "DOH_UPSTREAM", "nameserver /dns-query and /resolve forward to", (*stringValue)(&c.DoH.Upstream)},
+		{"doh.transport", "DOH_UPSTREAM_TRANSPORT", "transport to the DoH upstream", (*stringValue)(&c.DoH.Transport)},
+		{"dnssec.trust_anchor_file", "DNSSEC_TRUST_ANCHOR_FILE", "DNSSEC trust anchors file", (*stringValue)(&c.DNSSEC.TrustAnchorFile)},
+		{"trace.root_hints", "TRACE_ROOT_HINTS", "comma-separated root server addresses", (*listValue)(&c.Trace.RootHints)},
+		{"auth.api_keys_file", "API_KEYS_FILE", "JSON file with API key definitions", (*stringValue)(&c.Auth.KeysFile)},
+		{"auth.api_keys", "API_KEYS", "inline JSON API key definitions", (*stringValue)(&c.Auth.Keys)},
+		{"metrics.enabled", "METRICS_ENABLED", "serve Prometheus metrics on /metrics", (*boolValue)(&c.Metrics.Enabled)},
+		{"tracing.exporter", "TRACING_EXPORTER", "trace exporter: none, otlp, stdout or file", (*stringValue)(&c.Tracing.Exporter)},
+		{"tracing.otlp_endpoint", "TRACING_OTLP_ENDPOINT", "OTLP/HTTP traces URL", (*stringValue)(&c.Tracing.OTLPEndpoint)},
+		{"tracing.file", "TRACING_FILE", "file the file exporter appends spans to", (*stringValue)(&c.Tracing.File)},
+		{"tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "fraction of new traces recorded", (*floatValue)(&c.Tracing.SampleRatio)},
+		{"health.canary_nameserver", "HEALTH_CANARY_NAMESERVER", "nameserver or upstream profile /readyz sends a canary query to", (*stringValue)(&c.Health.CanaryNameserver)},
+		{"health.canary_transport", "HEALTH_CANARY_TRANSPORT", "transport of the canary query", (*stringValue)(&c.Health.CanaryTransport)},
+		{"health.canary_name", "HEALTH_CANARY_NAME", "name the canary query resolves", (*stringValue)(&c.Health.CanaryName)},
+		{"health.canary_interval", "HEALTH_CANARY_INTERVAL", "how long a canary result is reused", durationValue{&c.Health.CanaryInterval, time.Second}},
+	}
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(strings.TrimSpace(s))
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+// listValue is a comma-separated list; empty elements are dropped.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	out := listValue{}
+	for part := range strings.SplitSeq(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	*v = out
+	return nil
+}
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+type intListValue []int
+
+func (v *intListValue) Set(s string) error {
+	var list listValue
+	_ = list.Set(s)
+	out := make(intListValue, 0, len(list))
+	for _, part := range list {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", part)
+		}
+		out = append(out, n)
+	}
+	*v = out
+	return nil
+}
+
+func (v *intListValue) String() string {
+	parts := make([]string, len(*v))
+	for i, n := range *v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
+
+// durationValue accepts a Go duration ("1500ms", "2s") or a bare integer in
+// unit, which keeps the historical RESOLVER_QUEUE_TIMEOUT_MS (milliseconds)
+// and CACHE_MAX_TTL (seconds) values working.
+type durationValue struct {
+	d    *time.Duration
+	unit time.Duration
+}
+
+func (v durationValue) Set(s string) error {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		*v.d = time.Duration(n) * v.unit
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", s)
+	}
+	*v.d = d
+	return nil
+}
+
+func (v durationValue) String() string {
+	if v.d == nil {
+		return ""
+	}
+	return v.d.String()
+}
+
+// portValue sets a listen address of ":port".
+type portValue struct {
+	listen *string
+}
+
+func (v portValue) Set(s string) error {
+	*v.listen = ":" + strings.TrimSpace(s)
+	return nil
+}
+
+func (v portValue) String() string {
+	if v.listen == nil {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(*v.listen)
+	return port
+}
@@ -0,0 +1,96 @@
+package dnssec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoAnchors is returned when a trust anchor source contains no DS or
+// DNSKEY records.
+var ErrNoAnchors = errors.New("no DS or DNSKEY trust anchor records found")
+
+// TrustAnchor holds the DS and/or DNSKEY records trusted for one zone.
+type TrustAnchor struct {
+	Zone string
+	DS   []*dns.DS
+	Keys []*dns.DNSKEY
+}
+
+// RootAnchors returns the IANA root zone KSK trust anchors (KSK-2017 and
+// KSK-2024) as DS records.
+func RootAnchors() []TrustAnchor {
+	rootDS := func(keyTag uint16, digest string) *dns.DS {
+		return &dns.DS{
+			Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 0, Rdlength: 0},
+			KeyTag:     keyTag,
+			Algorithm:  dns.RSASHA256,
+			DigestType: dns.SHA256,
+			Digest:     digest,
+		}
+	}
+	return []TrustAnchor{{
+		Zone: ".",
+		DS: []*dns.DS{
+			rootDS(20326, "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"),
+			rootDS(38696, "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16"),
+		},
+		Keys: nil,
+	}}
+}
+
+// LoadTrustAnchors reads trust anchors from a zone-file formatted file
+// containing DS and/or DNSKEY records, e.g. the output of `kdig . DNSKEY`.
+func LoadTrustAnchors(path string) ([]TrustAnchor, error) {
+	f, err := os.Open(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("open trust anchor file: %w", err)
+	}
+	defer f.Close()
+	anchors, err := ParseTrustAnchors(f)
+	if err != nil {
+		return nil, fmt.Errorf("trust anchor file %s: %w", path, err)
+	}
+	return anchors, nil
+}
+
+// ParseTrustAnchors parses zone-file formatted DS and DNSKEY records into
+// trust anchors grouped by owner name. Other record types are ignored.
+func ParseTrustAnchors(r io.Reader) ([]TrustAnchor, error) {
+	byZone := make(map[string]*TrustAnchor)
+	var order []string
+	zp := dns.NewZoneParser(r, ".", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		zone := dns.CanonicalName(rr.Header().Name)
+		anchor, seen := byZone[zone]
+		if !seen {
+			anchor = &TrustAnchor{Zone: zone, DS: nil, Keys: nil}
+		}
+		switch rec := rr.(type) {
+		case *dns.DS:
+			anchor.DS = append(anchor.DS, rec)
+		case *dns.DNSKEY:
+			anchor.Keys = append(anchor.Keys, rec)
+		default:
+			continue
+		}
+		if !seen {
+			byZone[zone] = anchor
+			order = append(order, zone)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("parse trust anchors: %w", err)
+	}
+	if len(order) == 0 {
+		return nil, ErrNoAnchors
+	}
+	out := make([]TrustAnchor, 0, len(order))
+	for _, zone := range order {
+		out = append(out, *byZone[zone])
+	}
+	return out, nil
+}
@@ -0,0 +1,102 @@
+package dnssec
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// nsec3OptOut is the Opt-Out flag of NSEC3 records (RFC 5155 3.1.2.1).
+const nsec3OptOut = 1
+
+// provesNoDS checks that the authority section of resp proves, with NSEC or
+// NSEC3 records signed by keys of the parent zone, that the delegation to
+// name has no DS record (RFC 4035 5.2, RFC 5155 8.6 and 8.9). It returns ""
+// when the proof holds, or why it does not.
+func (r *run) provesNoDS(resp *dns.Msg, name string, keys []*dns.DNSKEY) string {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	reason := "no NSEC or NSEC3 record proves it"
+	for _, set := range groupRRsets(resp.Ns) {
+		rrtype := set.rrs[0].Header().Rrtype
+		if rrtype != dns.TypeNSEC && rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if _, why := verifyRRset(set.rrs, set.sigs, keys, r.v.Now()); why != "" {
+			reason = "denial of existence: " + why
+			continue
+		}
+		for _, rr := range set.rrs {
+			switch rr := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, rr)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, rr)
+			default:
+			}
+		}
+	}
+	for _, nsec := range nsecs {
+		if strings.EqualFold(dns.CanonicalName(nsec.Hdr.Name), name) {
+			return delegationReason("NSEC", nsec.TypeBitMap)
+		}
+	}
+	if len(nsec3s) > 0 {
+		return nsec3ProvesNoDS(nsec3s, name)
+	}
+	return reason
+}
+
+// nsec3ProvesNoDS checks that an NSEC3 record matching name denies the DS
+// type or, for opt-out zones, that the closest provable encloser of name is
+// matched and the next closer name is covered by an opt-out NSEC3 record.
+func nsec3ProvesNoDS(records []*dns.NSEC3, name string) string {
+	for _, rr := range records {
+		if rr.Match(name) {
+			return delegationReason("NSEC3", rr.TypeBitMap)
+		}
+	}
+	next := name
+	for encloser := parentName(name); ; encloser = parentName(encloser) {
+		matched := slices.ContainsFunc(records, func(rr *dns.NSEC3) bool { return rr.Match(encloser) })
+		if matched {
+			for _, rr := range records {
+				if rr.Flags&nsec3OptOut != 0 && rr.Cover(next) {
+					return ""
+				}
+			}
+			return "no opt-out NSEC3 record covers " + next
+		}
+		if encloser == "." {
+			return "no NSEC3 record matches " + name + " or an ancestor"
+		}
+		next = encloser
+	}
+}
+
+// delegationReason checks the type bitmap of the NSEC or NSEC3 record owned
+// by a delegation: NS present, DS and SOA absent, the latter ruling out the
+// child zone's own record.
+func delegationReason(kind string, types []uint16) string {
+	switch {
+	case slices.Contains(types, dns.TypeDS):
+		return kind + " record lists a DS record"
+	case slices.Contains(types, dns.TypeSOA):
+		return kind + " record is from the child zone"
+	case !slices.Contains(types, dns.TypeNS):
+		return kind + " record does not prove a delegation"
+	default:
+		return ""
+	}
+}
+
+// parentName returns name without its first label, or "." for the root and
+// top-level names.
+func parentName(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
@@ -0,0 +1,78 @@
+// Package dnssec validates DNSSEC signatures in-process by walking the chain
+// of trust from a configured trust anchor down to the queried name.
+package dnssec
+
+import "slices"
+
+// Status is the DNSSEC validation state of a zone or RRset (RFC 4035 4.3).
+type Status string
+
+// Validation states.
+const (
+	// StatusSecure means a chain of signed DNSKEY and DS records leads from a
+	// trust anchor to the data and all signatures verified.
+	StatusSecure Status = "secure"
+	// StatusInsecure means the data is provably outside any signed zone, e.g.
+	// below a delegation whose parent proves with signed NSEC or NSEC3
+	// records that it has no DS records.
+	StatusInsecure Status = "insecure"
+	// StatusBogus means signatures or the chain failed to verify.
+	StatusBogus Status = "bogus"
+	// StatusIndeterminate means validation could not be completed, e.g.
+	// because records could not be fetched.
+	StatusIndeterminate Status = "indeterminate"
+)
+
+// Report is the result of validating a single query.
+type Report struct {
+	// Status is the overall result for the queried data.
+	Status Status `json:"status"`
+	// Reason explains non-secure results.
+	Reason string `json:"reason,omitempty"`
+	// Anchor is the owner name of the trust anchor the chain starts at.
+	Anchor string `json:"anchor,omitempty"`
+	// Chain lists the zones walked from the trust anchor down, in order.
+	Chain []ZoneReport `json:"chain"`
+	// RRsets lists the validation result of each RRset in the response.
+	RRsets []RRsetReport `json:"rrsets"`
+}
+
+// ZoneReport describes the validation state of one zone in the chain.
+type ZoneReport struct {
+	Zone   string `json:"zone"`
+	Status Status `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	// DS lists the key tags of DS records published for the zone by its parent.
+	DS []uint16 `json:"ds,omitempty"`
+	// KeyTags lists the key tags of the zone's DNSKEY RRset.
+	KeyTags []uint16 `json:"key_tags,omitempty"`
+}
+
+// RRsetReport describes the validation state of one RRset of the response.
+type RRsetReport struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Section string `json:"section"`
+	Status  Status `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Signer  string `json:"signer,omitempty"`
+	KeyTag  uint16 `json:"key_tag,omitempty"`
+}
+
+// severity orders statuses from best to worst for aggregation. Unknown
+// statuses rank like indeterminate.
+func severity(s Status) int {
+	order := []Status{StatusSecure, StatusInsecure, StatusIndeterminate, StatusBogus}
+	if i := slices.Index(order, s); i >= 0 {
+		return i
+	}
+	return slices.Index(order, StatusIndeterminate)
+}
+
+// worst returns the more severe of a and b.
+func worst(a, b Status) Status {
+	if severity(b) > severity(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,401 @@
+package dnssec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	ednsBufferSize = 4096
+	sectionAnswer  = "answer"
+	sectionAuth    = "authority"
+)
+
+// Exchanger sends a DNS message to a nameserver over the given transport and
+// returns the response. resolver.NativeClient implements it.
+type Exchanger interface {
+	Exchange(ctx context.Context, msg *dns.Msg, nameserver, transport string) (*dns.Msg, error)
+}
+
+// Validator validates responses by fetching DNSKEY, DS and RRSIG records from
+// the queried nameserver and verifying them against its trust anchors.
+//
+// Queries are sent with the CD bit set so that the upstream returns data even
+// when its own validation fails, allowing the validator to explain why.
+type Validator struct {
+	Anchors  []TrustAnchor
+	Exchange Exchanger
+	// Now returns the time used to check signature validity periods.
+	Now func() time.Time
+}
+
+// NewValidator creates a Validator. Nil or empty anchors default to the root
+// zone KSKs.
+func NewValidator(exchanger Exchanger, anchors []TrustAnchor) *Validator {
+	if len(anchors) == 0 {
+		anchors = RootAnchors()
+	}
+	return &Validator{Anchors: anchors, Exchange: exchanger, Now: time.Now}
+}
+
+// zoneState is the validation result of one zone, cached during a run.
+type zoneState struct {
+	report ZoneReport
+	keys   []*dns.DNSKEY
+}
+
+// run holds the state of a single validation.
+type run struct {
+	v          *Validator
+	ctx        context.Context //nolint:containedctx // scoped to a single Validate call
+	nameserver string
+	transport  string
+	zones      map[string]*zoneState
+	report     *Report
+}
+
+// Validate queries name/qtype at nameserver and validates the response and the
+// chain of trust of every signer involved.
+func (v *Validator) Validate(ctx context.Context, name string, qtype uint16, nameserver, transport string) *Report {
+	r := &run{
+		v:          v,
+		ctx:        ctx,
+		nameserver: nameserver,
+		transport:  transport,
+		zones:      make(map[string]*zoneState),
+		report: &Report{
+			Status: StatusSecure,
+			Reason: "",
+			Anchor: "",
+			Chain:  []ZoneReport{},
+			RRsets: []RRsetReport{},
+		},
+	}
+
+	resp, err := r.query(dns.Fqdn(name), qtype)
+	if err != nil {
+		r.report.Status = StatusIndeterminate
+		r.report.Reason = err.Error()
+		return r.report
+	}
+
+	answerSets := groupRRsets(resp.Answer)
+	for _, set := range answerSets {
+		r.addRRset(r.validateRRset(set, sectionAnswer))
+	}
+	if len(answerSets) == 0 {
+		r.validateNegative(resp, dns.Fqdn(name))
+	}
+	return r.report
+}
+
+// validateNegative handles NXDOMAIN and NODATA responses. The signatures of
+// the SOA and NSEC/NSEC3 records are verified, but whether the denial records
+// actually cover the queried name is not, so a fully signed negative answer is
+// reported as indeterminate rather than secure.
+func (r *run) validateNegative(resp *dns.Msg, name string) {
+	authSets := groupRRsets(resp.Ns)
+	for _, set := range authSets {
+		r.addRRset(r.validateRRset(set, sectionAuth))
+	}
+	if len(authSets) == 0 {
+		state := r.zoneFor(name, false)
+		r.addRRset(RRsetReport{
+			Name:    name,
+			Type:    dns.RcodeToString[resp.Rcode],
+			Section: sectionAuth,
+			Status:  worst(state.report.Status, StatusIndeterminate),
+			Reason:  "negative response without SOA or denial-of-existence records",
+			Signer:  "",
+			KeyTag:  0,
+		})
+		return
+	}
+	if r.report.Status == StatusSecure {
+		r.report.Status = StatusIndeterminate
+		r.report.Reason = "negative response: denial-of-existence records are signed but coverage is not verified"
+	}
+}
+
+func (r *run) addRRset(rep RRsetReport) {
+	r.report.RRsets = append(r.report.RRsets, rep)
+	if severity(rep.Status) > severity(r.report.Status) {
+		r.report.Status = rep.Status
+		r.report.Reason = fmt.Sprintf("%s %s: %s", rep.Name, rep.Type, rep.Reason)
+	}
+}
+
+// rrset is an RRset of a response together with the RRSIGs covering it.
+type rrset struct {
+	rrs  []dns.RR
+	sigs []*dns.RRSIG
+}
+
+// validateRRset verifies set against the keys of its signer zone.
+func (r *run) validateRRset(set rrset, section string) RRsetReport {
+	hdr := set.rrs[0].Header()
+	rep := RRsetReport{
+		Name:    hdr.Name,
+		Type:    dns.TypeToString[hdr.Rrtype],
+		Section: section,
+		Status:  StatusIndeterminate,
+		Reason:  "",
+		Signer:  "",
+		KeyTag:  0,
+	}
+
+	if len(set.sigs) == 0 {
+		// no signature: fine below an insecure delegation, bogus in a signed zone
+		state := r.zoneFor(hdr.Name, false)
+		rep.Signer = state.report.Zone
+		if state.report.Status == StatusSecure {
+			rep.Status, rep.Reason = StatusBogus, "missing RRSIG in signed zone "+state.report.Zone
+			return rep
+		}
+		rep.Status, rep.Reason = state.report.Status, zoneReason(state)
+		return rep
+	}
+
+	signer := dns.CanonicalName(set.sigs[0].SignerName)
+	rep.Signer = signer
+	if !dns.IsSubDomain(signer, dns.CanonicalName(hdr.Name)) {
+		rep.Status, rep.Reason = StatusBogus, "signer "+signer+" is not an ancestor of the owner name"
+		return rep
+	}
+	state := r.zoneFor(signer, true)
+	if state.report.Status != StatusSecure {
+		rep.Status, rep.Reason = state.report.Status, zoneReason(state)
+		return rep
+	}
+	keyTag, reason := verifyRRset(set.rrs, set.sigs, state.keys, r.v.Now())
+	if reason != "" {
+		rep.Status, rep.Reason = StatusBogus, reason
+		return rep
+	}
+	rep.Status, rep.KeyTag = StatusSecure, keyTag
+	return rep
+}
+
+func zoneReason(state *zoneState) string {
+	if state.report.Reason != "" {
+		return "zone " + state.report.Zone + ": " + state.report.Reason
+	}
+	return "zone " + state.report.Zone + " is " + string(state.report.Status)
+}
+
+// zoneFor returns the validation state of the deepest zone enclosing name,
+// walking down from the closest trust anchor. When apex is true, name is
+// known to be a zone apex (it signed an RRset).
+func (r *run) zoneFor(name string, apex bool) *zoneState {
+	name = dns.CanonicalName(name)
+	anchor, ok := r.closestAnchor(name)
+	if !ok {
+		return r.remember(&zoneState{report: ZoneReport{
+			Zone: name, Status: StatusIndeterminate, Reason: "no trust anchor covers " + name, DS: nil, KeyTags: nil,
+		}, keys: nil})
+	}
+	if r.report.Anchor == "" {
+		r.report.Anchor = anchor.Zone
+	}
+
+	current, seen := r.zones[anchor.Zone]
+	if !seen {
+		current = r.remember(r.validateAnchorZone(anchor))
+	}
+
+	labels := dns.SplitDomainName(name)
+	anchorLabels := dns.CountLabel(anchor.Zone)
+	for i := len(labels) - anchorLabels - 1; i >= 0; i-- {
+		if current.report.Status != StatusSecure {
+			// nothing below an insecure, bogus or indeterminate zone can be secure
+			return current
+		}
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		if state, cached := r.zones[candidate]; cached {
+			current = state
+			continue
+		}
+		next, isCut := r.validateChild(current, candidate, apex && candidate == name)
+		if isCut {
+			current = r.remember(next)
+		}
+	}
+	return current
+}
+
+func (r *run) remember(state *zoneState) *zoneState {
+	r.zones[state.report.Zone] = state
+	r.report.Chain = append(r.report.Chain, state.report)
+	return state
+}
+
+func (r *run) closestAnchor(name string) (TrustAnchor, bool) {
+	var best TrustAnchor
+	found := false
+	for _, a := range r.v.Anchors {
+		zone := dns.CanonicalName(a.Zone)
+		if !dns.IsSubDomain(zone, name) {
+			continue
+		}
+		if !found || dns.CountLabel(zone) > dns.CountLabel(best.Zone) {
+			best, found = a, true
+			best.Zone = zone
+		}
+	}
+	return best, found
+}
+
+// validateAnchorZone validates the DNSKEY RRset of the anchor zone against the
+// anchor's DS and DNSKEY records.
+func (r *run) validateAnchorZone(anchor TrustAnchor) *zoneState {
+	state := newZoneState(anchor.Zone)
+	for _, ds := range anchor.DS {
+		state.report.DS = append(state.report.DS, ds.KeyTag)
+	}
+	keys, sigs, err := r.fetchDNSKEY(anchor.Zone)
+	if err != nil {
+		return state.fail(StatusIndeterminate, err.Error())
+	}
+	state.setKeys(keys)
+
+	trusted, reason := matchDS(keys, anchor.DS)
+	for _, k := range anchor.Keys {
+		for _, key := range keys {
+			if key.KeyTag() == k.KeyTag() && key.PublicKey == k.PublicKey && key.Algorithm == k.Algorithm {
+				trusted = append(trusted, key)
+			}
+		}
+	}
+	if len(trusted) == 0 {
+		if reason == "" {
+			reason = "no DNSKEY matches the trust anchor"
+		}
+		return state.fail(StatusBogus, reason)
+	}
+	return r.finishZone(state, keys, sigs, trusted)
+}
+
+// validateChild checks whether candidate is a zone cut below parent and, if
+// so, validates it. The second return value is false when candidate is not
+// a zone apex and should be skipped.
+func (r *run) validateChild(parent *zoneState, candidate string, knownApex bool) (*zoneState, bool) {
+	state := newZoneState(candidate)
+	resp, err := r.query(candidate, dns.TypeDS)
+	if err != nil {
+		return state.fail(StatusIndeterminate, err.Error()), true
+	}
+	dsSets := filterRRset(resp.Answer, candidate, dns.TypeDS)
+	if len(dsSets.rrs) == 0 {
+		if !knownApex && !r.isApex(candidate) {
+			return nil, false
+		}
+		// a missing DS only makes the delegation insecure when the parent
+		// proves it; otherwise it may have been stripped on the way
+		if reason := r.provesNoDS(resp, candidate, parent.keys); reason != "" {
+			return state.fail(StatusIndeterminate, "no DS record at parent "+parent.report.Zone+" and "+reason), true
+		}
+		return state.fail(StatusInsecure, "no DS record at parent "+parent.report.Zone+" (unsigned delegation)"), true
+	}
+
+	if _, reason := verifyRRset(dsSets.rrs, dsSets.sigs, parent.keys, r.v.Now()); reason != "" {
+		return state.fail(StatusBogus, "DS RRset: "+reason), true
+	}
+	var dsRecords []*dns.DS
+	for _, rr := range dsSets.rrs {
+		if ds, ok := rr.(*dns.DS); ok {
+			dsRecords = append(dsRecords, ds)
+			state.report.DS = append(state.report.DS, ds.KeyTag)
+		}
+	}
+
+	keys, sigs, err := r.fetchDNSKEY(candidate)
+	if err != nil {
+		return state.fail(StatusIndeterminate, err.Error()), true
+	}
+	state.setKeys(keys)
+	trusted, reason := matchDS(keys, dsRecords)
+	if len(trusted) == 0 {
+		return state.fail(StatusBogus, reason), true
+	}
+	return r.finishZone(state, keys, sigs, trusted), true
+}
+
+// finishZone verifies the DNSKEY RRset with one of the trusted keys.
+func (r *run) finishZone(state *zoneState, keys []*dns.DNSKEY, sigs []*dns.RRSIG, trusted []*dns.DNSKEY) *zoneState {
+	rrs := make([]dns.RR, 0, len(keys))
+	for _, k := range keys {
+		rrs = append(rrs, k)
+	}
+	if _, reason := verifyRRset(rrs, sigs, trusted, r.v.Now()); reason != "" {
+		return state.fail(StatusBogus, "DNSKEY RRset: "+reason)
+	}
+	state.report.Status = StatusSecure
+	return state
+}
+
+// isApex reports whether name owns an SOA record, i.e. is a zone apex.
+func (r *run) isApex(name string) bool {
+	resp, err := r.query(name, dns.TypeSOA)
+	if err != nil {
+		return false
+	}
+	return len(filterRRset(resp.Answer, name, dns.TypeSOA).rrs) > 0
+}
+
+func (r *run) fetchDNSKEY(zone string) ([]*dns.DNSKEY, []*dns.RRSIG, error) {
+	resp, err := r.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := filterRRset(resp.Answer, zone, dns.TypeDNSKEY)
+	keys := make([]*dns.DNSKEY, 0, len(set.rrs))
+	for _, rr := range set.rrs {
+		if k, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("no DNSKEY records for %s (rcode %s)", zone, dns.RcodeToString[resp.Rcode])
+	}
+	return keys, set.sigs, nil
+}
+
+func (r *run) query(name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = true
+	msg.CheckingDisabled = true
+	msg.SetEdns0(ednsBufferSize, true)
+	resp, err := r.v.Exchange.Exchange(r.ctx, msg, r.nameserver, r.transport)
+	if err != nil {
+		return nil, fmt.Errorf("query %s %s: %w", name, dns.TypeToString[qtype], err)
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("query %s %s: rcode %s", name, dns.TypeToString[qtype], dns.RcodeToString[resp.Rcode])
+	}
+	return resp, nil
+}
+
+func newZoneState(zone string) *zoneState {
+	return &zoneState{
+		report: ZoneReport{Zone: zone, Status: StatusIndeterminate, Reason: "", DS: nil, KeyTags: nil},
+		keys:   nil,
+	}
+}
+
+func (s *zoneState) fail(status Status, reason string) *zoneState {
+	s.report.Status = status
+	s.report.Reason = reason
+	return s
+}
+
+func (s *zoneState) setKeys(keys []*dns.DNSKEY) {
+	s.keys = keys
+	for _, k := range keys {
+		s.report.KeyTags = append(s.report.KeyTags, k.KeyTag())
+	}
+}
@@ -0,0 +1,299 @@
+package dnssec_test
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/dnssec"
+)
+
+// zoneExchanger answers queries from in-memory answer and authority maps
+// keyed by "name/TYPE", emulating a recursive resolver with the CD bit set.
+type zoneExchanger struct {
+	records   map[string][]dns.RR
+	authority map[string][]dns.RR
+}
+
+func (z *zoneExchanger) Exchange(_ context.Context, msg *dns.Msg, _, _ string) (*dns.Msg, error) {
+	q := msg.Question[0]
+	key := dns.CanonicalName(q.Name) + "/" + dns.TypeToString[q.Qtype]
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	resp.Answer = z.records[key]
+	resp.Ns = z.authority[key]
+	return resp, nil
+}
+
+func (z *zoneExchanger) set(name string, qtype uint16, rrs ...dns.RR) {
+	z.records[dns.CanonicalName(name)+"/"+dns.TypeToString[qtype]] = rrs
+}
+
+// deny answers name/qtype with NODATA and adds the RRset rrs, signed by s,
+// to its authority section.
+func (f *fixture) deny(t *testing.T, s signer, name string, qtype uint16, rrs ...dns.RR) {
+	t.Helper()
+	key := dns.CanonicalName(name) + "/" + dns.TypeToString[qtype]
+	delete(f.zones.records, key)
+	sig := s.sign(t, rrs, f.now.Add(-time.Hour), f.now.Add(time.Hour))
+	f.zones.authority[key] = append(append(f.zones.authority[key], rrs...), sig)
+}
+
+type signer struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newSigner(t *testing.T, zone string) signer {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600, Rdlength: 0},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+		PublicKey: "",
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	cs, ok := priv.(crypto.Signer)
+	if !ok {
+		t.Fatalf("unexpected private key type %T", priv)
+	}
+	return signer{key: key, priv: cs}
+}
+
+func (s signer) sign(t *testing.T, rrs []dns.RR, inception, expiration time.Time) *dns.RRSIG {
+	t.Helper()
+	hdr := rrs[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl, Rdlength: 0},
+		TypeCovered: hdr.Rrtype,
+		Algorithm:   s.key.Algorithm,
+		Labels:      uint8(dns.CountLabel(hdr.Name)),
+		OrigTtl:     hdr.Ttl,
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      s.key.KeyTag(),
+		SignerName:  s.key.Hdr.Name,
+		Signature:   "",
+	}
+	if err := sig.Sign(s.priv, rrs); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return sig
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return rr
+}
+
+// fixture is a signed "." -> "example." hierarchy.
+type fixture struct {
+	zones   *zoneExchanger
+	root    signer
+	example signer
+	now     time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{
+		zones:   &zoneExchanger{records: make(map[string][]dns.RR), authority: make(map[string][]dns.RR)},
+		root:    newSigner(t, "."),
+		example: newSigner(t, "example."),
+		now:     time.Now(),
+	}
+	f.publishKeys(t, f.root)
+	f.publishKeys(t, f.example)
+	f.publishDS(t, f.example.key.ToDS(dns.SHA256))
+	f.publish(t, f.example, mustRR(t, "www.example. 300 IN A 192.0.2.1"))
+	return f
+}
+
+func (f *fixture) publish(t *testing.T, s signer, rrs ...dns.RR) {
+	t.Helper()
+	hdr := rrs[0].Header()
+	sig := s.sign(t, rrs, f.now.Add(-time.Hour), f.now.Add(time.Hour))
+	f.zones.set(hdr.Name, hdr.Rrtype, append(rrs, sig)...)
+}
+
+func (f *fixture) publishKeys(t *testing.T, s signer) {
+	t.Helper()
+	f.publish(t, s, s.key)
+}
+
+func (f *fixture) publishDS(t *testing.T, ds *dns.DS) {
+	t.Helper()
+	f.publish(t, f.root, ds)
+}
+
+func (f *fixture) validator() *dnssec.Validator {
+	anchor := dnssec.TrustAnchor{Zone: ".", DS: []*dns.DS{f.root.key.ToDS(dns.SHA256)}, Keys: nil}
+	v := dnssec.NewValidator(f.zones, []dnssec.TrustAnchor{anchor})
+	v.Now = func() time.Time { return f.now }
+	return v
+}
+
+func TestValidateSecure(t *testing.T) {
+	f := newFixture(t)
+	report := f.validator().Validate(context.Background(), "www.example", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusSecure {
+		t.Fatalf("expected secure, got %+v", report)
+	}
+	if len(report.Chain) != 2 || report.Chain[0].Zone != "." || report.Chain[1].Zone != "example." {
+		t.Fatalf("unexpected chain: %+v", report.Chain)
+	}
+	if report.Anchor != "." || len(report.RRsets) != 1 || report.RRsets[0].Signer != "example." {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
+
+func TestValidateTamperedAnswer(t *testing.T) {
+	f := newFixture(t)
+	answer := f.zones.records["www.example./A"]
+	answer[0] = mustRR(t, "www.example. 300 IN A 192.0.2.66")
+
+	report := f.validator().Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusBogus || !strings.Contains(report.Reason, "failed to verify") {
+		t.Fatalf("expected bogus signature failure, got %+v", report)
+	}
+}
+
+func TestValidateExpiredSignature(t *testing.T) {
+	f := newFixture(t)
+	rr := mustRR(t, "www.example. 300 IN A 192.0.2.1")
+	sig := f.example.sign(t, []dns.RR{rr}, f.now.Add(-48*time.Hour), f.now.Add(-24*time.Hour))
+	f.zones.set("www.example.", dns.TypeA, rr, sig)
+
+	report := f.validator().Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusBogus || !strings.Contains(report.Reason, "expired") {
+		t.Fatalf("expected expired RRSIG, got %+v", report)
+	}
+}
+
+func TestValidateDSDigestMismatch(t *testing.T) {
+	f := newFixture(t)
+	ds := f.example.key.ToDS(dns.SHA256)
+	ds.Digest = strings.Repeat("00", 32)
+	f.publishDS(t, ds)
+
+	report := f.validator().Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusBogus {
+		t.Fatalf("expected bogus, got %+v", report)
+	}
+	if len(report.Chain) != 2 || !strings.Contains(report.Chain[1].Reason, "DS digest mismatch") {
+		t.Fatalf("expected DS digest mismatch in chain, got %+v", report.Chain)
+	}
+}
+
+func TestValidateInsecureDelegation(t *testing.T) {
+	f := newFixture(t)
+	f.deny(t, f.root, "example.", dns.TypeDS, mustRR(t, "example. 300 IN NSEC net. NS RRSIG NSEC"))
+	f.zones.set("www.example.", dns.TypeA, mustRR(t, "www.example. 300 IN A 192.0.2.1"))
+	f.zones.set("example.", dns.TypeSOA, mustRR(t, "example. 300 IN SOA ns.example. admin.example. 1 2 3 4 5"))
+
+	report := f.validator().Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusInsecure {
+		t.Fatalf("expected insecure, got %+v", report)
+	}
+}
+
+func TestValidateInsecureDelegationNSEC3(t *testing.T) {
+	hash := dns.HashName("sub.example.", dns.SHA1, 0, "")
+	apex := dns.HashName("example.", dns.SHA1, 0, "")
+	cases := map[string]func(f *fixture){
+		"matching": func(f *fixture) {
+			f.deny(t, f.example, "sub.example.", dns.TypeDS,
+				mustRR(t, hash+".example. 300 IN NSEC3 1 0 0 - "+hash+" NS"))
+		},
+		// the closest encloser example. is matched and sub.example. is
+		// covered by an opt-out span
+		"opt-out": func(f *fixture) {
+			f.deny(t, f.example, "sub.example.", dns.TypeDS,
+				mustRR(t, apex+".example. 300 IN NSEC3 1 0 0 - "+apex+" NS SOA RRSIG DNSKEY NSEC3PARAM"))
+			f.deny(t, f.example, "sub.example.", dns.TypeDS,
+				mustRR(t, strings.Repeat("0", 32)+".example. 300 IN NSEC3 1 1 0 - "+strings.Repeat("V", 32)))
+		},
+	}
+	for name, prove := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			prove(f)
+			f.zones.set("sub.example.", dns.TypeSOA, mustRR(t, "sub.example. 300 IN SOA ns.sub.example. admin.sub.example. 1 2 3 4 5"))
+			f.zones.set("www.sub.example.", dns.TypeA, mustRR(t, "www.sub.example. 300 IN A 192.0.2.1"))
+
+			report := f.validator().Validate(context.Background(), "www.sub.example.", dns.TypeA, "ns", "")
+			if report.Status != dnssec.StatusInsecure {
+				t.Fatalf("expected insecure, got %+v", report)
+			}
+		})
+	}
+}
+
+func TestValidateStrippedDS(t *testing.T) {
+	cases := map[string]func(f *fixture){
+		// the DS is simply removed from the answer
+		"no proof": func(f *fixture) { f.zones.set("example.", dns.TypeDS) },
+		"unsigned proof": func(f *fixture) {
+			f.zones.set("example.", dns.TypeDS)
+			f.zones.authority["example./DS"] = []dns.RR{mustRR(t, "example. 300 IN NSEC net. NS RRSIG NSEC")}
+		},
+		"proof listing DS": func(f *fixture) {
+			f.deny(t, f.root, "example.", dns.TypeDS, mustRR(t, "example. 300 IN NSEC net. NS DS RRSIG NSEC"))
+		},
+		"child zone proof": func(f *fixture) {
+			f.deny(t, f.example, "example.", dns.TypeDS, mustRR(t, "example. 300 IN NSEC www.example. NS SOA RRSIG NSEC DNSKEY"))
+		},
+	}
+	for name, strip := range cases {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			f.zones.set("example.", dns.TypeSOA, mustRR(t, "example. 300 IN SOA ns.example. admin.example. 1 2 3 4 5"))
+			// a forged answer the example. keys did not sign
+			f.zones.set("www.example.", dns.TypeA, mustRR(t, "www.example. 300 IN A 192.0.2.66"))
+			strip(f)
+
+			report := f.validator().Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+			if report.Status != dnssec.StatusIndeterminate {
+				t.Fatalf("expected indeterminate, got %+v", report)
+			}
+		})
+	}
+}
+
+func TestValidateNoAnchor(t *testing.T) {
+	f := newFixture(t)
+	anchor := dnssec.TrustAnchor{Zone: "org.", DS: []*dns.DS{f.root.key.ToDS(dns.SHA256)}, Keys: nil}
+	v := dnssec.NewValidator(f.zones, []dnssec.TrustAnchor{anchor})
+	v.Now = func() time.Time { return f.now }
+
+	report := v.Validate(context.Background(), "www.example.", dns.TypeA, "ns", "")
+	if report.Status != dnssec.StatusIndeterminate {
+		t.Fatalf("expected indeterminate, got %+v", report)
+	}
+}
+
+func TestParseTrustAnchors(t *testing.T) {
+	anchors, err := dnssec.ParseTrustAnchors(strings.NewReader(
+		";; comment\n. 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(anchors) != 1 || anchors[0].Zone != "." || len(anchors[0].DS) != 1 {
+		t.Fatalf("unexpected anchors: %+v", anchors)
+	}
+	if _, err = dnssec.ParseTrustAnchors(strings.NewReader("")); err == nil {
+		t.Fatalf("expected error for empty anchors")
+	}
+}
@@ -0,0 +1,134 @@
+package dnssec
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// groupRRsets splits rrs into RRsets keyed by owner name and type, attaching
+// the RRSIGs that cover each set. OPT records are skipped.
+func groupRRsets(rrs []dns.RR) []rrset {
+	var order []string
+	sets := make(map[string]*rrset)
+	key := func(name string, t uint16) string {
+		return dns.CanonicalName(name) + "/" + dns.TypeToString[t]
+	}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeRRSIG || hdr.Rrtype == dns.TypeOPT {
+			continue
+		}
+		k := key(hdr.Name, hdr.Rrtype)
+		set, ok := sets[k]
+		if !ok {
+			set = &rrset{rrs: nil, sigs: nil}
+			sets[k] = set
+			order = append(order, k)
+		}
+		set.rrs = append(set.rrs, rr)
+	}
+	for _, rr := range rrs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		if set, found := sets[key(sig.Hdr.Name, sig.TypeCovered)]; found {
+			set.sigs = append(set.sigs, sig)
+		}
+	}
+	out := make([]rrset, 0, len(order))
+	for _, k := range order {
+		out = append(out, *sets[k])
+	}
+	return out
+}
+
+// filterRRset returns the RRset of rrs owned by name with type qtype.
+func filterRRset(rrs []dns.RR, name string, qtype uint16) rrset {
+	for _, set := range groupRRsets(rrs) {
+		hdr := set.rrs[0].Header()
+		if hdr.Rrtype == qtype && strings.EqualFold(dns.CanonicalName(hdr.Name), dns.CanonicalName(name)) {
+			return set
+		}
+	}
+	return rrset{rrs: nil, sigs: nil}
+}
+
+// verifyRRset checks that at least one of sigs is currently valid and verifies
+// rrs with one of keys. It returns the key tag used, or a reason describing
+// why no signature verified.
+func verifyRRset(rrs []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, now time.Time) (uint16, string) {
+	if len(sigs) == 0 {
+		return 0, "missing RRSIG"
+	}
+	reason := ""
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			reason = validityReason(sig, now)
+			continue
+		}
+		matched := false
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			matched = true
+			if err := sig.Verify(key, rrs); err == nil {
+				return sig.KeyTag, ""
+			}
+		}
+		if matched {
+			reason = fmt.Sprintf("RRSIG with key tag %d failed to verify", sig.KeyTag)
+		} else if reason == "" {
+			reason = fmt.Sprintf("no DNSKEY with key tag %d and algorithm %s", sig.KeyTag,
+				dns.AlgorithmToString[sig.Algorithm])
+		}
+	}
+	return 0, reason
+}
+
+func validityReason(sig *dns.RRSIG, now time.Time) string {
+	const layout = "20060102150405"
+	if int64(sig.Inception) > now.Unix() {
+		return fmt.Sprintf("RRSIG with key tag %d not yet valid (inception %s)",
+			sig.KeyTag, time.Unix(int64(sig.Inception), 0).UTC().Format(layout))
+	}
+	return fmt.Sprintf("RRSIG with key tag %d expired (expiration %s)",
+		sig.KeyTag, time.Unix(int64(sig.Expiration), 0).UTC().Format(layout))
+}
+
+// matchDS returns the keys referenced by a DS record whose digest matches. If
+// none match it returns a reason such as a digest mismatch.
+func matchDS(keys []*dns.DNSKEY, dsRecords []*dns.DS) ([]*dns.DNSKEY, string) {
+	var trusted []*dns.DNSKEY
+	reason := ""
+	for _, ds := range dsRecords {
+		found := false
+		for _, key := range keys {
+			if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+				continue
+			}
+			found = true
+			computed := key.ToDS(ds.DigestType)
+			if computed == nil {
+				reason = fmt.Sprintf("unsupported DS digest type %d for key tag %d", ds.DigestType, ds.KeyTag)
+				continue
+			}
+			if strings.EqualFold(computed.Digest, ds.Digest) {
+				trusted = append(trusted, key)
+			} else {
+				reason = fmt.Sprintf("DS digest mismatch for key tag %d", ds.KeyTag)
+			}
+		}
+		if !found && reason == "" {
+			reason = fmt.Sprintf("no DNSKEY matches DS key tag %d", ds.KeyTag)
+		}
+	}
+	if len(trusted) == 0 && reason == "" {
+		reason = "no DS records to match"
+	}
+	return trusted, reason
+}
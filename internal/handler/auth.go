@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/exiguus/wdns/internal/auth"
+)
+
+// requireScope wraps next with API key authentication when opts.Keys is set.
+// The request must carry an `Authorization: Bearer` key, or present a
+// verified client certificate mapped to a key, granting scope. With
+// charge the request is counted against the key's rate limit and daily
+// quota; handlers that charge per item (/batch and /compare) pass false. The
+// authenticated key is attached to the request context and replaces the
+// per-IP rate limit.
+func requireScope(opts Options, scope string, charge bool, next http.HandlerFunc) http.HandlerFunc {
+	if opts.Keys == nil {
+		return next
+	}
+	return func(writer http.ResponseWriter, req *http.Request) {
+		key, ok := authenticate(opts.Keys, req)
+		if !ok {
+			opts.Logger.WarnContext(req.Context(), "api key rejected",
+				"remote", req.RemoteAddr,
+				"path", req.URL.Path,
+			)
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="wdns"`)
+			writeErrorResponse(writer, http.StatusUnauthorized, emptyRequestPayload(), "missing or invalid api key")
+			return
+		}
+		if !key.HasScope(scope) {
+			opts.Logger.WarnContext(req.Context(), "api key scope denied",
+				"key", key.ID,
+				"scope", scope,
+				"path", req.URL.Path,
+			)
+			writeErrorResponse(writer, http.StatusForbidden, emptyRequestPayload(), `api key lacks the "`+scope+`" scope`)
+			return
+		}
+
+		decision := opts.Keys.Status(key)
+		if charge {
+			decision = opts.Keys.Allow(key)
+		}
+		setRateLimitHeaders(writer, key, decision)
+		if !decision.Allowed {
+			opts.Metrics.ObserveRateLimited("key")
+			opts.Logger.WarnContext(req.Context(), "api key limited",
+				"key", key.ID,
+				"reason", decision.Reason,
+			)
+			writer.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter.Seconds())))
+			writeErrorResponse(writer, http.StatusTooManyRequests, emptyRequestPayload(), decision.Reason)
+			return
+		}
+		next(writer, req.WithContext(auth.WithKey(req.Context(), key)))
+	}
+}
+
+// authenticate resolves the key of req from its Authorization header or,
+// without one, from its verified TLS client certificate.
+func authenticate(keys *auth.Keyring, req *http.Request) (auth.Key, bool) {
+	if header := req.Header.Get("Authorization"); header != "" || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return keys.Authenticate(header)
+	}
+	return keys.AuthenticateCertificate(req.TLS.VerifiedChains[0][0])
+}
+
+// setRateLimitHeaders reports the key identity and, for keys with a daily
+// quota, the quota state.
+func setRateLimitHeaders(writer http.ResponseWriter, key auth.Key, decision auth.Decision) {
+	writer.Header().Set("X-RateLimit-Key", key.ID)
+	if decision.QuotaLimit == 0 {
+		return
+	}
+	writer.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.QuotaLimit))
+	writer.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.QuotaRemaining))
+	writer.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.QuotaReset.Seconds())))
+}
+
+// keyID returns the ID of the API key authenticating ctx, or "".
+func keyID(ctx context.Context) string {
+	key, _ := auth.KeyFromContext(ctx)
+	return key.ID
+}
+
+// ceilSeconds rounds seconds up to a whole number, at least 1.
+func ceilSeconds(seconds float64) int {
+	return max(1, int(math.Ceil(seconds)))
+}
@@ -0,0 +1,162 @@
+package handler_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/ratelimit"
+)
+
+func newAuthServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	keys, err := auth.ParseKeys(strings.NewReader(`[
+		{"id": "reader", "hash": "` + auth.HashSecret("reader-secret") + `", "scopes": ["query"], "daily_quota": 1},
+		{"id": "ops", "hash": "` + auth.HashSecret("ops-secret") + `", "scopes": ["query", "admin"]},
+		{"id": "ci", "subjects": ["cn:ci.example"], "scopes": ["query"]}
+	]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	var last api.RequestPayload
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         recordingResolver{last: &last},
+		Limiter:          ratelimit.NewManager(0, 0), // would refuse every unauthenticated request
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             auth.NewKeyring(keys, auth.Limits{RPS: 100, Burst: 100}),
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doAuthRequest(t *testing.T, method, url, secret string) *http.Response {
+	t.Helper()
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(`{"nameserver":"1.1.1.1","name":"example.com","type":"A"}`)
+	}
+	req, err := http.NewRequestWithContext(t.Context(), method, url, body)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	_ = res.Body.Close()
+	return res
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	srv := newAuthServer(t)
+
+	res := doAuthRequest(t, http.MethodPost, srv.URL+"/query", "")
+	if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected 401 with a challenge, got %d", res.StatusCode)
+	}
+	if res = doAuthRequest(t, http.MethodPost, srv.URL+"/query", "wrong"); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown key, got %d", res.StatusCode)
+	}
+	if res = doAuthRequest(t, http.MethodGet, srv.URL+"/health", ""); res.StatusCode != http.StatusOK {
+		t.Fatalf("health must stay open, got %d", res.StatusCode)
+	}
+
+	res = doAuthRequest(t, http.MethodPost, srv.URL+"/query", "reader-secret")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	if got := res.Header.Get("X-RateLimit-Key"); got != "reader" {
+		t.Errorf("X-RateLimit-Key = %q", got)
+	}
+	if res.Header.Get("X-RateLimit-Limit") != "1" || res.Header.Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("unexpected quota headers: %v", res.Header)
+	}
+
+	res = doAuthRequest(t, http.MethodPost, srv.URL+"/query", "reader-secret")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 after the quota, got %d", res.StatusCode)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	srv := newAuthServer(t)
+
+	if res := doAuthRequest(t, http.MethodGet, srv.URL+"/stats", "reader-secret"); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 without the admin scope, got %d", res.StatusCode)
+	}
+	if res := doAuthRequest(t, http.MethodPost, srv.URL+"/batch", "ops-secret"); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 without the batch scope, got %d", res.StatusCode)
+	}
+	res := doAuthRequest(t, http.MethodGet, srv.URL+"/stats", "ops-secret")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 with the admin scope, got %d", res.StatusCode)
+	}
+	if res.Header.Get("X-RateLimit-Key") != "ops" || res.Header.Get("X-RateLimit-Limit") != "" {
+		t.Errorf("unexpected rate-limit headers: %v", res.Header)
+	}
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	srv := newAuthServer(t)
+	//nolint:exhaustruct // only the subject is matched
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ci.example"}}
+	query := func(state *tls.ConnectionState, secret string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/query",
+			strings.NewReader(`{"nameserver":"1.1.1.1","name":"example.com","type":"A"}`))
+		req.TLS = state
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		rec := httptest.NewRecorder()
+		srv.Config.Handler.ServeHTTP(rec, req)
+		res := rec.Result()
+		_ = res.Body.Close()
+		return res
+	}
+
+	//nolint:exhaustruct // only the verified chains are read
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	res := query(verified, "")
+	if res.StatusCode != http.StatusOK || res.Header.Get("X-RateLimit-Key") != "ci" {
+		t.Fatalf("expected 200 as ci, got %d %q", res.StatusCode, res.Header.Get("X-RateLimit-Key"))
+	}
+	if res = query(verified, "ops-secret"); res.Header.Get("X-RateLimit-Key") != "ops" {
+		t.Fatalf("an Authorization header must take precedence, got %q", res.Header.Get("X-RateLimit-Key"))
+	}
+	//nolint:exhaustruct // a handshake without a client certificate
+	if res = query(&tls.ConnectionState{}, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a certificate, got %d", res.StatusCode)
+	}
+}
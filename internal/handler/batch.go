@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/auth"
+)
+
+const (
+	maxBatchBodyBytes = 8 << 20
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// makeBatchHandler returns the `/batch` handler. It accepts a JSON array or an
+// NDJSON stream of RequestPayloads, executes them with bounded concurrency and
+// streams one ResponsePayload per item back as NDJSON in completion order.
+// Every item is charged against the rate limiter individually.
+func makeBatchHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		opts.Logger.InfoContext(req.Context(), "http request",
+			"method", req.Method,
+			"remote", req.RemoteAddr,
+			"path", req.URL.Path,
+			"key", keyID(req.Context()),
+		)
+
+		if req.Method != http.MethodPost {
+			writeErrorResponse(writer, http.StatusMethodNotAllowed, emptyRequestPayload(), "Method not allowed")
+			return
+		}
+
+		items, err := decodeBatch(http.MaxBytesReader(writer, req.Body, maxBatchBodyBytes), opts.BatchMaxItems)
+		if err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, emptyRequestPayload(), err.Error())
+			return
+		}
+
+		clientIP := clientIP(req, opts)
+		limitKey := rateLimitKey(req, opts)
+		opts.Logger.InfoContext(req.Context(), "batch payload",
+			"items", len(items),
+			"client", clientIP,
+		)
+
+		writer.Header().Set("Content-Type", ndjsonContentType)
+		rc := http.NewResponseController(writer)
+		// batches may legitimately outlive the server's WriteTimeout
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		enc := json.NewEncoder(writer)
+		started := false
+		for resp := range runBatch(req.Context(), opts, items, limitKey, clientIP) {
+			if !started {
+				// the headers wait for the first item so that a batch the
+				// pool refuses carries Retry-After like /query
+				started = true
+				if resp.Status == http.StatusServiceUnavailable {
+					setRetryAfter(writer, opts)
+				}
+				writer.WriteHeader(http.StatusOK)
+			}
+			if encErr := enc.Encode(resp); encErr != nil {
+				// client went away; keep draining so workers can finish
+				continue
+			}
+			_ = rc.Flush()
+		}
+	}
+}
+
+// runBatch executes items with at most opts.BatchConcurrency in flight and
+// returns a channel that yields each response as it completes. The channel is
+// closed once every started item has finished.
+func runBatch(
+	ctx context.Context,
+	opts Options,
+	items []api.RequestPayload,
+	limitKey, clientIP string,
+) <-chan api.ResponsePayload {
+	results := make(chan api.ResponsePayload)
+	go func() {
+		defer close(results)
+		sem := make(chan struct{}, opts.BatchConcurrency)
+		var wg sync.WaitGroup
+	loop:
+		for i, item := range items {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break loop
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				resp := runBatchItem(ctx, opts, item, limitKey, clientIP)
+				resp.Index = &i
+				results <- resp
+			}()
+		}
+		wg.Wait()
+	}()
+	return results
+}
+
+// runBatchItem charges, validates and executes a single batch item. Items of
+// requests authenticated by API key are charged against the key instead of
+// the client's rate-limit bucket.
+func runBatchItem(
+	ctx context.Context,
+	opts Options,
+	item api.RequestPayload,
+	limitKey, clientIP string,
+) api.ResponsePayload {
+	if key, ok := auth.KeyFromContext(ctx); ok && opts.Keys != nil {
+		if decision := opts.Keys.Allow(key); !decision.Allowed {
+			opts.Metrics.ObserveRateLimited("key")
+			return newErrorResponse(http.StatusTooManyRequests, item, decision.Reason)
+		}
+	} else if !allowClient(ctx, opts, limitKey) {
+		return newErrorResponse(http.StatusTooManyRequests, item, "rate limit exceeded")
+	}
+	var err error
+	if item.Transport, err = upstreamTransport(opts, item.Nameserver, item.Transport); err != nil {
+		return newErrorResponse(http.StatusBadRequest, item, err.Error())
+	}
+	if ok, status, msg := api.Validate(item); !ok {
+		return newErrorResponse(status, item, msg)
+	}
+	return executeQuery(ctx, opts, item, clientIP)
+}
+
+// decodeBatch reads either a JSON array of payloads or a stream of
+// newline-delimited payloads, rejecting more than maxItems entries.
+func decodeBatch(body io.Reader, maxItems int) ([]api.RequestPayload, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err != nil {
+		return nil, errors.New("batch must not be empty")
+	}
+
+	dec := json.NewDecoder(reader)
+	var items []api.RequestPayload
+	appendItem := func() error {
+		if len(items) >= maxItems {
+			return errors.New("batch must not contain more than " + strconv.Itoa(maxItems) + " items")
+		}
+		var item api.RequestPayload
+		if decErr := dec.Decode(&item); decErr != nil {
+			return fmt.Errorf("item %d: %w", len(items), decErr)
+		}
+		items = append(items, item)
+		return nil
+	}
+
+	if first == '[' {
+		if _, err = dec.Token(); err != nil {
+			return nil, fmt.Errorf("read batch array: %w", err)
+		}
+		for dec.More() {
+			if err = appendItem(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = dec.Token(); err != nil {
+			return nil, fmt.Errorf("read batch array: %w", err)
+		}
+	} else {
+		for dec.More() {
+			if err = appendItem(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("batch must not be empty")
+	}
+	return items, nil
+}
+
+// peekNonSpace skips leading whitespace and returns the next byte without
+// consuming it.
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return b, reader.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/ratelimit"
+)
+
+// stubResolver answers every query with a fixed short answer.
+type stubResolver struct{}
+
+func (stubResolver) Run(_ context.Context, req api.RequestPayload) ([]byte, string, error) {
+	return []byte("192.0.2.1\n"), "kdig @" + req.Nameserver + " " + req.Name, nil
+}
+
+func (stubResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func newTestServer(t *testing.T, limiter *ratelimit.Manager) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         stubResolver{},
+		Limiter:          limiter,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 2,
+		BatchMaxItems:    5,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func readNDJSON(t *testing.T, body io.Reader) []api.ResponsePayload {
+	t.Helper()
+	var out []api.ResponsePayload
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var resp api.ResponsePayload
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid ndjson line %q: %v", scanner.Text(), err)
+		}
+		out = append(out, resp)
+	}
+	return out
+}
+
+func TestBatchArray(t *testing.T) {
+	srv := newTestServer(t, nil)
+	body := `[{"nameserver":"1.1.1.1","name":"a.example","type":"A"},
+		{"nameserver":"1.1.1.1","name":"b.example","type":"BOGUS"},
+		{"nameserver":"1.1.1.1","name":"c.example","type":"MX"}]`
+
+	res, err := http.Post(srv.URL+"/batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	results := readNDJSON(t, res.Body)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	seen := make(map[int]api.ResponsePayload)
+	for _, r := range results {
+		if r.Index == nil {
+			t.Fatalf("missing index in %+v", r)
+		}
+		seen[*r.Index] = r
+	}
+	if !seen[0].Success || !seen[2].Success {
+		t.Fatalf("expected items 0 and 2 to succeed: %+v", seen)
+	}
+	if seen[1].Success || seen[1].Status != http.StatusBadRequest {
+		t.Fatalf("expected item 1 to fail validation: %+v", seen[1])
+	}
+}
+
+func TestBatchNDJSONRateLimited(t *testing.T) {
+	srv := newTestServer(t, ratelimit.NewManager(0.001, 2))
+	body := `{"nameserver":"1.1.1.1","name":"a.example","type":"A"}
+{"nameserver":"1.1.1.1","name":"b.example","type":"A"}
+{"nameserver":"1.1.1.1","name":"c.example","type":"A"}
+`
+	res, err := http.Post(srv.URL+"/batch", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+
+	limited := 0
+	for _, r := range readNDJSON(t, res.Body) {
+		if r.Status == http.StatusTooManyRequests {
+			limited++
+		}
+	}
+	if limited != 1 {
+		t.Fatalf("expected exactly one rate-limited item, got %d", limited)
+	}
+}
+
+func TestBatchTooManyItems(t *testing.T) {
+	srv := newTestServer(t, nil)
+	item := `{"nameserver":"1.1.1.1","name":"a.example","type":"A"}`
+	body := "[" + strings.Repeat(item+",", 5) + item + "]"
+
+	res, err := http.Post(srv.URL+"/batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", res.StatusCode)
+	}
+}
@@ -6,6 +6,15 @@ import (
 	"strings"
 )
 
+// DefaultClientIPHeaders are the forwarding headers consulted, in order, when
+// Options.ClientIPHeaders is empty. Only X-Forwarded-For is trusted by
+// default: common proxies such as nginx and cloud load balancers append to
+// it but pass `Forwarded` and `X-Real-IP` from the client through unchanged,
+// so those must be enabled explicitly where the proxy sets them.
+func DefaultClientIPHeaders() []string {
+	return []string{"X-Forwarded-For"}
+}
+
 // ipInNets reports whether ip is contained in any of the nets.
 func ipInNets(ip net.IP, nets []*net.IPNet) bool {
 	if ip == nil {
@@ -20,46 +29,161 @@ func ipInNets(ip net.IP, nets []*net.IPNet) bool {
 }
 
 // ClientIP extracts the client IP address from the request considering a list
-// of trusted proxies. If trusted is nil or empty, it falls back to req.RemoteAddr.
-// The extraction order is: `X-Forwarded-For` (left-most non-proxy), `X-Real-IP`,
-// then the connection remote address.
+// of trusted proxies, using DefaultClientIPHeaders. See ClientIPFrom.
 func ClientIP(req *http.Request, trusted []*net.IPNet) string {
-	// Helper to parse host:port
-	hostFromRemote := func() string {
-		host, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err != nil {
-			return req.RemoteAddr
+	return ClientIPFrom(req, trusted, nil)
+}
+
+// ClientIPFrom extracts the client address from the request. Forwarding
+// headers are only honoured when the connection comes from a trusted proxy;
+// otherwise, or when trusted is empty, the remote address is returned.
+//
+// headers are consulted in order and the first one present decides.
+// `Forwarded` (RFC 7239) and `X-Forwarded-For` list one entry per hop and are
+// walked from the right, skipping trusted proxies, so that addresses a client
+// prepends itself are never reached. Any other header (e.g. `X-Real-IP`,
+// `CF-Connecting-IP`, `True-Client-IP`) holds a single address, and the remote
+// address is returned when it does not parse as one. An RFC 7239 obfuscated
+// identifier such as "_hidden" is returned as is; an "unknown" or malformed
+// entry stops the walk at the nearest trusted hop.
+func ClientIPFrom(req *http.Request, trusted []*net.IPNet, headers []string) string {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if len(trusted) == 0 || !ipInNets(net.ParseIP(remote), trusted) {
+		return remote
+	}
+	if len(headers) == 0 {
+		headers = DefaultClientIPHeaders()
+	}
+
+	for _, name := range headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Forwarded":
+			return walkHops(forwardedFor(values), trusted, remote)
+		case "X-Forwarded-For":
+			return walkHops(splitList(values), trusted, remote)
+		default:
+			if ip := parseNode(strings.TrimSpace(values[len(values)-1])); ip != nil {
+				return ip.String()
+			}
+			return remote
+		}
+	}
+	return remote
+}
+
+// walkHops returns the right-most hop that is not a trusted proxy. nearest is
+// the closest trusted hop, returned when no usable client entry is found.
+func walkHops(hops []string, trusted []*net.IPNet, nearest string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		node := strings.TrimSpace(hops[i])
+		if strings.HasPrefix(node, "_") {
+			return node
+		}
+		ip := parseNode(node)
+		switch {
+		case ip == nil:
+			// "unknown" or garbage: nothing left of it can be trusted
+			return nearest
+		case ipInNets(ip, trusted):
+			nearest = ip.String()
+		default:
+			return ip.String()
 		}
-		return host
 	}
+	return nearest
+}
+
+// parseNode parses a hop address with optional port: "192.0.2.1",
+// "192.0.2.1:8080", "2001:db8::1" or "[2001:db8::1]:8080".
+func parseNode(node string) net.IP {
+	if ip := net.ParseIP(node); ip != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	}
+	return net.ParseIP(host)
+}
 
-	// If no trusted proxies configured, return the direct remote host.
-	if len(trusted) == 0 {
-		return hostFromRemote()
+// splitList splits comma-separated header values into their elements.
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for part := range strings.SplitSeq(v, ",") {
+			out = append(out, strings.TrimSpace(part))
+		}
 	}
+	return out
+}
 
-	// X-Forwarded-For: left-most is original client
-	if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		for _, p := range parts {
-			ipStr := strings.TrimSpace(p)
-			ip := net.ParseIP(ipStr)
-			if ip == nil {
-				continue
+// forwardedFor returns the "for" parameter of every element of RFC 7239
+// Forwarded header values, in order. Elements without one yield "unknown".
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, element := range splitQuoted(v, ',') {
+			node := "unknown"
+			for _, pair := range splitQuoted(element, ';') {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(key), "for") {
+					node = unquote(strings.TrimSpace(value))
+				}
 			}
-			if !ipInNets(ip, trusted) {
-				return ipStr
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// splitQuoted splits s on sep outside of double-quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var out []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				out = append(out, s[start:i])
+				start = i + 1
 			}
+		default:
 		}
 	}
+	return append(out, s[start:])
+}
 
-	// X-Real-IP
-	if xr := strings.TrimSpace(req.Header.Get("X-Real-IP")); xr != "" {
-		if ip := net.ParseIP(xr); ip != nil && !ipInNets(ip, trusted) {
-			return xr
+// unquote removes the quotes and backslash escapes of an RFC 7230
+// quoted-string; other values are returned unchanged.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	inner := s[1 : len(s)-1]
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
 		}
+		b.WriteByte(inner[i])
 	}
+	return b.String()
+}
 
-	// Fallback to remote address
-	return hostFromRemote()
+// clientIP extracts the client address using the proxies and headers of opts.
+func clientIP(req *http.Request, opts Options) string {
+	return ClientIPFrom(req, opts.TrustedProxies, opts.ClientIPHeaders)
 }
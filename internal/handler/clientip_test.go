@@ -31,3 +31,76 @@ func TestClientIP_NoTrusted(t *testing.T) {
 		t.Fatalf("expected remote addr host 192.0.2.1, got %q", ip)
 	}
 }
+
+func TestClientIP_RightMostUntrusted(t *testing.T) {
+	_, trustedNet, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{trustedNet}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"spoofed prefix ignored", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "6.6.6.6, 203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"untrusted peer ignores headers", "198.51.100.7:1", map[string]string{"X-Forwarded-For": "203.0.113.5"}, "198.51.100.7"},
+		{"all hops trusted", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"garbage stops the walk", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "203.0.113.5, bogus, 10.0.0.2"}, "10.0.0.2"},
+		{"xff with port", "10.0.0.1:1", map[string]string{"X-Forwarded-For": "[2001:db8::1]:443"}, "2001:db8::1"},
+		{"forwarded ipv6", "10.0.0.1:1", map[string]string{"Forwarded": `for=6.6.6.6, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`}, "2001:db8:cafe::17"},
+		{"forwarded obfuscated", "10.0.0.1:1", map[string]string{"Forwarded": `for="_gazonk";by=10.0.0.1`}, "_gazonk"},
+		{"forwarded unknown", "10.0.0.1:1", map[string]string{"Forwarded": `for=unknown, for=10.0.0.2`}, "10.0.0.2"},
+		{"forwarded preferred", "10.0.0.1:1", map[string]string{"Forwarded": "for=192.0.2.60", "X-Forwarded-For": "192.0.2.61"}, "192.0.2.60"},
+		{"real ip", "10.0.0.1:1", map[string]string{"X-Real-IP": "192.0.2.62"}, "192.0.2.62"},
+		{"real ip with port", "10.0.0.1:1", map[string]string{"X-Real-IP": "192.0.2.62:8080"}, "192.0.2.62"},
+		{"real ip garbage", "10.0.0.1:1", map[string]string{"X-Real-IP": "_spoofed"}, "10.0.0.1"},
+		{"no headers", "10.0.0.1:1", nil, "10.0.0.1"},
+	}
+	// Forwarded and X-Real-IP are opt-in
+	headers := []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+	for _, tc := range cases {
+		req := &http.Request{Header: make(http.Header), RemoteAddr: tc.remote}
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if got := handler.ClientIPFrom(req, trusted, headers); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClientIP_DefaultIgnoresClientHeaders(t *testing.T) {
+	_, trustedNet, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{trustedNet}
+
+	// nginx appends the peer to X-Forwarded-For and passes the client's own
+	// Forwarded and X-Real-IP headers through
+	req := &http.Request{Header: make(http.Header), RemoteAddr: "10.0.0.1:1"}
+	req.Header.Set("Forwarded", "for=6.6.6.6")
+	req.Header.Set("X-Real-IP", "6.6.6.7")
+	req.Header.Set("X-Forwarded-For", "203.0.113.5")
+	if got := handler.ClientIP(req, trusted); got != "203.0.113.5" {
+		t.Fatalf("expected the proxy-appended X-Forwarded-For entry, got %q", got)
+	}
+
+	req.Header.Del("X-Forwarded-For")
+	if got := handler.ClientIP(req, trusted); got != "10.0.0.1" {
+		t.Fatalf("expected the proxy address without X-Forwarded-For, got %q", got)
+	}
+}
+
+func TestClientIPFrom_ConfiguredHeaders(t *testing.T) {
+	_, trustedNet, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{trustedNet}
+
+	req := &http.Request{Header: make(http.Header), RemoteAddr: "10.0.0.1:1"}
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	req.Header.Set("CF-Connecting-IP", "203.0.113.9")
+
+	if got := handler.ClientIPFrom(req, trusted, []string{"CF-Connecting-IP"}); got != "203.0.113.9" {
+		t.Fatalf("expected CF-Connecting-IP, got %q", got)
+	}
+	if got := handler.ClientIPFrom(req, trusted, []string{"True-Client-IP"}); got != "10.0.0.1" {
+		t.Fatalf("expected the proxy address without the configured header, got %q", got)
+	}
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/compare"
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+func emptyCompareRequest() api.CompareRequest {
+	return api.CompareRequest{Servers: nil, Name: "", Type: "A", DNSSEC: false}
+}
+
+func writeCompareError(writer http.ResponseWriter, status int, req api.CompareRequest, msg string) {
+	writeJSONBody(writer, status, api.CompareResponse{
+		Status:    status,
+		Success:   false,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Request:   req,
+		Results:   nil,
+		Diff:      nil,
+		Error:     msg,
+	})
+}
+
+// makeCompareHandler returns the `/compare` handler which runs one query
+// against several nameservers concurrently and diffs their answers. Every
+// server is charged against the rate limiter, like a /batch item.
+func makeCompareHandler(opts Options) http.HandlerFunc {
+	resolverRunner, logger := opts.Resolver, opts.Logger
+	return func(writer http.ResponseWriter, req *http.Request) {
+		logger.InfoContext(req.Context(), "http request",
+			"method", req.Method,
+			"remote", req.RemoteAddr,
+			"path", req.URL.Path,
+			"key", keyID(req.Context()),
+		)
+
+		if req.Method != http.MethodPost {
+			writeCompareError(writer, http.StatusMethodNotAllowed, emptyCompareRequest(), "Method not allowed")
+			return
+		}
+
+		var payload api.CompareRequest
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			writeCompareError(writer, http.StatusBadRequest, emptyCompareRequest(), err.Error())
+			return
+		}
+		for i, server := range payload.Servers {
+			transport, err := upstreamTransport(opts, server.Nameserver, server.Transport)
+			if err != nil {
+				writeCompareError(writer, http.StatusBadRequest, payload, "servers["+strconv.Itoa(i)+"]: "+err.Error())
+				return
+			}
+			payload.Servers[i].Transport = transport
+		}
+		if ok, status, msg := api.ValidateCompare(payload); !ok {
+			writeCompareError(writer, status, payload, msg)
+			return
+		}
+		if !chargeCompare(writer, req, opts, payload) {
+			return
+		}
+		ctx := req.Context()
+		for _, server := range payload.Servers {
+			var err error
+			if ctx, err = checkNameserver(ctx, opts, server.Nameserver, server.Transport); err != nil {
+				writeCompareError(writer, http.StatusForbidden, payload, err.Error())
+				return
+			}
+		}
+
+		logger.InfoContext(req.Context(), "compare payload",
+			"servers", len(payload.Servers),
+			"name", payload.Name,
+			"type", payload.Type,
+			"dnssec", payload.DNSSEC,
+			"client", clientIP(req, opts),
+		)
+
+		ctx, cancel := context.WithTimeout(ctx, resolverRunner.QueryTimeout()+1*time.Second)
+		defer cancel()
+
+		results := compare.Run(ctx, resolverRunner, payload)
+		diff := compare.Diff(results)
+
+		success, overloaded := false, false
+		for _, r := range results {
+			success = success || r.Success
+			overloaded = overloaded || r.Overloaded
+			status := http.StatusOK
+			switch {
+			case r.Overloaded:
+				status = http.StatusServiceUnavailable
+			case !r.Success:
+				status = http.StatusInternalServerError
+			default:
+			}
+			opts.Metrics.ObserveQuery(status, rrtype.Canonical(payload.Type), r.Transport)
+		}
+		status := http.StatusOK
+		if !success {
+			status = http.StatusInternalServerError
+			if overloaded {
+				status = http.StatusServiceUnavailable
+				setRetryAfter(writer, opts)
+			}
+		}
+		writeJSONBody(writer, status, api.CompareResponse{
+			Status:    status,
+			Success:   success,
+			Timestamp: time.Now().Format(time.RFC3339),
+			Request:   payload,
+			Results:   results,
+			Diff:      diff,
+			Error:     "",
+		})
+	}
+}
+
+// chargeCompare charges one request per server of payload against the API
+// key authenticating req or, without one, the client's rate-limit bucket.
+// Either every server is charged or none, and the request is refused.
+func chargeCompare(writer http.ResponseWriter, req *http.Request, opts Options, payload api.CompareRequest) bool {
+	servers := len(payload.Servers)
+	if key, ok := auth.KeyFromContext(req.Context()); ok && opts.Keys != nil {
+		decision := opts.Keys.AllowN(key, servers)
+		setRateLimitHeaders(writer, key, decision)
+		if !decision.Allowed {
+			opts.Metrics.ObserveRateLimited("key")
+			writer.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter.Seconds())))
+			writeCompareError(writer, http.StatusTooManyRequests, payload, decision.Reason)
+			return false
+		}
+		return true
+	}
+	if opts.Limiter != nil && !opts.Limiter.AllowN(rateLimitKey(req, opts), servers) {
+		opts.Metrics.ObserveRateLimited("client")
+		writer.Header().Set("Retry-After", "1")
+		writeCompareError(writer, http.StatusTooManyRequests, payload,
+			"rate limit exceeded: comparing "+strconv.Itoa(servers)+" servers takes as many requests")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+const (
+	dohContentType = "application/dns-message"
+	// maxDNSMessageSize is the largest DNS message (RFC 8484 section 6).
+	maxDNSMessageSize = 65535
+)
+
+var (
+	errDoHMissingParam = errors.New(`missing "dns" query parameter`)
+	errDoHBase64       = errors.New(`invalid base64url in "dns" parameter`)
+	errDoHContentType  = errors.New("content type must be " + dohContentType)
+	errDoHTooLarge     = errors.New("request body too large")
+	errDoHMethod       = errors.New("method not allowed")
+	errDoHQuestion     = errors.New("query must contain exactly one question")
+)
+
+// makeDoHHandler returns the RFC 8484 `/dns-query` handler. It accepts GET
+// with a base64url `dns` parameter and POST with an application/dns-message
+// body and forwards the message unchanged to opts.DoHUpstream.
+func makeDoHHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if !allowClient(req.Context(), opts, rateLimitKey(req, opts)) {
+			writer.Header().Set("Retry-After", "1")
+			http.Error(writer, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		msg, status, err := readDoHRequest(writer, req)
+		if err != nil {
+			http.Error(writer, err.Error(), status)
+			return
+		}
+
+		q := msg.Question[0]
+		clientIP := clientIP(req, opts)
+		opts.Logger.InfoContext(req.Context(), "doh query",
+			"method", req.Method,
+			"name", q.Name,
+			"type", dns.TypeToString[q.Qtype],
+			"upstream", opts.DoHUpstream,
+			"client", clientIP,
+		)
+
+		writeDoHResponse(writer, forwardDoH(req, opts, msg))
+	}
+}
+
+// readDoHRequest decodes the DNS message from a DoH request. On failure it
+// returns the HTTP status to reply with.
+func readDoHRequest(writer http.ResponseWriter, req *http.Request) (*dns.Msg, int, error) {
+	var wire []byte
+	switch req.Method {
+	case http.MethodGet:
+		param := req.URL.Query().Get("dns")
+		if param == "" {
+			return nil, http.StatusBadRequest, errDoHMissingParam
+		}
+		// RFC 8484 uses unpadded base64url; tolerate padding from lax clients.
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(param, "="))
+		if err != nil {
+			return nil, http.StatusBadRequest, errDoHBase64
+		}
+		wire = decoded
+	case http.MethodPost:
+		if ct := req.Header.Get("Content-Type"); ct != dohContentType {
+			return nil, http.StatusUnsupportedMediaType, errDoHContentType
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(writer, req.Body, maxDNSMessageSize))
+		if err != nil {
+			return nil, http.StatusRequestEntityTooLarge, errDoHTooLarge
+		}
+		wire = body
+	default:
+		writer.Header().Set("Allow", "GET, POST")
+		return nil, http.StatusMethodNotAllowed, errDoHMethod
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(wire); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed DNS message: %w", err)
+	}
+	if len(msg.Question) != 1 {
+		return nil, http.StatusBadRequest, errDoHQuestion
+	}
+	return msg, http.StatusOK, nil
+}
+
+// forwardDoH sends msg to the configured upstream. Zone transfers are refused
+// and upstream failures are answered with SERVFAIL so that stub resolvers get
+// a DNS-level error instead of an HTTP one. Forwarded queries are counted
+// with the status /query would have answered them with.
+func forwardDoH(req *http.Request, opts Options, msg *dns.Msg) *dns.Msg {
+	rrType := rrtype.ByCode(msg.Question[0].Qtype)
+	if rrType.ZoneTransfer {
+		return new(dns.Msg).SetRcode(msg, dns.RcodeRefused)
+	}
+	resp, err := opts.Exchanger.Exchange(req.Context(), msg, opts.DoHUpstream, opts.DoHTransport)
+	if err != nil {
+		opts.Logger.WarnContext(req.Context(), "doh upstream failed",
+			"upstream", opts.DoHUpstream,
+			"error", err,
+		)
+		opts.Metrics.ObserveQuery(http.StatusInternalServerError, rrType.Name, opts.DoHTransport)
+		return new(dns.Msg).SetRcode(msg, dns.RcodeServerFailure)
+	}
+	opts.Metrics.ObserveQuery(http.StatusOK, rrType.Name, opts.DoHTransport)
+	return resp
+}
+
+func writeDoHResponse(writer http.ResponseWriter, resp *dns.Msg) {
+	wire, err := resp.Pack()
+	if err != nil {
+		http.Error(writer, "failed to pack DNS response", http.StatusBadGateway)
+		return
+	}
+	writer.Header().Set("Content-Type", dohContentType)
+	if ttl, ok := minTTL(resp); ok {
+		writer.Header().Set("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(wire)
+}
+
+// minTTL returns the smallest TTL of the response records, which bounds the
+// HTTP freshness lifetime (RFC 8484 section 5.1). OPT records are ignored.
+func minTTL(resp *dns.Msg) (uint32, bool) {
+	lowest := uint32(math.MaxUint32)
+	found := false
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			lowest = min(lowest, rr.Header().Ttl)
+			found = true
+		}
+	}
+	return lowest, found
+}
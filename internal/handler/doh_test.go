@@ -0,0 +1,187 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/ratelimit"
+)
+
+// stubExchanger answers every query with a single A record, or fails when
+// fail is set.
+type stubExchanger struct {
+	fail bool
+}
+
+func (s stubExchanger) Exchange(_ context.Context, msg *dns.Msg, _, _ string) (*dns.Msg, error) {
+	if s.fail {
+		return nil, errors.New("upstream unreachable")
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	rr, err := dns.NewRR(msg.Question[0].Name + " 120 IN A 192.0.2.1")
+	if err != nil {
+		return nil, err
+	}
+	resp.Answer = []dns.RR{rr}
+	return resp, nil
+}
+
+func newDoHServer(t *testing.T, exchanger stubExchanger, limiter *ratelimit.Manager) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         stubResolver{},
+		Limiter:          limiter,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "192.0.2.53",
+		DoHTransport:     "",
+		Exchanger:        exchanger,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func packQuery(t *testing.T, name string, qtype uint16) []byte {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.Id = 0
+	wire, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+	return wire
+}
+
+func readDNSResponse(t *testing.T, res *http.Response) *dns.Msg {
+	t.Helper()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/dns-message" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(body); err != nil {
+		t.Fatalf("unpack: %v", err)
+	}
+	return msg
+}
+
+func TestDoHGet(t *testing.T) {
+	srv := newDoHServer(t, stubExchanger{fail: false}, nil)
+	query := base64.RawURLEncoding.EncodeToString(packQuery(t, "example.com.", dns.TypeA))
+
+	res, err := http.Get(srv.URL + "/dns-query?dns=" + query)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	defer res.Body.Close()
+	msg := readDNSResponse(t, res)
+	if len(msg.Answer) != 1 || msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("unexpected response: %v", msg)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "max-age=120" {
+		t.Fatalf("unexpected Cache-Control %q", cc)
+	}
+}
+
+func TestDoHPost(t *testing.T) {
+	srv := newDoHServer(t, stubExchanger{fail: true}, nil)
+
+	res, err := http.Post(srv.URL+"/dns-query", "application/dns-message",
+		bytes.NewReader(packQuery(t, "example.com.", dns.TypeAAAA)))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	if msg := readDNSResponse(t, res); msg.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[msg.Rcode])
+	}
+}
+
+func TestDoHRejectsBadRequests(t *testing.T) {
+	srv := newDoHServer(t, stubExchanger{fail: false}, nil)
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{"missing param", http.MethodGet, "/dns-query", "", nil, http.StatusBadRequest},
+		{"bad base64", http.MethodGet, "/dns-query?dns=!!!", "", nil, http.StatusBadRequest},
+		{"bad content type", http.MethodPost, "/dns-query", "application/json", []byte("{}"), http.StatusUnsupportedMediaType},
+		{"malformed message", http.MethodPost, "/dns-query", "application/dns-message", []byte{1, 2, 3}, http.StatusBadRequest},
+		{"method", http.MethodPut, "/dns-query", "", nil, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), tt.method, srv.URL+tt.url, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("new request: %v", err)
+			}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestDoHRateLimited(t *testing.T) {
+	srv := newDoHServer(t, stubExchanger{fail: false}, ratelimit.NewManager(0.001, 1))
+	query := base64.RawURLEncoding.EncodeToString(packQuery(t, "example.com.", dns.TypeA))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		res, err := http.Get(srv.URL + "/dns-query?dns=" + query)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("request %d: expected %d, got %d", i, want, res.StatusCode)
+		}
+	}
+}
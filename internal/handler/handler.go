@@ -4,48 +4,199 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/cache"
+	"github.com/exiguus/wdns/internal/coalesce"
+	"github.com/exiguus/wdns/internal/config"
+	"github.com/exiguus/wdns/internal/dnssec"
+	"github.com/exiguus/wdns/internal/health"
+	"github.com/exiguus/wdns/internal/metrics"
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/pool"
 	"github.com/exiguus/wdns/internal/ratelimit"
 	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/rrtype"
+	"github.com/exiguus/wdns/internal/telemetry"
+	"github.com/exiguus/wdns/internal/trace"
+	"github.com/exiguus/wdns/internal/upstream"
 )
 
-// Register registers the /query HTTP handler on the provided mux using the given
-// resolver runner, optional rate limiter and optional list of trusted proxies.
-// Passing a nil limiter disables rate limiting. Passing nil for trustedProxies
-// means header-based client extraction is disabled and req.RemoteAddr will be
-// used for rate limiting.
-func Register(
-	mux *http.ServeMux,
-	resolverRunner *resolver.Runner,
-	limiter *ratelimit.Manager,
-	trustedProxies []*net.IPNet,
-	logger *slog.Logger,
-) {
-	mux.HandleFunc("/query", makeQueryHandler(resolverRunner, limiter, trustedProxies, logger))
-	// Healthcheck endpoint for readiness/liveness probes
-	mux.HandleFunc("/healthz", makeHealthHandler(logger))
-	mux.HandleFunc("/health", makeHealthHandler(logger))
+// Options configures the handlers installed by Register.
+type Options struct {
+	// Resolver executes DNS queries.
+	Resolver resolver.Resolver
+	// Limiter enables per-client rate limiting. Nil disables rate limiting.
+	Limiter *ratelimit.Manager
+	// TrustedProxies enables header-based client IP extraction for
+	// connections from these networks. When empty, req.RemoteAddr is used for
+	// rate limiting.
+	TrustedProxies []*net.IPNet
+	// ClientIPHeaders lists the forwarding headers trusted proxies set, in
+	// order of preference (default DefaultClientIPHeaders).
+	ClientIPHeaders []string
+	// Logger receives request-level logs.
+	Logger *slog.Logger
+	// BatchConcurrency bounds the number of /batch items executed at once
+	// (default 4).
+	BatchConcurrency int
+	// BatchMaxItems caps the number of items accepted by /batch (default 1000).
+	BatchMaxItems int
+	// Validator performs DNSSEC validation for requests that set "validate".
+	// Nil rejects such requests.
+	Validator *dnssec.Validator
+	// Tracer performs iterative resolution for requests that set "trace".
+	// Nil rejects such requests.
+	Tracer *trace.Tracer
+	// DoHUpstream is the nameserver the DoH-style endpoints /dns-query and
+	// /resolve forward to. Empty disables both endpoints.
+	DoHUpstream string
+	// DoHTransport is the transport used to reach DoHUpstream.
+	DoHTransport string
+	// Exchanger forwards wire-format messages for /dns-query.
+	Exchanger resolver.Exchanger
+	// Cache is the response cache wrapping Resolver, if enabled. It is only
+	// used to report statistics on /stats.
+	Cache *cache.Cache
+	// Coalescer is the in-flight deduplication layer of Resolver, if any. It
+	// is only used to report statistics on /stats.
+	Coalescer *coalesce.Coalescer
+	// Pool bounds concurrent executions of Resolver, if enabled. It is used
+	// to report gauges on /stats and to derive Retry-After when overloaded.
+	Pool *pool.Pool
+	// Policy restricts the nameservers user requests may query. Nil allows
+	// every nameserver.
+	Policy *policy.Policy
+	// Upstreams lists the named upstream profiles requests may use as
+	// "nameserver". Profiles are operator-configured and bypass Policy.
+	Upstreams *upstream.Catalog
+	// Keys enables API key authentication. Nil leaves the API open and rate
+	// limited per client IP only.
+	Keys *auth.Keyring
+	// Metrics enables GET /metrics and request instrumentation. Nil disables
+	// both.
+	Metrics *metrics.Metrics
+	// Reload enables POST /admin/reload. Nil disables it.
+	Reload ReloadFunc
+	// Draining is set once the server is shutting down; the health endpoints
+	// then answer 503 so that load balancers stop sending new requests. Nil
+	// never drains.
+	Draining *atomic.Bool
+	// Health runs the readiness checks of /readyz. Nil reports ready unless
+	// draining.
+	Health *health.Checker
+}
+
+// Register registers the /query, /compare and /batch HTTP handlers, the
+// /dns-query and /resolve DoH endpoints when an upstream is configured, the
+// /upstreams and /stats endpoints, /metrics when metrics are enabled,
+// /admin/reload when reloading is enabled and API keys are configured, and
+// the health endpoints on the provided mux.
+func Register(mux *http.ServeMux, opts Options) {
+	opts = withDefaults(opts)
+	registerAPI(mux, opts)
+	registerAdmin(mux, opts, false)
+	registerHealth(mux, opts)
+}
+
+// RegisterAPI registers the query endpoints and the health endpoints, for
+// servers whose admin endpoints are registered on another mux with
+// RegisterAdmin.
+func RegisterAPI(mux *http.ServeMux, opts Options) {
+	opts = withDefaults(opts)
+	registerAPI(mux, opts)
+	registerHealth(mux, opts)
+}
+
+// RegisterAdmin registers /stats, /metrics when metrics are enabled,
+// /admin/reload when reloading is enabled and the health endpoints, for a
+// separate admin listener.
+func RegisterAdmin(mux *http.ServeMux, opts Options) {
+	opts = withDefaults(opts)
+	registerAdmin(mux, opts, true)
+	registerHealth(mux, opts)
+}
+
+func withDefaults(opts Options) Options {
+	if opts.BatchConcurrency <= 0 {
+		opts.BatchConcurrency = config.DefaultBatchConcurrency
+	}
+	if opts.BatchMaxItems <= 0 {
+		opts.BatchMaxItems = config.DefaultBatchMaxItems
+	}
+	return opts
+}
+
+// handle registers next for pattern with instrumentation and, when API keys
+// are enabled, authentication for scope.
+func handle(mux *http.ServeMux, opts Options, pattern, scope string, charge bool, next http.HandlerFunc) {
+	mux.HandleFunc(pattern, instrument(opts, pattern, requireScope(opts, scope, charge, next)))
+}
+
+func registerAPI(mux *http.ServeMux, opts Options) {
+	handle(mux, opts, "/query", auth.ScopeQuery, true, makeQueryHandler(opts))
+	handle(mux, opts, "/compare", auth.ScopeQuery, false, makeCompareHandler(opts))
+	handle(mux, opts, "/batch", auth.ScopeBatch, false, makeBatchHandler(opts))
+	if opts.DoHUpstream != "" {
+		handle(mux, opts, "/resolve", auth.ScopeQuery, true, makeResolveHandler(opts))
+		if opts.Exchanger != nil {
+			handle(mux, opts, "/dns-query", auth.ScopeQuery, true, makeDoHHandler(opts))
+		}
+	}
+	handle(mux, opts, "/upstreams", auth.ScopeQuery, true, makeUpstreamsHandler(opts))
+}
+
+// registerAdmin registers the admin endpoints. Without API keys anyone who
+// can reach the mux could trigger a reload, so /admin/reload is only
+// registered on a dedicated admin listener or when keys are configured.
+func registerAdmin(mux *http.ServeMux, opts Options, dedicated bool) {
+	handle(mux, opts, "/stats", auth.ScopeAdmin, true, makeStatsHandler(opts))
+	if opts.Reload != nil && (dedicated || opts.Keys != nil) {
+		handle(mux, opts, "/admin/reload", auth.ScopeAdmin, true, makeReloadHandler(opts))
+	}
+	if opts.Metrics != nil {
+		mux.HandleFunc("/metrics", requireScope(opts, auth.ScopeAdmin, true, opts.Metrics.Handler().ServeHTTP))
+	}
+}
+
+// registerHealth registers the healthcheck endpoints for readiness/liveness
+// probes.
+func registerHealth(mux *http.ServeMux, opts Options) {
+	mux.HandleFunc("/livez", makeLiveHandler(opts))
+	mux.HandleFunc("/readyz", makeReadyHandler(opts))
+	mux.HandleFunc("/healthz", makeHealthHandler(opts.Logger, opts.Draining))
+	mux.HandleFunc("/health", makeHealthHandler(opts.Logger, opts.Draining))
 }
 
 func emptyRequestPayload() api.RequestPayload {
 	return api.RequestPayload{
-		Nameserver: "",
-		Name:       "",
-		Type:       "A",
-		Transport:  "udp",
-		DNSSEC:     false,
-		Short:      false,
-		AsJSON:     false,
+		Nameserver:       "",
+		Name:             "",
+		Type:             "A",
+		Transport:        "udp",
+		DNSSEC:           false,
+		Short:            false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
 	}
 }
 
-func writeErrorResponse(writer http.ResponseWriter, status int, req api.RequestPayload, msg string) {
-	resp := api.ResponsePayload{
+func newErrorResponse(status int, req api.RequestPayload, msg string) api.ResponsePayload {
+	return api.ResponsePayload{
 		Status:    status,
 		Success:   false,
 		Timestamp: time.Now().Format(time.RFC3339),
@@ -53,30 +204,36 @@ func writeErrorResponse(writer http.ResponseWriter, status int, req api.RequestP
 		Command:   "",
 		Answer:    nil,
 		Error:     msg,
+		Note:      "",
+		Index:     nil,
+		DNSSEC:    nil,
+		Cached:    false,
+		TTL:       nil,
+		Shared:    false,
+		TraceID:   "",
 	}
-	writeJSON(writer, resp)
 }
 
-func handleRateLimit(
-	writer http.ResponseWriter,
-	req *http.Request,
-	limiter *ratelimit.Manager,
-	trusted []*net.IPNet,
-) bool {
-	if limiter == nil {
-		return true
-	}
-	clientIP := req.RemoteAddr
-	if len(trusted) > 0 {
-		clientIP = ClientIP(req, trusted)
-	} else {
-		// ensure we pass only host portion
-		host, _, err := net.SplitHostPort(req.RemoteAddr)
-		if err == nil {
-			clientIP = host
-		}
+func writeErrorResponse(writer http.ResponseWriter, status int, req api.RequestPayload, msg string) {
+	writeJSON(writer, newErrorResponse(status, req, msg))
+}
+
+// setRetryAfter advises overloaded clients to retry once a queued query
+// would have given up waiting, but at least after one second.
+func setRetryAfter(writer http.ResponseWriter, opts Options) {
+	seconds := 1
+	if opts.Pool != nil {
+		seconds = ceilSeconds(opts.Pool.QueueTimeout().Seconds())
 	}
-	if !limiter.Allow(clientIP) {
+	writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func handleRateLimit(writer http.ResponseWriter, req *http.Request, opts Options) bool {
+	ctx, span := telemetry.Tracer().Start(req.Context(), "handleRateLimit")
+	defer span.End()
+	allowed := allowClient(ctx, opts, rateLimitKey(req, opts))
+	span.SetAttributes(attribute.Bool("ratelimit.allowed", allowed))
+	if !allowed {
 		writer.Header().Set("Retry-After", "1")
 		writeErrorResponse(writer, http.StatusTooManyRequests, emptyRequestPayload(), "rate limit exceeded")
 		return false
@@ -84,7 +241,31 @@ func handleRateLimit(
 	return true
 }
 
+// allowClient charges one request against the client's rate-limit bucket.
+// Requests authenticated by API key are charged against the key instead.
+func allowClient(ctx context.Context, opts Options, limitKey string) bool {
+	if opts.Limiter == nil {
+		return true
+	}
+	if _, ok := auth.KeyFromContext(ctx); ok {
+		// already charged against the API key
+		return true
+	}
+	if !opts.Limiter.Allow(limitKey) {
+		opts.Metrics.ObserveRateLimited("client")
+		return false
+	}
+	return true
+}
+
+// rateLimitKey returns the client identity used for rate limiting.
+func rateLimitKey(req *http.Request, opts Options) string {
+	return clientIP(req, opts)
+}
+
 func decodeRequestPayload(writer http.ResponseWriter, req *http.Request) (api.RequestPayload, bool) {
+	_, span := telemetry.Tracer().Start(req.Context(), "decodeRequestPayload")
+	defer span.End()
 	var payload api.RequestPayload
 	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
 		writeErrorResponse(writer, http.StatusBadRequest, emptyRequestPayload(), err.Error())
@@ -101,14 +282,10 @@ func validatePayload(writer http.ResponseWriter, payload api.RequestPayload) boo
 	return true
 }
 
-func makeQueryHandler(
-	resolverRunner *resolver.Runner,
-	limiter *ratelimit.Manager,
-	trusted []*net.IPNet,
-	logger *slog.Logger,
-) http.HandlerFunc {
+func makeQueryHandler(opts Options) http.HandlerFunc {
+	logger := opts.Logger
 	return func(writer http.ResponseWriter, req *http.Request) {
-		if !handleRateLimit(writer, req, limiter, trusted) {
+		if !handleRateLimit(writer, req, opts) {
 			return
 		}
 
@@ -117,6 +294,7 @@ func makeQueryHandler(
 			"method", req.Method,
 			"remote", req.RemoteAddr,
 			"path", req.URL.Path,
+			"key", keyID(req.Context()),
 		)
 
 		if req.Method != http.MethodPost {
@@ -129,12 +307,17 @@ func makeQueryHandler(
 			return
 		}
 
+		var err error
+		if payload.Transport, err = upstreamTransport(opts, payload.Nameserver, payload.Transport); err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, payload, err.Error())
+			return
+		}
 		if !validatePayload(writer, payload) {
 			return
 		}
 
 		// log the query payload for every request
-		clientIP := ClientIP(req, trusted)
+		clientIP := clientIP(req, opts)
 		logger.InfoContext(req.Context(), "query payload",
 			"nameserver", payload.Nameserver,
 			"name", payload.Name,
@@ -143,69 +326,242 @@ func makeQueryHandler(
 			"dnssec", payload.DNSSEC,
 			"short", payload.Short,
 			"json", payload.AsJSON,
+			"structured", payload.Structured,
+			"validate", payload.ValidateDNSSEC,
+			"trace", payload.Trace,
 			"client", clientIP,
 		)
 
-		// Use request context and a safety timeout
-		ctx := req.Context()
-		ctx, cancel := context.WithTimeout(ctx, resolverRunner.Timeout+1*time.Second)
-		defer cancel()
-
-		out, cmdDesc, runErr := resolverRunner.Run(ctx, payload)
-
-		// log empty responses (no output) for visibility
-		if len(out) == 0 {
-			client := ClientIP(req, trusted)
-			logger.InfoContext(req.Context(), "empty resolver response",
-				"nameserver", payload.Nameserver,
-				"name", payload.Name,
-				"type", payload.Type,
-				"transport", payload.Transport,
-				"dnssec", payload.DNSSEC,
-				"client", client,
-				"error", runErr,
-			)
+		resp := executeQuery(req.Context(), opts, payload, clientIP)
+		if resp.Status == http.StatusServiceUnavailable {
+			setRetryAfter(writer, opts)
 		}
+		writeJSON(writer, resp)
+	}
+}
 
-		resp := api.ResponsePayload{
-			Status:    http.StatusOK,
-			Success:   runErr == nil,
-			Timestamp: time.Now().Format(time.RFC3339),
-			Request:   payload,
-			Command:   cmdDesc,
-			Answer:    nil,
-			Error:     "",
-		}
+// executeQuery runs a query via runQuery, counts its outcome and reports the
+// trace ID. It is shared by the /query and /batch handlers.
+func executeQuery(ctx context.Context, opts Options, payload api.RequestPayload, client string) api.ResponsePayload {
+	resp := runQuery(ctx, opts, payload, client)
+	resp.TraceID = telemetry.TraceID(ctx)
+	opts.Metrics.ObserveQuery(resp.Status, rrtype.Canonical(payload.Type), payload.Transport)
+	return resp
+}
 
-		if runErr != nil {
-			resp.Status = http.StatusInternalServerError
-			resp.Error = runErr.Error()
-		}
+// runQuery runs a validated payload through the resolver and builds the
+// response.
+func runQuery(ctx context.Context, opts Options, payload api.RequestPayload, client string) api.ResponsePayload {
+	resolverRunner, logger := opts.Resolver, opts.Logger
+	if payload.ValidateDNSSEC && opts.Validator == nil {
+		return newErrorResponse(http.StatusBadRequest, payload, "dnssec validation is not enabled")
+	}
+	ctx, err := checkNameserver(ctx, opts, payload.Nameserver, payload.Transport)
+	if err != nil {
+		return newErrorResponse(http.StatusForbidden, payload, err.Error())
+	}
+	if payload.Trace {
+		return executeTrace(ctx, opts, payload)
+	}
+
+	// Use request context and a safety timeout
+	runCtx, cancel := context.WithTimeout(ctx, resolverRunner.QueryTimeout()+1*time.Second)
+	defer cancel()
+	runCtx, meta := resolver.WithMeta(runCtx)
+
+	out, cmdDesc, runErr := resolverRunner.Run(runCtx, payload)
+
+	// log empty responses (no output) for visibility
+	if len(out) == 0 {
+		logger.InfoContext(ctx, "empty resolver response",
+			"nameserver", payload.Nameserver,
+			"name", payload.Name,
+			"type", payload.Type,
+			"transport", payload.Transport,
+			"dnssec", payload.DNSSEC,
+			"client", client,
+			"error", runErr,
+		)
+	}
 
-		if payload.AsJSON && len(out) > 0 {
-			var parsed interface{}
-			if unmarshalErr := json.Unmarshal(out, &parsed); unmarshalErr == nil {
-				resp.Answer = parsed
-			} else {
-				resp.Answer = string(out)
-			}
-		} else {
-			resp.Answer = string(out)
+	resp := api.ResponsePayload{
+		Status:    http.StatusOK,
+		Success:   runErr == nil,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Request:   payload,
+		Command:   cmdDesc,
+		Answer:    nil,
+		Error:     "",
+		Note:      "",
+		Index:     nil,
+		DNSSEC:    nil,
+		Cached:    false,
+		TTL:       nil,
+		Shared:    false,
+		TraceID:   "",
+	}
+	if rrType, known := rrtype.Lookup(payload.Type); known {
+		resp.Note = rrType.Note
+	}
+	if meta.Cached {
+		ttl := meta.TTL
+		resp.Cached = true
+		resp.TTL = &ttl
+	}
+	resp.Shared = meta.Shared
+
+	if runErr != nil {
+		resp.Status = http.StatusInternalServerError
+		if pool.Overloaded(runErr) {
+			resp.Status = http.StatusServiceUnavailable
 		}
+		resp.Error = runErr.Error()
+	}
 
-		writeJSON(writer, resp)
+	resp.Answer = formatAnswer(ctx, logger, payload, out)
+	if payload.ValidateDNSSEC && runErr == nil {
+		resp.DNSSEC = validateDNSSEC(ctx, opts, payload)
+	}
+	return resp
+}
+
+// upstreamTransport returns the transport to use for nameserver: the
+// profile's transport when nameserver names an upstream profile, otherwise
+// transport unchanged. A transport conflicting with the profile is an error.
+func upstreamTransport(opts Options, nameserver, transport string) (string, error) {
+	profile, ok := opts.Upstreams.Lookup(nameserver)
+	if !ok || transport == profile.Transport {
+		return transport, nil
+	}
+	if transport != "" {
+		return "", fmt.Errorf(`"transport" %q conflicts with upstream %q`, transport, nameserver)
+	}
+	return profile.Transport, nil
+}
+
+// checkNameserver applies the nameserver policy, logging refused targets. The
+// returned context pins nameserver to the address the policy checked, so
+// that the resolvers do not look the hostname up again. Upstream profiles are
+// configured by the operator and always allowed, and an empty nameserver (a
+// trace from the root servers) has nothing to check.
+func checkNameserver(ctx context.Context, opts Options, nameserver, transport string) (context.Context, error) {
+	if _, ok := opts.Upstreams.Lookup(nameserver); ok || opts.Policy == nil || nameserver == "" {
+		return ctx, nil
+	}
+	addr, err := opts.Policy.Resolve(ctx, nameserver, transport)
+	if err != nil {
+		opts.Logger.WarnContext(ctx, "nameserver refused",
+			"nameserver", nameserver,
+			"transport", transport,
+			"error", err,
+		)
+		return ctx, err //nolint:wrapcheck // the policy error is the user-facing message
 	}
+	if addr.IsValid() {
+		ctx = resolver.WithAddress(ctx, nameserver, addr)
+	}
+	return ctx, nil
+}
+
+// validateDNSSEC validates the queried RRset against the same nameserver and
+// transport, within its own timeout budget.
+func validateDNSSEC(ctx context.Context, opts Options, payload api.RequestPayload) *dnssec.Report {
+	rrType, _ := rrtype.Lookup(payload.Type)
+	valCtx, cancel := context.WithTimeout(ctx, opts.Resolver.QueryTimeout()+1*time.Second)
+	defer cancel()
+
+	report := opts.Validator.Validate(valCtx, payload.Name, rrType.Code, payload.Nameserver, payload.Transport)
+	opts.Logger.InfoContext(ctx, "dnssec validation",
+		"name", payload.Name,
+		"type", payload.Type,
+		"status", report.Status,
+		"reason", report.Reason,
+	)
+	return report
+}
+
+// executeTrace resolves the payload iteratively from the root servers. A trace
+// that stops early (e.g. at a broken delegation) is still a useful answer, so
+// it is reported with status 200 and the partial path.
+func executeTrace(ctx context.Context, opts Options, payload api.RequestPayload) api.ResponsePayload {
+	if opts.Tracer == nil {
+		return newErrorResponse(http.StatusBadRequest, payload, "trace is not enabled")
+	}
+	rrType, _ := rrtype.Lookup(payload.Type)
+	traceCtx, cancel := context.WithTimeout(ctx, opts.Resolver.QueryTimeout()+1*time.Second)
+	defer cancel()
+
+	result := opts.Tracer.Trace(traceCtx, payload.Name, rrType.Code, payload.Transport)
+	cmd := "kdig " + payload.Name + " " + rrType.Name + " +trace"
+	if payload.Transport == "tcp" {
+		cmd += " +tcp"
+	}
+	opts.Logger.InfoContext(ctx, "trace finished",
+		"name", payload.Name,
+		"type", payload.Type,
+		"hops", len(result.Hops),
+		"rcode", result.Rcode,
+		"error", result.Error,
+	)
+	return api.ResponsePayload{
+		Status:    http.StatusOK,
+		Success:   result.Error == "",
+		Timestamp: time.Now().Format(time.RFC3339),
+		Request:   payload,
+		Command:   cmd,
+		Answer:    result,
+		Error:     result.Error,
+		Note:      rrType.Note,
+		Index:     nil,
+		DNSSEC:    nil,
+		Cached:    false,
+		TTL:       nil,
+		Shared:    false,
+		TraceID:   "",
+	}
+}
+
+// formatAnswer converts resolver output into the answer representation
+// requested by the payload, falling back to the raw text when parsing fails.
+func formatAnswer(ctx context.Context, logger *slog.Logger, payload api.RequestPayload, out []byte) interface{} {
+	if len(out) == 0 {
+		return string(out)
+	}
+	switch {
+	case payload.Structured:
+		msg, err := resolver.ParseKdigOutput(out)
+		if err == nil {
+			return msg
+		}
+		logger.WarnContext(ctx, "structured parse failed", "error", err)
+	case payload.AsJSON:
+		var parsed interface{}
+		if err := json.Unmarshal(out, &parsed); err == nil {
+			return parsed
+		}
+	default:
+	}
+	return string(out)
 }
 
 func writeJSON(w http.ResponseWriter, resp api.ResponsePayload) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(resp.Status)
-	_ = json.NewEncoder(w).Encode(resp)
+	writeJSONBody(w, resp.Status, resp)
+}
+
+// writeJSONBody writes body as JSON with the given HTTP status. A
+// Content-Type already set by the caller (e.g. application/dns-json) is kept.
+func writeJSONBody(w http.ResponseWriter, status int, body interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
 }
 
 // makeHealthHandler returns a simple healthcheck handler that responds 200 OK
-// with a small JSON body. Useful for liveness/readiness probes.
-func makeHealthHandler(logger *slog.Logger) http.HandlerFunc {
+// with a small JSON body, or 503 once draining is set. Useful for
+// liveness/readiness probes.
+func makeHealthHandler(logger *slog.Logger, draining *atomic.Bool) http.HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
 		// Log the health check for visibility
 		if logger != nil {
@@ -215,8 +571,10 @@ func makeHealthHandler(logger *slog.Logger) http.HandlerFunc {
 				"path", req.URL.Path,
 			)
 		}
-		writer.Header().Set("Content-Type", "application/json")
-		writer.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(writer).Encode(map[string]string{"status": "ok"})
+		if draining != nil && draining.Load() {
+			writeJSONBody(writer, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+			return
+		}
+		writeJSONBody(writer, http.StatusOK, map[string]string{"status": "ok"})
 	}
 }
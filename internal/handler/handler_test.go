@@ -2,13 +2,28 @@ package handler_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/health"
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/pool"
+	"github.com/exiguus/wdns/internal/ratelimit"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/testutil"
 )
 
 func TestHandlerQuery(t *testing.T) {
@@ -26,6 +41,13 @@ func TestHandlerQuery(t *testing.T) {
 			Command:   "",
 			Answer:    "93.184.216.34",
 			Error:     "",
+			Note:      "",
+			Index:     nil,
+			DNSSEC:    nil,
+			Cached:    false,
+			TTL:       nil,
+			Shared:    false,
+			TraceID:   "",
 		}
 		writer.Header().Set("Content-Type", "application/json")
 		writer.WriteHeader(resp.Status)
@@ -36,13 +58,18 @@ func TestHandlerQuery(t *testing.T) {
 	defer srv.Close()
 
 	reqBody := api.RequestPayload{
-		Nameserver: "1.1.1.1",
-		Name:       "example.com",
-		Type:       "A",
-		Short:      false,
-		DNSSEC:     false,
-		Transport:  "",
-		AsJSON:     false,
+		Nameserver:       "1.1.1.1",
+		Name:             "example.com",
+		Type:             "A",
+		Short:            false,
+		DNSSEC:           false,
+		Transport:        "",
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
 	}
 	b, _ := json.Marshal(reqBody)
 
@@ -60,3 +87,381 @@ func TestHandlerQuery(t *testing.T) {
 		t.Fatalf("unexpected response: %+v", got)
 	}
 }
+
+func TestQueryValidateDisabled(t *testing.T) {
+	srv := newTestServer(t, nil)
+	body := `{"nameserver":"1.1.1.1","name":"example.com","type":"A","validate":true}`
+
+	res, err := http.Post(srv.URL+"/query", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", res.StatusCode)
+	}
+}
+
+// overloadedResolver refuses every query like a saturated pool.
+type overloadedResolver struct{}
+
+func (overloadedResolver) Run(_ context.Context, _ api.RequestPayload) ([]byte, string, error) {
+	return nil, "", pool.ErrQueueFull
+}
+
+func (overloadedResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func TestQueryOverloaded(t *testing.T) {
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         overloadedResolver{},
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 1,
+		BatchMaxItems:    10,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             pool.New(overloadedResolver{}, pool.Config{MaxRunning: 1, MaxQueued: 1, QueueTimeout: 2500 * time.Millisecond}),
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	query := `{"nameserver":"1.1.1.1","name":"example.com","type":"A"}`
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/query", query, http.StatusServiceUnavailable},
+		{"/compare", `{"servers":[{"nameserver":"1.1.1.1"},{"nameserver":"9.9.9.9"}],"name":"example.com","type":"A"}`, http.StatusServiceUnavailable},
+		// the stream itself succeeds; its items report 503
+		{"/batch", "[" + query + "," + query + "]", http.StatusOK},
+	} {
+		res, err := http.Post(srv.URL+tc.path, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("%s: post failed: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tc.status || res.Header.Get("Retry-After") != "3" {
+			t.Fatalf("%s: expected %d with Retry-After 3, got %d %q", tc.path, tc.status, res.StatusCode, res.Header.Get("Retry-After"))
+		}
+		if !strings.Contains(string(body), `"status":503`) {
+			t.Fatalf("%s: expected status 503 in body, got %s", tc.path, body)
+		}
+	}
+}
+
+func TestCompareChargesPerServer(t *testing.T) {
+	srv := newTestServer(t, ratelimit.NewManager(0.001, 3))
+	post := func(servers ...string) int {
+		t.Helper()
+		list := make([]string, len(servers))
+		for i, server := range servers {
+			list[i] = `{"nameserver":"` + server + `"}`
+		}
+		res, err := http.Post(srv.URL+"/compare", "application/json", strings.NewReader(
+			`{"servers":[`+strings.Join(list, ",")+`],"name":"example.com","type":"A"}`))
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := post("1.1.1.1", "8.8.8.8", "9.9.9.9", "1.0.0.1"); status != http.StatusTooManyRequests {
+		t.Fatalf("four servers with three tokens: got %d, want 429", status)
+	}
+	if status := post("1.1.1.1", "8.8.8.8"); status == http.StatusTooManyRequests {
+		t.Fatalf("a refused compare must not consume tokens: got %d", status)
+	}
+	if status := post("1.1.1.1", "8.8.8.8"); status != http.StatusTooManyRequests {
+		t.Fatalf("two servers with one token left: got %d, want 429", status)
+	}
+}
+
+func TestNameserverPolicyRefused(t *testing.T) {
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         stubResolver{},
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           policy.New(policy.Config{AllowCIDRs: nil, DenyCIDRs: nil, AllowHosts: nil, DenyHosts: nil, Ports: nil}),
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct{ path, body string }{
+		{"/query", `{"nameserver":"169.254.169.254","name":"example.com","type":"A"}`},
+		{"/query", `{"nameserver":"10.0.0.1","name":"example.com","type":"A","trace":true}`},
+		{"/compare", `{"servers":[{"nameserver":"1.1.1.1"},{"nameserver":"127.0.0.1#5353"}],"name":"example.com","type":"A"}`},
+	}
+	for _, tc := range cases {
+		path := tc.path
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		var got struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&got)
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden || !strings.Contains(got.Error, "nameserver refused") {
+			t.Fatalf("%s: expected 403 with policy error, got %d %q", path, res.StatusCode, got.Error)
+		}
+	}
+
+	res, err := http.Post(srv.URL+"/query", "application/json",
+		strings.NewReader(`{"nameserver":"1.1.1.1","name":"example.com","type":"A"}`))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected public nameserver to be allowed, got %d", res.StatusCode)
+	}
+}
+
+func TestNameserverPolicyPinsAddress(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+	_, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+
+	nameserverPolicy := policy.New(policy.Config{
+		AllowCIDRs: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		DenyCIDRs:  nil,
+		AllowHosts: nil,
+		DenyHosts:  nil,
+		Ports:      []uint16{uint16(port)},
+	})
+	// the hostname does not resolve again in the backend: only the address
+	// checked by the policy can answer
+	nameserverPolicy.Lookup = func(context.Context, string) ([]netip.Addr, error) {
+		return []netip.Addr{netip.MustParseAddr("127.0.0.1")}, nil
+	}
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         resolver.NewNativeClient(2*time.Second, 4096),
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nameserverPolicy,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/query", "application/json",
+		strings.NewReader(`{"nameserver":"ns.pinned.invalid#`+portStr+`","name":"example.com","type":"A","short":true}`))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	var got api.ResponsePayload
+	_ = json.NewDecoder(res.Body).Decode(&got)
+	if res.StatusCode != http.StatusOK || !got.Success || got.Request.Nameserver != "ns.pinned.invalid#"+portStr {
+		t.Fatalf("expected the pinned address to answer, got %d %+v", res.StatusCode, got)
+	}
+}
+
+func TestHealthDraining(t *testing.T) {
+	var draining atomic.Bool
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         nil,
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         &draining,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, want := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		res, err := http.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != want {
+			t.Fatalf("got %d, want %d", res.StatusCode, want)
+		}
+		draining.Store(true)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	var draining atomic.Bool
+	checker := health.NewChecker()
+	checker.Add("pool", func(context.Context) (string, string) { return health.StatusWarn, "16/16 running" })
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         nil,
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         &draining,
+		Health:           checker,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	get := func(path string) (int, health.Report) {
+		t.Helper()
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		defer res.Body.Close()
+		var report health.Report
+		_ = json.NewDecoder(res.Body).Decode(&report)
+		return res.StatusCode, report
+	}
+
+	if status, report := get("/readyz"); status != http.StatusOK || report.Status != health.StatusWarn ||
+		len(report.Checks) != 1 || report.Checks[0].Message != "16/16 running" {
+		t.Fatalf("ready: got %d %+v", status, report)
+	}
+	draining.Store(true)
+	if status, report := get("/readyz"); status != http.StatusServiceUnavailable || report.Status != health.StatusFail ||
+		len(report.Checks) != 2 || report.Checks[0].Name != "draining" {
+		t.Fatalf("draining: got %d %+v", status, report)
+	}
+	if status, report := get("/livez"); status != http.StatusOK || report.Status != health.StatusOK {
+		t.Fatalf("livez while draining: got %d %+v", status, report)
+	}
+}
+
+func TestRegisterAPIAndAdmin(t *testing.T) {
+	opts := handler.Options{
+		Resolver:         stubResolver{},
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	}
+	public, admin := http.NewServeMux(), http.NewServeMux()
+	handler.RegisterAPI(public, opts)
+	handler.RegisterAdmin(admin, opts)
+
+	cases := []struct {
+		mux  *http.ServeMux
+		path string
+		want int
+	}{
+		{public, "/upstreams", http.StatusOK},
+		{public, "/stats", http.StatusNotFound},
+		{public, "/readyz", http.StatusOK},
+		{admin, "/stats", http.StatusOK},
+		{admin, "/upstreams", http.StatusNotFound},
+		{admin, "/readyz", http.StatusOK},
+	}
+	for _, tc := range cases {
+		rec := httptest.NewRecorder()
+		tc.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.path, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/exiguus/wdns/internal/health"
+)
+
+// makeLiveHandler returns the `/livez` handler. It only reports that the
+// process serves HTTP, so that orchestrators restart it when it stops doing
+// so, and stays ok while draining.
+func makeLiveHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		opts.Logger.InfoContext(req.Context(), "http request",
+			"method", req.Method,
+			"remote", req.RemoteAddr,
+			"path", req.URL.Path,
+		)
+		writeJSONBody(writer, http.StatusOK, map[string]string{"status": health.StatusOK})
+	}
+}
+
+// makeReadyHandler returns the `/readyz` handler, which runs opts.Health and
+// answers 503 with the per-check report when a check fails or the server is
+// draining. Warnings, such as a busy execution pool, keep it ready.
+func makeReadyHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		opts.Logger.InfoContext(req.Context(), "http request",
+			"method", req.Method,
+			"remote", req.RemoteAddr,
+			"path", req.URL.Path,
+		)
+		report := opts.Health.Run(req.Context())
+		if opts.Draining != nil && opts.Draining.Load() {
+			draining := health.Result{Name: "draining", Status: health.StatusFail, Message: "shutting down", DurationMS: 0}
+			report.Checks = append([]health.Result{draining}, report.Checks...)
+			report.Status = health.StatusFail
+		}
+		status := http.StatusOK
+		if report.Status == health.StatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSONBody(writer, status, report)
+	}
+}
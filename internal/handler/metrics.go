@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// WriteHeader records status and forwards it.
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 and forwards b.
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b) //nolint:wrapcheck // transparent wrapper
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush /batch results.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument traces the requests served by next under name and counts them
+// when metrics are enabled.
+func instrument(opts Options, name string, next http.HandlerFunc) http.HandlerFunc {
+	next = traced(name, next)
+	if opts.Metrics == nil {
+		return next
+	}
+	return func(writer http.ResponseWriter, req *http.Request) {
+		rec := &statusRecorder{ResponseWriter: writer, status: 0}
+		next(rec, req)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		opts.Metrics.ObserveRequest(name, rec.status)
+	}
+}
@@ -0,0 +1,131 @@
+package handler_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/metrics"
+	"github.com/exiguus/wdns/internal/ratelimit"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	var last api.RequestPayload
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         recordingResolver{last: &last},
+		Limiter:          ratelimit.NewManager(0, 1),
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          metrics.New(),
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for range 2 {
+		res, err := http.Post(srv.URL+"/query", "application/json",
+			strings.NewReader(`{"nameserver":"9.9.9.9","name":"example.com","type":"mx","transport":"tcp"}`))
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		_ = res.Body.Close()
+	}
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	for _, want := range []string{
+		`wdns_http_requests_total{code="200",handler="/query"} 1`,
+		`wdns_http_requests_total{code="429",handler="/query"} 1`,
+		`wdns_queries_total{status="200",transport="tcp",type="MX"} 1`,
+		`wdns_rate_limited_total{limiter="client"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsCountCompareAndDoH(t *testing.T) {
+	var last api.RequestPayload
+	m := metrics.New()
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         recordingResolver{last: &last},
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "9.9.9.9",
+		DoHTransport:     "tls",
+		Exchanger:        stubExchanger{fail: false},
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          m,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL+"/compare", "application/json",
+		strings.NewReader(`{"servers":[{"nameserver":"1.1.1.1","transport":"tcp"},{"nameserver":"8.8.8.8","transport":"tcp"}],"name":"example.com","type":"A"}`))
+	if err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+	_ = res.Body.Close()
+	wire, _ := new(dns.Msg).SetQuestion("example.com.", dns.TypeAAAA).Pack()
+	res, err = http.Post(srv.URL+"/dns-query", "application/dns-message", bytes.NewReader(wire))
+	if err != nil {
+		t.Fatalf("dns-query failed: %v", err)
+	}
+	_ = res.Body.Close()
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`wdns_queries_total{status="500",transport="tcp",type="A"} 2`, // the stub output is not structured
+		`wdns_queries_total{status="200",transport="tls",type="AAAA"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+// ReloadFunc reloads the configuration and returns a description of every
+// setting that changed, or why the reload was rejected.
+type ReloadFunc func(ctx context.Context) ([]string, error)
+
+// reloadResponse is the body of `/admin/reload`.
+type reloadResponse struct {
+	Changes []string `json:"changes"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// makeReloadHandler returns the `/admin/reload` handler, which applies the
+// current configuration file and environment like SIGHUP does.
+func makeReloadHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONBody(writer, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		if !handleRateLimit(writer, req, opts) {
+			return
+		}
+		opts.Logger.InfoContext(req.Context(), "reload requested", "remote", req.RemoteAddr, "key", keyID(req.Context()))
+		changes, err := opts.Reload(req.Context())
+		if changes == nil {
+			changes = []string{}
+		}
+		if err != nil {
+			writeJSONBody(writer, http.StatusUnprocessableEntity, reloadResponse{Changes: changes, Error: err.Error()})
+			return
+		}
+		writeJSONBody(writer, http.StatusOK, reloadResponse{Changes: changes, Error: ""})
+	}
+}
@@ -0,0 +1,129 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/handler"
+)
+
+// newReloadServer serves the handlers registered by register, which is
+// handler.Register or handler.RegisterAdmin.
+func newReloadServer(
+	t *testing.T,
+	register func(*http.ServeMux, handler.Options),
+	keys *auth.Keyring,
+	reload handler.ReloadFunc,
+) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	register(mux, handler.Options{
+		Resolver:         nil,
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             keys,
+		Metrics:          nil,
+		Reload:           reload,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	calls := 0
+	srv := newReloadServer(t, handler.RegisterAdmin, nil, func(context.Context) ([]string, error) {
+		calls++
+		if calls > 1 {
+			return nil, errors.New("rate_limit.rps: must be positive")
+		}
+		return []string{`rate_limit.rps: "10" -> "5"`}, nil
+	})
+
+	res, err := http.Get(srv.URL + "/admin/reload")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || calls != 0 {
+		t.Fatalf("GET: got %d after %d reloads", res.StatusCode, calls)
+	}
+
+	for _, want := range []struct {
+		status  int
+		changes int
+		err     string
+	}{
+		{http.StatusOK, 1, ""},
+		{http.StatusUnprocessableEntity, 0, "rate_limit.rps: must be positive"},
+	} {
+		res, err := http.Post(srv.URL+"/admin/reload", "", nil)
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		var body struct {
+			Changes []string `json:"changes"`
+			Error   string   `json:"error"`
+		}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		_ = res.Body.Close()
+		if res.StatusCode != want.status || len(body.Changes) != want.changes || body.Error != want.err {
+			t.Errorf("got %d %+v, want %d with %d changes and error %q",
+				res.StatusCode, body, want.status, want.changes, want.err)
+		}
+	}
+}
+
+func TestReloadEndpointDisabled(t *testing.T) {
+	reload := func(context.Context) ([]string, error) { return nil, nil }
+	parsed, err := auth.ParseKeys(strings.NewReader(`[{"id": "ops", "hash": "` + auth.HashSecret("ops-secret") + `", "scopes": ["admin"]}]`))
+	if err != nil {
+		t.Fatalf("parse keys: %v", err)
+	}
+	keys := auth.NewKeyring(parsed, auth.Limits{RPS: 100, Burst: 100})
+	for _, tc := range []struct {
+		name     string
+		register func(*http.ServeMux, handler.Options)
+		keys     *auth.Keyring
+		reload   handler.ReloadFunc
+		want     int
+	}{
+		{"reloading disabled", handler.RegisterAdmin, nil, nil, http.StatusNotFound},
+		{"shared listener without keys", handler.Register, nil, reload, http.StatusNotFound},
+		{"shared listener with keys", handler.Register, keys, reload, http.StatusUnauthorized},
+	} {
+		srv := newReloadServer(t, tc.register, tc.keys, tc.reload)
+		res, err := http.Post(srv.URL+"/admin/reload", "", nil)
+		if err != nil {
+			t.Fatalf("post failed: %v", err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, res.StatusCode, tc.want)
+		}
+	}
+}
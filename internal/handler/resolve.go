@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/pool"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+const dnsJSONContentType = "application/dns-json"
+
+var errResolveName = errors.New(`"name" parameter is required`)
+
+// makeResolveHandler returns the `/resolve` handler serving the Google and
+// Cloudflare compatible `application/dns-json` API. Queries are executed by
+// the configured resolver against opts.DoHUpstream.
+func makeResolveHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if !handleRateLimit(writer, req, opts) {
+			return
+		}
+
+		opts.Logger.InfoContext(req.Context(), "http request",
+			"method", req.Method,
+			"remote", req.RemoteAddr,
+			"path", req.URL.Path,
+			"key", keyID(req.Context()),
+		)
+
+		if req.Method != http.MethodGet {
+			writeJSONBody(writer, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+
+		payload, err := resolvePayload(req.URL.Query(), opts)
+		if err != nil {
+			writeJSONBody(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if ok, status, msg := api.Validate(payload); !ok {
+			writeJSONBody(writer, status, map[string]string{"error": msg})
+			return
+		}
+
+		opts.Logger.InfoContext(req.Context(), "resolve payload",
+			"name", payload.Name,
+			"type", payload.Type,
+			"do", payload.DNSSEC,
+			"cd", payload.CheckingDisabled,
+			"client", clientIP(req, opts),
+		)
+
+		runCtx, cancel := context.WithTimeout(req.Context(), opts.Resolver.QueryTimeout()+1*time.Second)
+		defer cancel()
+		out, _, runErr := opts.Resolver.Run(runCtx, payload)
+		if pool.Overloaded(runErr) {
+			opts.Metrics.ObserveQuery(http.StatusServiceUnavailable, rrtype.Canonical(payload.Type), payload.Transport)
+			setRetryAfter(writer, opts)
+			writeJSONBody(writer, http.StatusServiceUnavailable, map[string]string{"error": runErr.Error()})
+			return
+		}
+
+		status := http.StatusOK
+		if runErr != nil {
+			status = http.StatusInternalServerError
+		}
+		opts.Metrics.ObserveQuery(status, rrtype.Canonical(payload.Type), payload.Transport)
+		writer.Header().Set("Content-Type", resolveContentType(req))
+		writeJSONBody(writer, http.StatusOK, dnsJSONFromOutput(payload, out, runErr))
+	}
+}
+
+// resolvePayload maps the `name`, `type`, `do` and `cd` query parameters to a
+// structured query against the default upstream. `type` accepts mnemonics and
+// numeric codes.
+func resolvePayload(query url.Values, opts Options) (api.RequestPayload, error) {
+	payload := emptyRequestPayload()
+	payload.Nameserver = opts.DoHUpstream
+	transport, err := upstreamTransport(opts, opts.DoHUpstream, opts.DoHTransport)
+	if err != nil {
+		return payload, err
+	}
+	payload.Transport = transport
+	payload.Structured = true
+	payload.Name = query.Get("name")
+	if payload.Name == "" {
+		return payload, errResolveName
+	}
+	if t := query.Get("type"); t != "" {
+		payload.Type = t
+		if code, err := strconv.ParseUint(t, 10, 16); err == nil {
+			payload.Type = rrtype.ByCode(uint16(code)).Name
+		}
+	}
+	if payload.DNSSEC, err = queryBool(query, "do"); err != nil {
+		return payload, err
+	}
+	if payload.CheckingDisabled, err = queryBool(query, "cd"); err != nil {
+		return payload, err
+	}
+	return payload, nil
+}
+
+// queryBool parses an optional boolean parameter ("1", "true", "0", ...).
+func queryBool(query url.Values, name string) (bool, error) {
+	v := query.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%q parameter must be a boolean", name)
+	}
+	return b, nil
+}
+
+// resolveContentType honours `ct=application/dns-json` and an Accept header
+// asking for it, and defaults to application/json.
+func resolveContentType(req *http.Request) string {
+	if req.URL.Query().Get("ct") == dnsJSONContentType || strings.Contains(req.Header.Get("Accept"), dnsJSONContentType) {
+		return dnsJSONContentType
+	}
+	return "application/json"
+}
+
+// dnsJSONFromOutput converts resolver output into the dns-json format.
+// Failures are reported as SERVFAIL with the error in Comment.
+func dnsJSONFromOutput(payload api.RequestPayload, out []byte, runErr error) api.DNSJSONResponse {
+	qtype, _ := rrtype.Lookup(payload.Type)
+	resp := api.DNSJSONResponse{
+		Status:     dns.RcodeServerFailure,
+		TC:         false,
+		RD:         true,
+		RA:         false,
+		AD:         false,
+		CD:         payload.CheckingDisabled,
+		Question:   []api.DNSJSONQuestion{{Name: dns.Fqdn(payload.Name), Type: qtype.Code}},
+		Answer:     nil,
+		Authority:  nil,
+		Additional: nil,
+		Comment:    "",
+	}
+	if runErr != nil {
+		resp.Comment = runErr.Error()
+		return resp
+	}
+	msg, err := resolver.ParseKdigOutput(out)
+	if err != nil {
+		resp.Comment = "failed to parse upstream response: " + err.Error()
+		return resp
+	}
+
+	if rcode, ok := dns.StringToRcode[msg.Header.Rcode]; ok {
+		resp.Status = rcode
+	}
+	setDNSJSONFlags(&resp, msg.Header.Flags)
+	if len(msg.Question) > 0 {
+		resp.Question = resp.Question[:0]
+		for _, q := range msg.Question {
+			t, _ := rrtype.Lookup(q.Type)
+			resp.Question = append(resp.Question, api.DNSJSONQuestion{Name: q.Name, Type: t.Code})
+		}
+	}
+	resp.Answer = dnsJSONRecords(msg.Answer)
+	resp.Authority = dnsJSONRecords(msg.Authority)
+	resp.Additional = dnsJSONRecords(msg.Additional)
+	return resp
+}
+
+// setDNSJSONFlags copies the header flags reported by the resolver.
+func setDNSJSONFlags(resp *api.DNSJSONResponse, flags []string) {
+	resp.RD, resp.CD = false, false
+	for _, flag := range flags {
+		switch strings.ToLower(flag) {
+		case "tc":
+			resp.TC = true
+		case "rd":
+			resp.RD = true
+		case "ra":
+			resp.RA = true
+		case "ad":
+			resp.AD = true
+		case "cd":
+			resp.CD = true
+		default:
+		}
+	}
+}
+
+func dnsJSONRecords(records []resolver.Record) []api.DNSJSONRecord {
+	var out []api.DNSJSONRecord
+	for _, rec := range records {
+		t, _ := rrtype.Lookup(rec.Type)
+		out = append(out, api.DNSJSONRecord{Name: rec.Name, Type: t.Code, TTL: rec.TTL, Data: rec.RData})
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/handler"
+)
+
+const kdigAnswerOutput = `;; ->>HEADER<<- opcode: QUERY; status: NOERROR; id: 1
+;; Flags: qr rd ra ad; QUERY: 1; ANSWER: 1; AUTHORITY: 0; ADDITIONAL: 0
+
+;; QUESTION SECTION:
+;; example.com.        		IN	A
+
+;; ANSWER SECTION:
+example.com.        	300	IN	A	192.0.2.1
+
+;; Received 56 B
+`
+
+// textResolver answers with kdig text output and records the last request.
+type textResolver struct {
+	fail bool
+	last *api.RequestPayload
+}
+
+func (r textResolver) Run(_ context.Context, req api.RequestPayload) ([]byte, string, error) {
+	*r.last = req
+	if r.fail {
+		return nil, "", errors.New("upstream timed out")
+	}
+	return []byte(kdigAnswerOutput), "", nil
+}
+
+func (textResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func newResolveServer(t *testing.T, res textResolver) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         res,
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "192.0.2.53",
+		DoHTransport:     "tcp",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           nil,
+		Upstreams:        nil,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getDNSJSON(t *testing.T, url string) (*http.Response, api.DNSJSONResponse) {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	defer res.Body.Close()
+	var body api.DNSJSONResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return res, body
+}
+
+func TestResolveAnswer(t *testing.T) {
+	var last api.RequestPayload
+	srv := newResolveServer(t, textResolver{fail: false, last: &last})
+
+	res, body := getDNSJSON(t, srv.URL+"/resolve?name=example.com&type=1&do=1&cd=true&ct=application/dns-json")
+	if ct := res.Header.Get("Content-Type"); ct != "application/dns-json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if body.Status != 0 || !body.RD || !body.RA || !body.AD || body.TC {
+		t.Fatalf("unexpected header: %+v", body)
+	}
+	if len(body.Answer) != 1 || body.Answer[0].Type != 1 || body.Answer[0].TTL != 300 || body.Answer[0].Data != "192.0.2.1" {
+		t.Fatalf("unexpected answer: %+v", body.Answer)
+	}
+	if last.Nameserver != "192.0.2.53" || last.Transport != "tcp" || last.Type != "A" || !last.DNSSEC || !last.CheckingDisabled {
+		t.Fatalf("unexpected resolver request: %+v", last)
+	}
+}
+
+func TestResolveUpstreamFailure(t *testing.T) {
+	var last api.RequestPayload
+	srv := newResolveServer(t, textResolver{fail: true, last: &last})
+
+	res, body := getDNSJSON(t, srv.URL+"/resolve?name=example.com")
+	if res.StatusCode != http.StatusOK || body.Status != 2 || body.Comment == "" {
+		t.Fatalf("expected SERVFAIL with comment, got %d %+v", res.StatusCode, body)
+	}
+}
+
+func TestResolveBadParams(t *testing.T) {
+	var last api.RequestPayload
+	srv := newResolveServer(t, textResolver{fail: false, last: &last})
+
+	for _, query := range []string{"", "?name=example.com&type=BOGUS", "?name=example.com&do=maybe"} {
+		res, err := http.Get(srv.URL + "/resolve" + query)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", query, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/exiguus/wdns/internal/cache"
+	"github.com/exiguus/wdns/internal/coalesce"
+	"github.com/exiguus/wdns/internal/pool"
+)
+
+// statsResponse is the body of `/stats`. Sections are omitted when the
+// corresponding component is disabled.
+type statsResponse struct {
+	Cache    *cache.Stats    `json:"cache,omitempty"`
+	Coalesce *coalesce.Stats `json:"coalesce,omitempty"`
+	Pool     *pool.Stats     `json:"pool,omitempty"`
+}
+
+// makeStatsHandler returns the `/stats` handler reporting runtime counters of
+// the optional resolver layers.
+func makeStatsHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONBody(writer, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		body := statsResponse{Cache: nil, Coalesce: nil, Pool: nil}
+		if opts.Cache != nil {
+			stats := opts.Cache.Stats()
+			body.Cache = &stats
+		}
+		if opts.Coalescer != nil {
+			stats := opts.Coalescer.Stats()
+			body.Coalesce = &stats
+		}
+		if opts.Pool != nil {
+			stats := opts.Pool.Stats()
+			body.Pool = &stats
+		}
+		writeJSONBody(writer, http.StatusOK, body)
+	}
+}
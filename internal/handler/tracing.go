@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/exiguus/wdns/internal/telemetry"
+)
+
+// traced serves next under route inside a server span continuing the
+// caller's W3C trace context, and records the response status on the span.
+func traced(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		ctx := telemetry.Extract(req.Context(), req.Header)
+		ctx, span := telemetry.Tracer().Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: writer, status: 0}
+		next(rec, req.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
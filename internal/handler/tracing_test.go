@@ -0,0 +1,44 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/telemetry"
+)
+
+func TestQueryEchoesTraceID(t *testing.T) {
+	if _, err := telemetry.Setup(context.Background(), telemetry.Config{
+		Exporter:    telemetry.ExporterNone,
+		Endpoint:    "",
+		File:        "",
+		SampleRatio: 1,
+	}); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	var last api.RequestPayload
+	srv := newUpstreamsServer(t, recordingResolver{last: &last})
+
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, srv.URL+"/query",
+		strings.NewReader(`{"nameserver":"9.9.9.9","name":"example.com","type":"A"}`))
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	defer res.Body.Close()
+	var resp api.ResponsePayload
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace_id = %q", resp.TraceID)
+	}
+}
@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+// upstreamsResponse is the body of `/upstreams`.
+type upstreamsResponse struct {
+	Upstreams []upstream.Profile `json:"upstreams"`
+}
+
+// makeUpstreamsHandler returns the `/upstreams` handler listing the upstream
+// profiles requests may reference as "nameserver".
+func makeUpstreamsHandler(opts Options) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONBody(writer, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+			return
+		}
+		profiles := opts.Upstreams.Profiles()
+		if profiles == nil {
+			profiles = []upstream.Profile{}
+		}
+		writeJSONBody(writer, http.StatusOK, upstreamsResponse{Upstreams: profiles})
+	}
+}
@@ -0,0 +1,123 @@
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+// recordingResolver answers every query and records the last request.
+type recordingResolver struct {
+	last *api.RequestPayload
+}
+
+func (r recordingResolver) Run(_ context.Context, req api.RequestPayload) ([]byte, string, error) {
+	*r.last = req
+	return []byte("192.0.2.1\n"), "kdig", nil
+}
+
+func (recordingResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func newUpstreamsServer(t *testing.T, res recordingResolver) *httptest.Server {
+	t.Helper()
+	catalog, err := upstream.NewCatalog([]upstream.Profile{{
+		Name:        "corp-dot",
+		Description: "internal resolver",
+		Addresses:   []string{"10.0.0.53"},
+		Port:        0,
+		Transport:   "tls",
+		TLSHostname: "dns.corp.example",
+		DoHURL:      "",
+		DNSSEC:      false,
+	}})
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	mux := http.NewServeMux()
+	handler.Register(mux, handler.Options{
+		Resolver:         res,
+		Limiter:          nil,
+		TrustedProxies:   nil,
+		ClientIPHeaders:  nil,
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		BatchConcurrency: 0,
+		BatchMaxItems:    0,
+		Validator:        nil,
+		Tracer:           nil,
+		DoHUpstream:      "",
+		DoHTransport:     "",
+		Exchanger:        nil,
+		Cache:            nil,
+		Coalescer:        nil,
+		Pool:             nil,
+		Policy:           policy.New(policy.Config{AllowCIDRs: nil, DenyCIDRs: nil, AllowHosts: nil, DenyHosts: nil, Ports: nil}),
+		Upstreams:        catalog,
+		Keys:             nil,
+		Metrics:          nil,
+		Reload:           nil,
+		Draining:         nil,
+		Health:           nil,
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpstreamsList(t *testing.T) {
+	var last api.RequestPayload
+	srv := newUpstreamsServer(t, recordingResolver{last: &last})
+
+	res, err := http.Get(srv.URL + "/upstreams")
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	defer res.Body.Close()
+	var body struct {
+		Upstreams []upstream.Profile `json:"upstreams"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Upstreams) != 1 || body.Upstreams[0].Name != "corp-dot" || body.Upstreams[0].Port != 853 {
+		t.Fatalf("unexpected upstreams: %+v", body.Upstreams)
+	}
+}
+
+func TestQueryUpstreamProfile(t *testing.T) {
+	var last api.RequestPayload
+	srv := newUpstreamsServer(t, recordingResolver{last: &last})
+
+	// the profile points at a private address but is operator-configured
+	res, err := http.Post(srv.URL+"/query", "application/json",
+		strings.NewReader(`{"nameserver":"corp-dot","name":"example.com","type":"A"}`))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || last.Nameserver != "corp-dot" || last.Transport != "tls" {
+		t.Fatalf("expected profile query over tls, got %d %+v", res.StatusCode, last)
+	}
+
+	res, err = http.Post(srv.URL+"/query", "application/json",
+		strings.NewReader(`{"nameserver":"corp-dot","name":"example.com","type":"A","transport":"tcp"}`))
+	if err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected conflicting transport to be rejected, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,199 @@
+// Package health runs the readiness checks behind /readyz and reports their
+// results.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/pool"
+	"github.com/exiguus/wdns/internal/resolver"
+)
+
+// Check statuses. Only StatusFail makes the service unready.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+const defaultCheckTimeout = 5 * time.Second
+
+// Result is the outcome of one check.
+type Result struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// DurationMS is how long the check took in milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// Report is the outcome of every check. Status is StatusFail if any check
+// failed, StatusWarn if any warned, and StatusOK otherwise.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Check inspects one dependency and returns its status and a message.
+type Check func(ctx context.Context) (status, message string)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs a fixed set of checks.
+type Checker struct {
+	checks []namedCheck
+	// Timeout bounds each check (default 5s).
+	Timeout time.Duration
+}
+
+// NewChecker returns a Checker without checks.
+func NewChecker() *Checker {
+	return &Checker{checks: nil, Timeout: defaultCheckTimeout}
+}
+
+// Add registers check under name. Checks are reported in the order they
+// were added.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Run executes every check concurrently and returns the report. It is safe
+// to call on a nil Checker, which reports StatusOK without checks.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: []Result{}}
+	if c == nil {
+		return report
+	}
+	report.Checks = make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, nc := range c.checks {
+		wg.Go(func() {
+			checkCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			start := time.Now()
+			status, message := nc.check(checkCtx)
+			report.Checks[i] = Result{
+				Name:       nc.name,
+				Status:     status,
+				Message:    message,
+				DurationMS: time.Since(start).Milliseconds(),
+			}
+		})
+	}
+	wg.Wait()
+	for _, r := range report.Checks {
+		report.Status = Worst(report.Status, r.Status)
+	}
+	return report
+}
+
+// Worst returns the more severe of two statuses.
+func Worst(a, b string) string {
+	switch {
+	case a == StatusFail || b == StatusFail:
+		return StatusFail
+	case a == StatusWarn || b == StatusWarn:
+		return StatusWarn
+	default:
+		return StatusOK
+	}
+}
+
+// Backend is implemented by resolver backends that can report whether they
+// are able to execute queries, such as *resolver.Runner (kdig present and
+// supported) and *resolver.NativeClient.
+type Backend interface {
+	Ready(ctx context.Context) (string, error)
+}
+
+// BackendCheck fails when backend is not ready. A result is reused for
+// interval, since checking may be expensive (the kdig runner starts a
+// process) and /readyz is unauthenticated.
+func BackendCheck(backend Backend, interval time.Duration) Check {
+	return Cached(func(ctx context.Context) (string, string) {
+		description, err := backend.Ready(ctx)
+		if err != nil {
+			return StatusFail, err.Error()
+		}
+		return StatusOK, description
+	}, interval)
+}
+
+// PoolCheck warns when every execution slot of p is busy or its wait queue
+// is full. Saturation never fails readiness: the pool already refuses the
+// excess queries, and taking every busy instance out of rotation at once
+// would only push their load onto the rest.
+func PoolCheck(p *pool.Pool) Check {
+	return func(context.Context) (string, string) {
+		s := p.Stats()
+		message := fmt.Sprintf("%d/%d running, %d/%d queued", s.Running, s.MaxRunning, s.Queued, s.MaxQueued)
+		switch {
+		case s.Queued >= int64(s.MaxQueued):
+			return StatusWarn, message + ", queue full"
+		case s.Running >= int64(s.MaxRunning):
+			return StatusWarn, message
+		default:
+			return StatusOK, message
+		}
+	}
+}
+
+// CanaryCheck resolves req through res and fails when the query fails or
+// the answer is not NOERROR. A result is reused for interval so that
+// frequent probes do not load the upstream.
+func CanaryCheck(res resolver.Resolver, req api.RequestPayload, interval time.Duration) Check {
+	return Cached(func(ctx context.Context) (string, string) {
+		return runCanary(ctx, res, req)
+	}, interval)
+}
+
+// Cached returns a Check that runs check at most once per interval and
+// reports the previous result in between. A result obtained after the
+// probe's context was canceled, e.g. because the client went away, says
+// nothing about the dependency and is not kept.
+func Cached(check Check, interval time.Duration) Check {
+	var (
+		mu      sync.Mutex
+		checked time.Time
+		status  string
+		message string
+	)
+	return func(ctx context.Context) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !checked.IsZero() && time.Since(checked) < interval {
+			return status, message
+		}
+		s, m := check(ctx)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return s, m
+		}
+		status, message, checked = s, m, time.Now()
+		return status, message
+	}
+}
+
+// runCanary executes one canary query.
+func runCanary(ctx context.Context, res resolver.Resolver, req api.RequestPayload) (string, string) {
+	target := req.Name + " " + req.Type + " @" + req.Nameserver
+	out, _, err := res.Run(ctx, req)
+	if err != nil {
+		return StatusFail, target + ": " + err.Error()
+	}
+	msg, err := resolver.ParseKdigOutput(out)
+	if err != nil {
+		return StatusFail, target + ": " + err.Error()
+	}
+	if rcode := msg.Header.Rcode; rcode != "NOERROR" {
+		return StatusFail, target + ": " + rcode
+	}
+	return StatusOK, target + ": NOERROR"
+}
@@ -0,0 +1,182 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/health"
+	"github.com/exiguus/wdns/internal/pool"
+)
+
+type backend struct {
+	err error
+}
+
+func (b backend) Ready(context.Context) (string, error) {
+	return "kdig 3.4.4", b.err
+}
+
+// countingResolver answers every query with output and counts the calls.
+type countingResolver struct {
+	output string
+	err    error
+	calls  *int
+}
+
+func (r countingResolver) Run(ctx context.Context, _ api.RequestPayload) ([]byte, string, error) {
+	*r.calls++
+	if r.err != nil {
+		return nil, "", r.err
+	}
+	select {
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	default:
+	}
+	return []byte(r.output), "kdig", nil
+}
+
+func (countingResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func canaryRequest() api.RequestPayload {
+	return api.RequestPayload{
+		Nameserver:       "192.0.2.53",
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "",
+		DNSSEC:           false,
+		Short:            false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          true,
+	}
+}
+
+func TestCheckerReport(t *testing.T) {
+	checker := health.NewChecker()
+	checker.Add("backend", health.BackendCheck(backend{err: nil}, 0))
+	if report := checker.Run(context.Background()); report.Status != health.StatusOK ||
+		len(report.Checks) != 1 || report.Checks[0].Message != "kdig 3.4.4" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	checker.Add("warning", func(context.Context) (string, string) { return health.StatusWarn, "busy" })
+	if report := checker.Run(context.Background()); report.Status != health.StatusWarn {
+		t.Fatalf("expected warn, got %+v", report)
+	}
+
+	checker.Add("kdig", health.BackendCheck(backend{err: errors.New("kdig not available")}, 0))
+	report := checker.Run(context.Background())
+	if report.Status != health.StatusFail || report.Checks[2].Name != "kdig" ||
+		report.Checks[2].Status != health.StatusFail || report.Checks[2].Message != "kdig not available" {
+		t.Fatalf("expected the kdig check to fail, got %+v", report)
+	}
+
+	var nilChecker *health.Checker
+	if report := nilChecker.Run(context.Background()); report.Status != health.StatusOK || report.Checks == nil {
+		t.Fatalf("nil checker: %+v", report)
+	}
+}
+
+func TestPoolCheck(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p := pool.New(blockingResolver{release: release}, pool.Config{MaxRunning: 1, MaxQueued: 1, QueueTimeout: time.Minute})
+	check := health.PoolCheck(p)
+	if status, msg := check(context.Background()); status != health.StatusOK || msg != "0/1 running, 0/1 queued" {
+		t.Fatalf("idle pool: %s %s", status, msg)
+	}
+	for range 2 {
+		go func() { _, _, _ = p.Run(context.Background(), canaryRequest()) }()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for p.Stats().Queued < 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if status, msg := check(context.Background()); status != health.StatusWarn || msg != "1/1 running, 1/1 queued, queue full" {
+		t.Fatalf("saturated pool must be degraded, not failed: %s %s", status, msg)
+	}
+}
+
+type blockingResolver struct {
+	release chan struct{}
+}
+
+func (r blockingResolver) Run(_ context.Context, _ api.RequestPayload) ([]byte, string, error) {
+	<-r.release
+	return nil, "", nil
+}
+
+func (blockingResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func TestCanaryCheck(t *testing.T) {
+	calls := 0
+	ok := countingResolver{
+		output: ";; ->>HEADER<<- opcode: QUERY; status: NOERROR; id: 1\n",
+		err:    nil,
+		calls:  &calls,
+	}
+	check := health.CanaryCheck(ok, canaryRequest(), time.Hour)
+	for range 2 {
+		if status, msg := check(context.Background()); status != health.StatusOK ||
+			msg != "example.com A @192.0.2.53: NOERROR" {
+			t.Fatalf("got %s %q", status, msg)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the result to be reused, got %d queries", calls)
+	}
+
+	servfail := countingResolver{
+		output: ";; ->>HEADER<<- opcode: QUERY; status: SERVFAIL; id: 1\n",
+		err:    nil,
+		calls:  &calls,
+	}
+	if status, msg := health.CanaryCheck(servfail, canaryRequest(), 0)(context.Background()); status != health.StatusFail ||
+		!strings.HasSuffix(msg, "SERVFAIL") {
+		t.Fatalf("SERVFAIL: got %s %q", status, msg)
+	}
+
+	failing := countingResolver{output: "", err: errors.New("connection refused"), calls: &calls}
+	if status, msg := health.CanaryCheck(failing, canaryRequest(), 0)(context.Background()); status != health.StatusFail ||
+		!strings.Contains(msg, "connection refused") {
+		t.Fatalf("error: got %s %q", status, msg)
+	}
+}
+
+func TestCachedCheck(t *testing.T) {
+	calls := 0
+	check := health.Cached(func(ctx context.Context) (string, string) {
+		calls++
+		if ctx.Err() != nil {
+			return health.StatusFail, ctx.Err().Error()
+		}
+		return health.StatusOK, "ready"
+	}, time.Hour)
+
+	// a probe abandoned by its client does not poison the cache
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if status, _ := check(canceled); status != health.StatusFail {
+		t.Fatalf("canceled probe: got %s", status)
+	}
+	for range 2 {
+		if status, msg := check(context.Background()); status != health.StatusOK || msg != "ready" {
+			t.Fatalf("got %s %q", status, msg)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected one canceled and one cached run, got %d runs", calls)
+	}
+}
@@ -0,0 +1,143 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// UnixPrefix marks a listen address as a Unix domain socket path.
+const UnixPrefix = "unix:"
+
+// ErrNotSocket is returned by Listen when the socket path exists and is not a
+// socket, so that a typo cannot delete an unrelated file.
+var ErrNotSocket = errors.New("path exists and is not a socket")
+
+// SocketOptions sets the permissions of Unix domain sockets created by
+// Listen. Zero values keep the defaults of the process.
+type SocketOptions struct {
+	// Mode is the permission bits of the socket file, e.g. 0o660.
+	Mode fs.FileMode
+	// User and Group own the socket file, by name or numeric ID.
+	User  string
+	Group string
+}
+
+// SocketPath returns the path of a "unix:/path.sock" listen address.
+func SocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, UnixPrefix)
+	return path, ok
+}
+
+// ParseSocketMode parses octal permission bits such as "0660". An empty
+// value is 0, which keeps the mode the process umask gives.
+func ParseSocketMode(s string) (fs.FileMode, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || mode > uint64(fs.ModePerm) {
+		return 0, fmt.Errorf("%q is not an octal permission mode such as 0660", s)
+	}
+	return fs.FileMode(mode), nil
+}
+
+// Listen listens on addr, either a TCP host:port or a Unix domain socket
+// given as "unix:/path.sock". A stale socket left by a previous run is
+// removed first, and the socket is removed again when the listener closes.
+// A socket with a configured mode is only accessible to its owner until the
+// mode is applied.
+//
+//nolint:ireturn // TCP or Unix listener depending on addr
+func Listen(addr string, opts SocketOptions) (net.Listener, error) {
+	path, ok := SocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr) //nolint:wrapcheck // net errors name the address
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("listen on %s: %w", path, ErrNotSocket)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	// with a mode to apply, nobody else may connect in the meantime
+	ln, err := listenUnix(path, opts.Mode != 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySocketOptions(path, opts); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// applySocketOptions sets the mode and owner of the socket file at path.
+func applySocketOptions(path string, opts SocketOptions) error {
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			return fmt.Errorf("socket mode: %w", err)
+		}
+	}
+	if opts.User == "" && opts.Group == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(opts.User, opts.Group)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("socket owner: %w", err)
+	}
+	return nil
+}
+
+// lookupOwner resolves a user and group, by name or numeric ID, to the IDs
+// os.Chown takes. An empty name yields -1, which leaves that ID unchanged.
+func lookupOwner(userName, groupName string) (int, int, error) {
+	uid, err := lookupID(userName, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err //nolint:wrapcheck // wrapped by the caller
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return -1, -1, fmt.Errorf("socket user: %w", err)
+	}
+	gid, err := lookupID(groupName, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err //nolint:wrapcheck // wrapped by the caller
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return -1, -1, fmt.Errorf("socket group: %w", err)
+	}
+	return uid, gid, nil
+}
+
+// lookupID returns the numeric ID name stands for, resolving names with
+// lookup, or -1 for an empty name.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id) //nolint:wrapcheck // IDs from the user database are numeric
+}
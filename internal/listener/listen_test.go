@@ -0,0 +1,70 @@
+package listener_test
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/listener"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wdns.sock")
+	// a socket left behind by a crashed process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if unix, ok := stale.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	_ = stale.Close()
+
+	ln, err := listener.Listen("unix:"+path, listener.SocketOptions{Mode: 0o660, User: "", Group: ""})
+	if err != nil {
+		t.Fatalf("listen over a stale socket: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o660 {
+		t.Fatalf("socket mode: %v %v", info, err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.Close()
+	_ = ln.Close()
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("socket must be removed on close, got %v", err)
+	}
+}
+
+func TestListenUnixRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("keep me"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := listener.Listen("unix:"+path, listener.SocketOptions{Mode: 0, User: "", Group: ""}); !errors.Is(err, listener.ErrNotSocket) {
+		t.Fatalf("got %v, want ErrNotSocket", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep me" {
+		t.Fatal("regular file was modified")
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	cases := map[string]fs.FileMode{"": 0, "0660": 0o660, "660": 0o660, "0o600": 0o600, "0777": 0o777}
+	for in, want := range cases {
+		if got, err := listener.ParseSocketMode(in); err != nil || got != want {
+			t.Errorf("ParseSocketMode(%q) = %o, %v; want %o", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"rw", "0888", "01777"} {
+		if _, err := listener.ParseSocketMode(in); err == nil {
+			t.Errorf("ParseSocketMode(%q): expected an error", in)
+		}
+	}
+}
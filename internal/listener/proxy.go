@@ -0,0 +1,90 @@
+// Package listener builds the network listeners the HTTP server accepts
+// connections on.
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// PROXY protocol modes accepted by ParseProxyMode.
+const (
+	// ProxyOff ignores PROXY protocol headers; connections carrying one fail
+	// to parse as HTTP.
+	ProxyOff = "off"
+	// ProxyOptional uses the header of trusted peers when present.
+	ProxyOptional = "optional"
+	// ProxyRequired rejects connections from trusted peers without a header.
+	ProxyRequired = "required"
+)
+
+// ErrNoTrustedProxies is returned by WithProxyProtocol when no proxy is
+// trusted to send PROXY headers.
+var ErrNoTrustedProxies = errors.New("PROXY protocol requires trusted proxies")
+
+// ParseProxyMode validates a PROXY protocol mode. An empty value is ProxyOff
+// and "on" is an alias of ProxyOptional.
+func ParseProxyMode(s string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(s)); mode {
+	case "", ProxyOff:
+		return ProxyOff, nil
+	case "on", ProxyOptional:
+		return ProxyOptional, nil
+	case ProxyRequired:
+		return ProxyRequired, nil
+	default:
+		return "", fmt.Errorf("unknown PROXY protocol mode %q", s)
+	}
+}
+
+// WithProxyProtocol wraps l so that connections from trusted peers may (or, in
+// ProxyRequired mode, must) start with a PROXY protocol v1 or v2 header, whose
+// source address then becomes the connection's remote address. Connections
+// from other peers are served as is, and rejected if they send a header.
+// trusted is consulted for every connection, so the trusted networks can be
+// reloaded while listening. headerTimeout bounds the wait for the header.
+func WithProxyProtocol(
+	l net.Listener,
+	trusted func() []*net.IPNet,
+	mode string,
+	headerTimeout time.Duration,
+) (*proxyproto.Listener, error) {
+	if len(trusted()) == 0 {
+		return nil, ErrNoTrustedProxies
+	}
+	use := proxyproto.USE
+	if mode == ProxyRequired {
+		use = proxyproto.REQUIRE
+	}
+	return &proxyproto.Listener{
+		Listener: l,
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if isTrusted(upstream, trusted()) {
+				return use, nil
+			}
+			return proxyproto.REJECT, nil
+		},
+		ValidateHeader:    nil,
+		ReadHeaderTimeout: headerTimeout,
+	}, nil
+}
+
+// isTrusted reports whether addr is a TCP peer inside one of the trusted
+// networks.
+func isTrusted(addr net.Addr, trusted []*net.IPNet) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package listener_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/listener"
+)
+
+// acceptOne dials ln, writes payload and returns the server side remote
+// address together with the first line read from the connection.
+func acceptOne(t *testing.T, ln net.Listener, payload string) (string, string, error) {
+	t.Helper()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte(payload)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return conn.RemoteAddr().String(), line, err
+}
+
+func newListener(t *testing.T, trustedCIDR, mode string) net.Listener {
+	t.Helper()
+	_, trusted, _ := net.ParseCIDR(trustedCIDR)
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	nets := func() []*net.IPNet { return []*net.IPNet{trusted} }
+	ln, err := listener.WithProxyProtocol(raw, nets, mode, time.Second)
+	if err != nil {
+		t.Fatalf("wrap: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+func TestProxyProtocolTrustedPeer(t *testing.T) {
+	ln := newListener(t, "127.0.0.0/8", listener.ProxyOptional)
+
+	remote, line, err := acceptOne(t, ln, "PROXY TCP4 203.0.113.7 192.0.2.1 51000 80\r\nGET / HTTP/1.1\r\n")
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if remote != "203.0.113.7:51000" || line != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("got remote %q line %q", remote, line)
+	}
+
+	// optional: a trusted peer may also connect without a header
+	remote, _, err = acceptOne(t, ln, "GET / HTTP/1.1\r\n")
+	if err != nil || !net.ParseIP(mustHost(t, remote)).IsLoopback() {
+		t.Fatalf("expected the direct peer, got %q (%v)", remote, err)
+	}
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	ln := newListener(t, "127.0.0.0/8", listener.ProxyRequired)
+
+	header := "\r\n\r\n\x00\r\nQUIT\n" + // signature
+		"\x21\x11\x00\x0c" + // v2 PROXY, TCP over IPv4, 12 address bytes
+		"\xc6\x33\x64\x09" + "\xc0\x00\x02\x01" + // 198.51.100.9 -> 192.0.2.1
+		"\x1f\x90\x00\x50" // 8080 -> 80
+	remote, _, err := acceptOne(t, ln, header+"GET / HTTP/1.1\r\n")
+	if err != nil || remote != "198.51.100.9:8080" {
+		t.Fatalf("got remote %q (%v)", remote, err)
+	}
+
+	if _, _, err := acceptOne(t, ln, "GET / HTTP/1.1\r\n"); err == nil {
+		t.Fatal("required mode must reject connections without a header")
+	}
+}
+
+func TestProxyProtocolUntrustedPeer(t *testing.T) {
+	ln := newListener(t, "10.0.0.0/8", listener.ProxyOptional)
+
+	if _, _, err := acceptOne(t, ln, "PROXY TCP4 203.0.113.7 192.0.2.1 51000 80\r\nGET / HTTP/1.1\r\n"); err == nil {
+		t.Fatal("expected a header from an untrusted peer to be rejected")
+	}
+	if _, line, err := acceptOne(t, ln, "GET / HTTP/1.1\r\n"); err != nil || line != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("untrusted peer without header: %q (%v)", line, err)
+	}
+}
+
+func TestWithProxyProtocolRequiresTrustedProxies(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer raw.Close()
+	none := func() []*net.IPNet { return nil }
+	if _, err := listener.WithProxyProtocol(raw, none, listener.ProxyOptional, time.Second); !errors.Is(
+		err, listener.ErrNoTrustedProxies,
+	) {
+		t.Fatalf("expected ErrNoTrustedProxies, got %v", err)
+	}
+}
+
+func TestParseProxyMode(t *testing.T) {
+	for in, want := range map[string]string{"": "off", "on": "optional", "Required": "required"} {
+		if got, err := listener.ParseProxyMode(in); err != nil || got != want {
+			t.Errorf("ParseProxyMode(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := listener.ParseProxyMode("v2"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func mustHost(t *testing.T, hostport string) string {
+	t.Helper()
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		t.Fatalf("split %q: %v", hostport, err)
+	}
+	return host
+}
@@ -0,0 +1,68 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// listenFDsStart is the first file descriptor passed by systemd.
+	listenFDsStart = 3
+	// unnamedFD is the name systemd reports for sockets without
+	// FileDescriptorName=.
+	unnamedFD = "unknown"
+)
+
+// ListenFDNames returns the names of the sockets systemd passed to the
+// process with the given pid, one per file descriptor starting at 3, as set
+// by FileDescriptorName= in the socket unit ("unknown" when unset). It
+// returns nil when the process was not socket activated, including when
+// LISTEN_PID names another process.
+func ListenFDNames(getenv func(string) string, pid int) ([]string, error) {
+	if getenv("LISTEN_PID") == "" || getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	names := make([]string, count)
+	given := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	for i := range names {
+		names[i] = unnamedFD
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		}
+	}
+	return names, nil
+}
+
+// Activated returns the listening sockets passed by systemd socket
+// activation, grouped by their FileDescriptorName=, or nil when the process
+// was not socket activated. The LISTEN_* variables are removed from the
+// environment so that child processes do not mistake the sockets for
+// their own.
+func Activated() (map[string][]net.Listener, error) {
+	names, err := ListenFDNames(os.Getenv, os.Getpid())
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(env)
+	}
+	if err != nil || names == nil {
+		return nil, err
+	}
+	listeners := make(map[string][]net.Listener, len(names))
+	for i, name := range names {
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), name) //nolint:gosec // small fd numbers cannot overflow
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket %d (%s): %w", fd, name, err)
+		}
+		listeners[name] = append(listeners[name], ln)
+	}
+	return listeners, nil
+}
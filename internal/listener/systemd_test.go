@@ -0,0 +1,33 @@
+package listener_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/listener"
+)
+
+func TestListenFDNames(t *testing.T) {
+	cases := []struct {
+		name string
+		env  map[string]string
+		want []string
+	}{
+		{"not activated", map[string]string{}, nil},
+		{"other process", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, nil},
+		{"unnamed", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2"}, []string{"unknown", "unknown"}},
+		{"named", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "3", "LISTEN_FDNAMES": "api:admin:"},
+			[]string{"api", "admin", "unknown"}},
+	}
+	for _, tc := range cases {
+		got, err := listener.ListenFDNames(func(key string) string { return tc.env[key] }, 42)
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	bad := map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "many"}
+	if _, err := listener.ListenFDNames(func(key string) string { return bad[key] }, 42); err == nil {
+		t.Fatal("expected an error for a malformed LISTEN_FDS")
+	}
+}
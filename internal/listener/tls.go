@@ -0,0 +1,231 @@
+package listener
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client certificate modes accepted by ParseClientAuth.
+const (
+	// ClientAuthOptional verifies a client certificate when one is sent.
+	ClientAuthOptional = "optional"
+	// ClientAuthRequire rejects handshakes without a verified certificate.
+	ClientAuthRequire = "require"
+)
+
+// ErrNoCertificates is returned by LoadCertPool for a file without PEM
+// certificates.
+var ErrNoCertificates = errors.New("no PEM certificates found")
+
+// TLSConfig describes how the server terminates TLS.
+type TLSConfig struct {
+	// Certificate provides the server certificate.
+	Certificate *Certificate
+	// MinVersion is the lowest accepted protocol version, e.g. "1.2".
+	MinVersion string
+	// CipherSuites restricts the TLS 1.0-1.2 cipher suites; empty keeps the
+	// Go defaults. TLS 1.3 suites are not configurable.
+	CipherSuites []string
+	// ClientCAs verifies client certificates; nil disables mTLS.
+	ClientCAs *x509.CertPool
+	// ClientAuth is ClientAuthOptional or ClientAuthRequire.
+	ClientAuth string
+	// HTTP2 offers HTTP/2 besides HTTP/1.1 through ALPN.
+	HTTP2 bool
+}
+
+// NewTLSConfig returns the server side tls.Config for cfg, for listeners
+// wrapped with tls.NewListener.
+func NewTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	version, err := ParseTLSVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	clientAuth := tls.NoClientCert
+	if cfg.ClientCAs != nil {
+		if clientAuth, err = ParseClientAuth(cfg.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+	protocols := []string{"http/1.1"}
+	if cfg.HTTP2 {
+		protocols = []string{"h2", "http/1.1"}
+	}
+	//nolint:exhaustruct // the remaining settings keep the crypto/tls defaults
+	return &tls.Config{
+		NextProtos:     protocols,
+		GetCertificate: cfg.Certificate.GetCertificate,
+		MinVersion:     version,
+		CipherSuites:   suites,
+		ClientCAs:      cfg.ClientCAs,
+		ClientAuth:     clientAuth,
+	}, nil
+}
+
+// ParseTLSVersion parses a protocol version of the form "1.2". An empty value
+// is TLS 1.2.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "", "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q, use 1.0, 1.1, 1.2 or 1.3", s)
+	}
+}
+
+// ParseCipherSuites maps cipher suite names such as
+// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" to their IDs. Only suites Go
+// considers secure are accepted, and TLS 1.3 suites are refused because
+// crypto/tls does not let them be configured.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	byName := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := byName[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suite %q is TLS 1.3 only and cannot be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// ParseClientAuth parses a client certificate mode. An empty value is
+// ClientAuthOptional, so that health probes without a certificate can still
+// connect.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q, use optional or require", s)
+	}
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s: %w", path, ErrNoCertificates)
+	}
+	return pool, nil
+}
+
+// Certificate serves a certificate and key loaded from PEM files. Handshakes
+// check the files' modification times at most once per interval and load
+// them again when they changed, so renewed certificates are picked up without
+// a restart. A pair that fails to load is reported to OnReload and the
+// previous certificate stays in use.
+type Certificate struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+
+	// OnReload is called after every reload attempt triggered by a file
+	// change, with its error. It may be nil.
+	OnReload func(err error)
+}
+
+// LoadCertificate loads the certificate and key at certFile and keyFile.
+func LoadCertificate(certFile, keyFile string, interval time.Duration) (*Certificate, error) {
+	c := &Certificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		mu:       sync.Mutex{},
+		cert:     nil,
+		modTime:  time.Time{},
+		checked:  time.Time{},
+		OnReload: nil,
+	}
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first when
+// the files changed. It is meant for tls.Config.GetCertificate.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.checked) >= c.interval {
+		c.checked = now
+		modTime, err := c.latestModTime()
+		if err == nil && modTime.Equal(c.modTime) {
+			return c.cert, nil
+		}
+		if err == nil {
+			err = c.load(modTime)
+		}
+		if c.OnReload != nil {
+			c.OnReload(err)
+		}
+	}
+	return c.cert, nil
+}
+
+// load reads the key pair. The caller must hold c.mu or own c exclusively.
+func (c *Certificate) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return nil
+}
+
+// latestModTime returns the later modification time of both files.
+func (c *Certificate) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
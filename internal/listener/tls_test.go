@@ -0,0 +1,141 @@
+package listener_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/listener"
+)
+
+// writeKeyPair writes a self-signed certificate for cn and its key to dir
+// and returns both paths.
+func writeKeyPair(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	//nolint:exhaustruct // only the fields a test certificate needs
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Headers: nil, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Headers: nil, Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, "old.example")
+	cert, err := listener.LoadCertificate(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	var reloads []error
+	cert.OnReload = func(err error) { reloads = append(reloads, err) }
+
+	got, _ := cert.GetCertificate(nil)
+	if cn := commonName(t, got); cn != "old.example" || len(reloads) != 0 {
+		t.Fatalf("got %q after %d reloads", cn, len(reloads))
+	}
+
+	writeKeyPair(t, dir, "new.example")
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		_ = os.Chtimes(path, later, later)
+	}
+	got, _ = cert.GetCertificate(nil)
+	if cn := commonName(t, got); cn != "new.example" || len(reloads) != 1 || reloads[0] != nil {
+		t.Fatalf("got %q after reloads %v", cn, reloads)
+	}
+
+	// a broken pair keeps the previous certificate
+	_ = os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(keyFile, later, later)
+	got, _ = cert.GetCertificate(nil)
+	if cn := commonName(t, got); cn != "new.example" || len(reloads) != 2 || reloads[1] == nil {
+		t.Fatalf("got %q after reloads %v", cn, reloads)
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), "wdns.example")
+	cert, err := listener.LoadCertificate(certFile, keyFile, time.Minute)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	cfg, err := listener.NewTLSConfig(listener.TLSConfig{
+		Certificate:  cert,
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		ClientCAs:    x509.NewCertPool(),
+		ClientAuth:   listener.ClientAuthOptional,
+		HTTP2:        false,
+	})
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || len(cfg.CipherSuites) != 1 ||
+		cfg.CipherSuites[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 || cfg.ClientAuth != tls.VerifyClientCertIfGiven ||
+		len(cfg.NextProtos) != 1 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	for _, bad := range []listener.TLSConfig{
+		{Certificate: cert, MinVersion: "1.4", CipherSuites: nil, ClientCAs: nil, ClientAuth: "", HTTP2: true},
+		{Certificate: cert, MinVersion: "", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}, ClientCAs: nil, ClientAuth: "", HTTP2: true},
+		{Certificate: cert, MinVersion: "", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, ClientCAs: nil, ClientAuth: "", HTTP2: true},
+		{Certificate: cert, MinVersion: "", CipherSuites: nil, ClientCAs: x509.NewCertPool(), ClientAuth: "maybe", HTTP2: true},
+	} {
+		if _, err := listener.NewTLSConfig(bad); err == nil {
+			t.Errorf("expected an error for %+v", bad)
+		}
+	}
+}
+
+func TestLoadCertPool(t *testing.T) {
+	certFile, keyFile := writeKeyPair(t, t.TempDir(), "ca.example")
+	if _, err := listener.LoadCertPool(certFile); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if _, err := listener.LoadCertPool(keyFile); err == nil {
+		t.Fatal("expected an error for a file without certificates")
+	}
+}
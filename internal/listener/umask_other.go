@@ -0,0 +1,13 @@
+//go:build !unix
+
+package listener
+
+import "net"
+
+// listenUnix creates the socket at path. Without a umask the socket gets the
+// permissions of the directory it is created in.
+//
+//nolint:ireturn // net.Listen returns the interface
+func listenUnix(path string, _ bool) (net.Listener, error) {
+	return net.Listen("unix", path) //nolint:wrapcheck // net errors name the address
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package listener
+
+import (
+	"net"
+	"syscall"
+)
+
+// privateUmask leaves the socket file readable and writable by its owner
+// only.
+const privateUmask = 0o177
+
+// listenUnix creates the socket at path. With private the socket is created
+// under a restrictive umask, so that no other user can connect before the
+// configured mode is applied. The umask is process-wide; sockets are created
+// during startup, before anything else writes files.
+//
+//nolint:ireturn // net.Listen returns the interface
+func listenUnix(path string, private bool) (net.Listener, error) {
+	if private {
+		defer syscall.Umask(syscall.Umask(privateUmask))
+	}
+	return net.Listen("unix", path) //nolint:wrapcheck // net errors name the address
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package listener_test
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/listener"
+)
+
+func TestListenUnixRestoresUmask(t *testing.T) {
+	old := syscall.Umask(0o022)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(t.TempDir(), "wdns.sock")
+	ln, err := listener.Listen("unix:"+path, listener.SocketOptions{Mode: 0o666, User: "", Group: ""})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	if mask := syscall.Umask(0o022); mask != 0o022 {
+		t.Fatalf("umask left at %o, want 022", mask)
+	}
+}
@@ -0,0 +1,175 @@
+// Package metrics collects the service's Prometheus metrics and serves them
+// in the exposition format. All methods are safe to call on a nil *Metrics,
+// which disables collection.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "wdns"
+	// maxUpstreamLabels bounds the distinct upstream label values; further
+	// nameservers are reported as "other" since request nameservers are
+	// client supplied.
+	maxUpstreamLabels = 64
+	otherUpstream     = "other"
+)
+
+// Metrics holds the registry and collectors of the service.
+type Metrics struct {
+	registry    *prometheus.Registry
+	requests    *prometheus.CounterVec
+	queries     *prometheus.CounterVec
+	execution   *prometheus.HistogramVec
+	exits       *prometheus.CounterVec
+	truncated   prometheus.Counter
+	rateLimited *prometheus.CounterVec
+
+	mu        sync.Mutex
+	upstreams map[string]bool
+}
+
+// New returns Metrics registered with a private registry that also exports
+// the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by handler and status code.",
+		}, []string{"handler", "code"}),
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "queries_total",
+			Help:      "DNS queries by response status, record type and transport.",
+		}, []string{"status", "type", "transport"}),
+		execution: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "kdig_duration_seconds",
+			Help:      "kdig execution latency by upstream.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream"}),
+		exits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "kdig_exits_total",
+			Help:      `kdig executions by exit code ("timeout" or "error" when kdig did not exit normally).`,
+		}, []string{"code"}),
+		truncated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "resolver_output_truncated_total",
+			Help:      "Resolver outputs truncated to the configured maximum size.",
+		}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      `Requests rejected by rate limiting, by limiter ("client" or "key").`,
+		}, []string{"limiter"}),
+		mu:        sync.Mutex{},
+		upstreams: make(map[string]bool),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}), //nolint:exhaustruct // defaults
+		m.requests, m.queries, m.execution, m.exits, m.truncated, m.rateLimited,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}) //nolint:exhaustruct // defaults
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on every scrape,
+// e.g. the number of tracked rate limiters.
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{ //nolint:exhaustruct // no labels
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// ObserveRequest counts an HTTP request served by handler.
+func (m *Metrics) ObserveRequest(handler string, code int) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(handler, strconv.Itoa(code)).Inc()
+}
+
+// ObserveQuery counts a DNS query answered with status. An empty transport
+// is reported as "udp".
+func (m *Metrics) ObserveQuery(status int, rrType, transport string) {
+	if m == nil {
+		return
+	}
+	transport = strings.ToLower(transport)
+	if transport == "" {
+		transport = "udp"
+	}
+	m.queries.WithLabelValues(strconv.Itoa(status), rrType, transport).Inc()
+}
+
+// ObserveExecution records a kdig execution against upstream. exitCode is the
+// process exit code, or -1 when kdig did not exit normally; timedOut marks
+// executions killed by their deadline.
+func (m *Metrics) ObserveExecution(upstream string, elapsed time.Duration, exitCode int, timedOut bool) {
+	if m == nil {
+		return
+	}
+	m.execution.WithLabelValues(m.upstreamLabel(upstream)).Observe(elapsed.Seconds())
+	code := strconv.Itoa(exitCode)
+	switch {
+	case timedOut:
+		code = "timeout"
+	case exitCode < 0:
+		code = "error"
+	default:
+	}
+	m.exits.WithLabelValues(code).Inc()
+}
+
+// ObserveTruncated counts a resolver output cut to the maximum size.
+func (m *Metrics) ObserveTruncated() {
+	if m == nil {
+		return
+	}
+	m.truncated.Inc()
+}
+
+// ObserveRateLimited counts a request rejected by limiter.
+func (m *Metrics) ObserveRateLimited(limiter string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(limiter).Inc()
+}
+
+// upstreamLabel returns upstream, or "other" once maxUpstreamLabels distinct
+// upstreams have been seen.
+func (m *Metrics) upstreamLabel(upstream string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.upstreams[upstream] {
+		return upstream
+	}
+	if len(m.upstreams) >= maxUpstreamLabels {
+		return otherUpstream
+	}
+	m.upstreams[upstream] = true
+	return upstream
+}
@@ -0,0 +1,76 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/metrics"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetricsExposition(t *testing.T) {
+	m := metrics.New()
+	m.ObserveRequest("/query", http.StatusOK)
+	m.ObserveQuery(http.StatusOK, "AAAA", "")
+	m.ObserveExecution("quad9-dot", 30*time.Millisecond, 0, false)
+	m.ObserveExecution("9.9.9.9", time.Second, 9, false)
+	m.ObserveExecution("9.9.9.9", 5*time.Second, -1, true)
+	m.ObserveTruncated()
+	m.ObserveRateLimited("client")
+	m.GaugeFunc("rate_limiters", "Tracked clients.", func() float64 { return 3 })
+
+	body := scrape(t, m)
+	for _, want := range []string{
+		`wdns_http_requests_total{code="200",handler="/query"} 1`,
+		`wdns_queries_total{status="200",transport="udp",type="AAAA"} 1`,
+		`wdns_kdig_duration_seconds_count{upstream="quad9-dot"} 1`,
+		`wdns_kdig_duration_seconds_count{upstream="9.9.9.9"} 2`,
+		`wdns_kdig_exits_total{code="0"} 1`,
+		`wdns_kdig_exits_total{code="9"} 1`,
+		`wdns_kdig_exits_total{code="timeout"} 1`,
+		`wdns_resolver_output_truncated_total 1`,
+		`wdns_rate_limited_total{limiter="client"} 1`,
+		`wdns_rate_limiters 3`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+}
+
+func TestMetricsBoundUpstreamLabels(t *testing.T) {
+	m := metrics.New()
+	for i := range 100 {
+		m.ObserveExecution("192.0.2."+strconv.Itoa(i), time.Millisecond, 0, false)
+	}
+	body := scrape(t, m)
+	if !strings.Contains(body, `wdns_kdig_duration_seconds_count{upstream="other"} 36`) {
+		t.Fatalf("expected upstreams beyond the label limit to be reported as other")
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *metrics.Metrics
+	m.ObserveRequest("/query", http.StatusOK)
+	m.ObserveQuery(http.StatusOK, "A", "tcp")
+	m.ObserveExecution("x", time.Millisecond, 0, false)
+	m.ObserveTruncated()
+	m.ObserveRateLimited("key")
+	m.GaugeFunc("x", "x", func() float64 { return 0 })
+}
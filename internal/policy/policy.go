@@ -0,0 +1,315 @@
+// Package policy decides which nameserver targets user requests may query, so
+// that wdns cannot be used to probe internal networks or arbitrary ports.
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	portDNS   = 53
+	portHTTPS = 443
+	portDoT   = 853
+)
+
+// ErrRefused is wrapped by every error returned from Check.
+var ErrRefused = errors.New("nameserver refused")
+
+// BlockedRanges returns the address ranges refused unless explicitly allowed:
+// unspecified, loopback, private, shared (CGNAT), link-local (including cloud
+// metadata endpoints), multicast and reserved addresses. NAT64 and 6to4
+// addresses are checked by the IPv4 address they embed.
+func BlockedRanges() []netip.Prefix {
+	return []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("169.254.0.0/16"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("224.0.0.0/4"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("::/128"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("fc00::/7"),
+		netip.MustParsePrefix("fe80::/10"),
+		netip.MustParsePrefix("ff00::/8"),
+	}
+}
+
+// DefaultPorts returns the ports allowed when no port list is configured:
+// DNS, DNS-over-HTTPS and DNS-over-TLS.
+func DefaultPorts() []uint16 {
+	return []uint16{portDNS, portHTTPS, portDoT}
+}
+
+// Config lists the explicit policy rules. Explicit allow entries take
+// precedence over deny entries, which take precedence over the built-in
+// BlockedRanges; any other public address is allowed.
+type Config struct {
+	// AllowCIDRs are accepted even when inside BlockedRanges or DenyCIDRs.
+	AllowCIDRs []netip.Prefix
+	// DenyCIDRs are refused.
+	DenyCIDRs []netip.Prefix
+	// AllowHosts are hostnames accepted without address checks. An entry
+	// "*.example.com" matches every subdomain of example.com.
+	AllowHosts []string
+	// DenyHosts are hostnames refused before they are resolved.
+	DenyHosts []string
+	// Ports restricts the target port (default DefaultPorts).
+	Ports []uint16
+}
+
+// Policy checks nameserver targets against a Config, which can be replaced
+// while requests are being checked.
+type Policy struct {
+	cfg atomic.Pointer[Config]
+	// Lookup resolves hostnames; it defaults to net.DefaultResolver.
+	Lookup func(ctx context.Context, host string) ([]netip.Addr, error)
+}
+
+// New returns a Policy enforcing cfg.
+func New(cfg Config) *Policy {
+	p := &Policy{
+		cfg: atomic.Pointer[Config]{},
+		Lookup: func(ctx context.Context, host string) ([]netip.Addr, error) {
+			return net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		},
+	}
+	p.SetConfig(cfg)
+	return p
+}
+
+// SetConfig atomically replaces the configuration enforced by p. Checks in
+// progress complete with the previous configuration.
+func (p *Policy) SetConfig(cfg Config) {
+	if len(cfg.Ports) == 0 {
+		cfg.Ports = DefaultPorts()
+	}
+	p.cfg.Store(&cfg)
+}
+
+// Check reports whether nameserver may be queried over transport. Hostnames
+// are resolved and every address must pass. The returned error wraps
+// ErrRefused and explains the reason.
+func (p *Policy) Check(ctx context.Context, nameserver, transport string) error {
+	_, err := p.Resolve(ctx, nameserver, transport)
+	return err
+}
+
+// Resolve checks nameserver like Check and returns the address it was
+// checked at, so that the query can be sent there instead of resolving the
+// hostname again, which a rebinding DNS record could answer differently. The
+// address is invalid when there is nothing to pin: for IP literals and for
+// hostnames accepted through AllowHosts, which are trusted without a lookup.
+func (p *Policy) Resolve(ctx context.Context, nameserver, transport string) (netip.Addr, error) {
+	cfg := p.cfg.Load()
+	host, port, err := splitTarget(nameserver, transport)
+	if err != nil {
+		return netip.Addr{}, refused("%v", err)
+	}
+	if !slices.Contains(cfg.Ports, port) {
+		return netip.Addr{}, refused("port %d is not allowed", port)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err == nil {
+		if reason := addrReason(cfg, addr); reason != "" {
+			return netip.Addr{}, refused("%s", reason)
+		}
+		return netip.Addr{}, nil
+	}
+
+	name := normalizeHost(host)
+	if matchHost(cfg.AllowHosts, name) {
+		return netip.Addr{}, nil
+	}
+	if matchHost(cfg.DenyHosts, name) {
+		return netip.Addr{}, refused("host %s is denied", name)
+	}
+	addrs, err := p.Lookup(ctx, name)
+	if err != nil || len(addrs) == 0 {
+		return netip.Addr{}, refused("cannot resolve %s", name)
+	}
+	for _, a := range addrs {
+		if reason := addrReason(cfg, a); reason != "" {
+			return netip.Addr{}, refused("%s resolves to %s", name, reason)
+		}
+	}
+	return addrs[0].Unmap(), nil
+}
+
+// addrReason returns why addr is refused, or "" when it is allowed.
+// IPv4-mapped IPv6 addresses are checked as IPv4 and zones are dropped, since
+// a zoned address never matches a prefix. NAT64 and 6to4 addresses must
+// also pass with the IPv4 address they embed.
+func addrReason(cfg *Config, addr netip.Addr) string {
+	addr = addr.Unmap().WithZone("")
+	switch {
+	case containsAddr(cfg.AllowCIDRs, addr):
+		return ""
+	case containsAddr(cfg.DenyCIDRs, addr):
+		return "denied address " + addr.String()
+	case containsAddr(BlockedRanges(), addr):
+		return "private or reserved address " + addr.String()
+	default:
+	}
+	if embedded, ok := embeddedIPv4(addr); ok {
+		if reason := addrReason(cfg, embedded); reason != "" {
+			return reason + " embedded in " + addr.String()
+		}
+	}
+	return ""
+}
+
+// embeddedIPv4 returns the IPv4 address carried by a NAT64 (64:ff9b::/96,
+// RFC 6052) or 6to4 (2002::/16, RFC 3056) address.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+	switch {
+	case !addr.Is6():
+		return netip.Addr{}, false
+	case netip.MustParsePrefix("64:ff9b::/96").Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case netip.MustParsePrefix("2002::/16").Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+func refused(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrRefused}, args...)...)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTarget extracts the host and port a nameserver string refers to. It
+// accepts the same forms as the resolver backends: bare hosts and IPs,
+// host:port, [v6]:port, kdig's host#port and, for https, full URLs.
+func splitTarget(nameserver, transport string) (string, uint16, error) {
+	ns := strings.TrimSpace(nameserver)
+	if strings.HasPrefix(ns, "https://") {
+		u, err := url.Parse(ns)
+		if err != nil || u.Hostname() == "" {
+			return "", 0, fmt.Errorf("invalid nameserver URL %q", ns)
+		}
+		return portOf(u.Hostname(), u.Port(), portHTTPS)
+	}
+
+	def := uint16(portDNS)
+	switch transport {
+	case "tls":
+		def = portDoT
+	case "https":
+		def = portHTTPS
+	default:
+	}
+	if host, port, ok := strings.Cut(ns, "#"); ok {
+		return portOf(strings.Trim(host, "[]"), port, def)
+	}
+	if host, port, err := net.SplitHostPort(ns); err == nil {
+		return portOf(host, port, def)
+	}
+	return portOf(strings.Trim(ns, "[]"), "", def)
+}
+
+func portOf(host, port string, def uint16) (string, uint16, error) {
+	if host == "" || strings.ContainsAny(host, " /@\\") {
+		return "", 0, fmt.Errorf("invalid nameserver host %q", host)
+	}
+	if port == "" {
+		return host, def, nil
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid nameserver port %q", port)
+	}
+	return host, uint16(n), nil
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// matchHost reports whether name equals an entry or, for "*.zone" entries,
+// is a subdomain of zone.
+func matchHost(entries []string, name string) bool {
+	for _, entry := range entries {
+		entry = normalizeHost(entry)
+		if zone, ok := strings.CutPrefix(entry, "*."); ok {
+			if strings.HasSuffix(name, "."+zone) {
+				return true
+			}
+			continue
+		}
+		if name == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses a comma-separated list of CIDRs or single addresses.
+func ParseCIDRs(raw string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, part := range splitList(raw) {
+		if addr, err := netip.ParseAddr(part); err == nil {
+			out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", part, err)
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
+
+// ParsePorts parses a comma-separated list of ports.
+func ParsePorts(raw string) ([]uint16, error) {
+	var out []uint16
+	for _, part := range splitList(raw) {
+		n, err := strconv.ParseUint(part, 10, 16)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid port %q", part)
+		}
+		out = append(out, uint16(n))
+	}
+	return out, nil
+}
+
+// ParseHosts parses a comma-separated list of hostnames.
+func ParseHosts(raw string) []string {
+	return splitList(raw)
+}
+
+func splitList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
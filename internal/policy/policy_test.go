@@ -0,0 +1,158 @@
+package policy_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/policy"
+)
+
+func newPolicy(t *testing.T, allow, deny, allowHosts, denyHosts, ports string) *policy.Policy {
+	t.Helper()
+	allowCIDRs, err := policy.ParseCIDRs(allow)
+	if err != nil {
+		t.Fatalf("parse allow: %v", err)
+	}
+	denyCIDRs, err := policy.ParseCIDRs(deny)
+	if err != nil {
+		t.Fatalf("parse deny: %v", err)
+	}
+	portList, err := policy.ParsePorts(ports)
+	if err != nil {
+		t.Fatalf("parse ports: %v", err)
+	}
+	p := policy.New(policy.Config{
+		AllowCIDRs: allowCIDRs,
+		DenyCIDRs:  denyCIDRs,
+		AllowHosts: policy.ParseHosts(allowHosts),
+		DenyHosts:  policy.ParseHosts(denyHosts),
+		Ports:      portList,
+	})
+	hosts := map[string][]netip.Addr{
+		"dns.google":       {netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("2001:4860:4860::8888")},
+		"rebind.example":   {netip.MustParseAddr("8.8.8.8"), netip.MustParseAddr("10.1.2.3")},
+		"dns.corp.example": {netip.MustParseAddr("10.0.0.53")},
+	}
+	p.Lookup = func(_ context.Context, host string) ([]netip.Addr, error) {
+		if addrs, ok := hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	return p
+}
+
+func TestPolicyDefaults(t *testing.T) {
+	p := newPolicy(t, "", "", "", "", "")
+	cases := []struct {
+		nameserver, transport string
+		allowed               bool
+	}{
+		{"1.1.1.1", "", true},
+		{"9.9.9.9#853", "tls", true},
+		{"[2620:fe::fe]:53", "", true},
+		{"https://dns.google/dns-query", "https", true},
+		{"dns.google", "", true},
+		{"127.0.0.1", "", false},
+		{"10.0.0.1", "tcp", false},
+		{"192.168.1.1#53", "", false},
+		{"169.254.169.254", "", false},
+		{"::ffff:127.0.0.1", "", false},
+		{"fe80::1%eth0", "", false},
+		{"[fd00:ec2::254]:53", "", false},
+		{"64:ff9b::a9fe:a9fe", "", false},
+		{"[64:ff9b::7f00:1]:53", "", false},
+		{"64:ff9b::101:101", "", true},
+		{"2002:a9fe:a9fe::1", "", false},
+		{"2002:c0a8:101::", "", false},
+		{"2002:101:101::1", "", true},
+		{"1.1.1.1:22", "", false},
+		{"1.1.1.1#6379", "", false},
+		{"https://1.1.1.1:8443/dns-query", "https", false},
+		{"rebind.example", "", false},
+		{"unknown.example", "", false},
+		{"bad host", "", false},
+	}
+	for _, tc := range cases {
+		err := p.Check(context.Background(), tc.nameserver, tc.transport)
+		if tc.allowed && err != nil {
+			t.Errorf("%s: expected allowed, got %v", tc.nameserver, err)
+		}
+		if !tc.allowed && !errors.Is(err, policy.ErrRefused) {
+			t.Errorf("%s: expected refusal, got %v", tc.nameserver, err)
+		}
+	}
+}
+
+func TestPolicyLists(t *testing.T) {
+	p := newPolicy(t, "10.0.0.53,0.0.0.0/0", "8.8.0.0/16", "*.corp.example", "dns.google", "53,5353")
+	cases := []struct {
+		nameserver string
+		allowed    bool
+	}{
+		{"10.0.0.53#5353", true},   // explicit allow beats the private range
+		{"10.0.0.54", true},        // 0.0.0.0/0 allow covers every IPv4 address
+		{"dns.corp.example", true}, // allowed host skips address checks
+		{"dns.google", false},      // denied host
+		{"9.9.9.9#853", false},     // port not in the list
+		{"::1", false},             // IPv6 is not covered by the allow list
+	}
+	for _, tc := range cases {
+		err := p.Check(context.Background(), tc.nameserver, "")
+		if tc.allowed != (err == nil) {
+			t.Errorf("%s: allowed=%v, got %v", tc.nameserver, tc.allowed, err)
+		}
+	}
+
+	deny := newPolicy(t, "", "8.8.0.0/16", "", "", "")
+	if err := deny.Check(context.Background(), "8.8.4.4", ""); !errors.Is(err, policy.ErrRefused) {
+		t.Fatalf("expected denied CIDR to be refused, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := policy.ParseCIDRs("10.0.0.0/33"); err == nil {
+		t.Fatalf("expected invalid CIDR error")
+	}
+	if _, err := policy.ParsePorts("53,http"); err == nil {
+		t.Fatalf("expected invalid port error")
+	}
+}
+
+func TestPolicySetConfig(t *testing.T) {
+	p := newPolicy(t, "", "", "", "", "")
+	if err := p.Check(context.Background(), "9.9.9.9", ""); err != nil {
+		t.Fatalf("before: %v", err)
+	}
+	deny, _ := policy.ParseCIDRs("9.9.9.0/24")
+	p.SetConfig(policy.Config{AllowCIDRs: nil, DenyCIDRs: deny, AllowHosts: nil, DenyHosts: nil, Ports: nil})
+	if err := p.Check(context.Background(), "9.9.9.9", ""); !errors.Is(err, policy.ErrRefused) {
+		t.Fatalf("after: got %v, want ErrRefused", err)
+	}
+	if err := p.Check(context.Background(), "1.1.1.1#853", "tls"); err != nil {
+		t.Fatalf("default ports after SetConfig: %v", err)
+	}
+}
+
+func TestPolicyResolve(t *testing.T) {
+	p := newPolicy(t, "", "", "*.corp.example", "", "")
+	cases := []struct {
+		nameserver string
+		want       netip.Addr
+	}{
+		{"dns.google", netip.MustParseAddr("8.8.8.8")}, // the checked address is pinned
+		{"1.1.1.1", netip.Addr{}},                      // literals need no pinning
+		{"dns.corp.example", netip.Addr{}},             // allowed hosts are not looked up
+	}
+	for _, tc := range cases {
+		got, err := p.Resolve(context.Background(), tc.nameserver, "")
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %v, %v, want %v", tc.nameserver, got, err, tc.want)
+		}
+	}
+	if got, err := p.Resolve(context.Background(), "rebind.example", ""); err == nil || got.IsValid() {
+		t.Fatalf("rebind.example: got %v, %v, want a refusal", got, err)
+	}
+}
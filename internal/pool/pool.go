@@ -0,0 +1,192 @@
+// Package pool bounds the number of concurrently executing resolver queries
+// (and therefore kdig child processes) with a limited wait queue.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/telemetry"
+)
+
+// Defaults applied by New to zero Config values.
+const (
+	DefaultMaxRunning   = 16
+	DefaultMaxQueued    = 64
+	DefaultQueueTimeout = 2 * time.Second
+)
+
+var (
+	// ErrQueueFull is returned when all execution slots are busy and the wait
+	// queue is full.
+	ErrQueueFull = errors.New("resolver queue is full")
+	// ErrQueueTimeout is returned when a query waited longer than the queue
+	// timeout for an execution slot.
+	ErrQueueTimeout = errors.New("timed out waiting for a resolver slot")
+	// ErrClosed is returned for queries queued or started after Close.
+	ErrClosed = errors.New("resolver pool is shutting down")
+)
+
+// Config bounds a Pool. Zero values select the defaults.
+type Config struct {
+	// MaxRunning is the number of queries executed at once (default 16).
+	MaxRunning int
+	// MaxQueued is the number of queries allowed to wait for a slot
+	// (default 64).
+	MaxQueued int
+	// QueueTimeout is the longest a query waits for a slot (default 2s).
+	QueueTimeout time.Duration
+}
+
+// Stats is a snapshot of the pool gauges and counters.
+type Stats struct {
+	// Running is the number of queries currently executing.
+	Running int64 `json:"running"`
+	// Queued is the number of queries currently waiting for a slot.
+	Queued int64 `json:"queued"`
+	// MaxRunning and MaxQueued are the configured bounds.
+	MaxRunning int `json:"max_running"`
+	MaxQueued  int `json:"max_queued"`
+	// Rejected counts queries refused because the queue was full.
+	Rejected uint64 `json:"rejected"`
+	// TimedOut counts queries that gave up waiting for a slot.
+	TimedOut uint64 `json:"timed_out"`
+}
+
+// Pool is a resolver.Resolver that runs at most MaxRunning queries of next at
+// once. Further queries wait in a bounded queue for up to QueueTimeout.
+type Pool struct {
+	next  resolver.Resolver
+	cfg   Config
+	slots chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	running  atomic.Int64
+	queued   atomic.Int64
+	rejected atomic.Uint64
+	timedOut atomic.Uint64
+}
+
+// New wraps next with a bounded execution pool.
+func New(next resolver.Resolver, cfg Config) *Pool {
+	if cfg.MaxRunning <= 0 {
+		cfg.MaxRunning = DefaultMaxRunning
+	}
+	if cfg.MaxQueued <= 0 {
+		cfg.MaxQueued = DefaultMaxQueued
+	}
+	if cfg.QueueTimeout <= 0 {
+		cfg.QueueTimeout = DefaultQueueTimeout
+	}
+	return &Pool{
+		next:      next,
+		cfg:       cfg,
+		slots:     make(chan struct{}, cfg.MaxRunning),
+		closed:    make(chan struct{}),
+		closeOnce: sync.Once{},
+		running:   atomic.Int64{},
+		queued:    atomic.Int64{},
+		rejected:  atomic.Uint64{},
+		timedOut:  atomic.Uint64{},
+	}
+}
+
+// QueryTimeout reports the timeout of the wrapped resolver.
+func (p *Pool) QueryTimeout() time.Duration {
+	return p.next.QueryTimeout()
+}
+
+// QueueTimeout reports the longest a query waits for a slot.
+func (p *Pool) QueueTimeout() time.Duration {
+	return p.cfg.QueueTimeout
+}
+
+// Run executes req once a slot is free. It fails fast with ErrQueueFull when
+// the queue is full and with ErrQueueTimeout when no slot frees up in time.
+func (p *Pool) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
+	_, span := telemetry.Tracer().Start(ctx, "pool.acquire")
+	err := p.acquire(ctx)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	if err != nil {
+		return nil, "", err
+	}
+	p.running.Add(1)
+	defer func() {
+		p.running.Add(-1)
+		<-p.slots
+	}()
+	return p.next.Run(ctx, req)
+}
+
+// Close fails every queued query with ErrClosed and refuses new ones, so that
+// a shutting down server only waits for the queries already executing, which
+// are not affected. It is safe to call more than once.
+func (p *Pool) Close() {
+	p.closeOnce.Do(func() { close(p.closed) })
+}
+
+// acquire takes an execution slot, queueing if none is free.
+func (p *Pool) acquire(ctx context.Context) error {
+	select {
+	case <-p.closed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if p.queued.Add(1) > int64(p.cfg.MaxQueued) {
+		p.queued.Add(-1)
+		p.rejected.Add(1)
+		return ErrQueueFull
+	}
+	defer p.queued.Add(-1)
+
+	timer := time.NewTimer(p.cfg.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		p.timedOut.Add(1)
+		return ErrQueueTimeout
+	case <-p.closed:
+		return ErrClosed
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for resolver slot: %w", ctx.Err())
+	}
+}
+
+// Stats returns a snapshot of the pool gauges and counters.
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Running:    p.running.Load(),
+		Queued:     p.queued.Load(),
+		MaxRunning: p.cfg.MaxRunning,
+		MaxQueued:  p.cfg.MaxQueued,
+		Rejected:   p.rejected.Load(),
+		TimedOut:   p.timedOut.Load(),
+	}
+}
+
+// Overloaded reports whether err means the query was refused by a Pool
+// rather than failed upstream.
+func Overloaded(err error) bool {
+	return errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueTimeout) || errors.Is(err, ErrClosed)
+}
@@ -0,0 +1,149 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/pool"
+)
+
+// blockingResolver holds every query until release is closed.
+type blockingResolver struct {
+	release chan struct{}
+}
+
+func (r blockingResolver) Run(ctx context.Context, _ api.RequestPayload) ([]byte, string, error) {
+	select {
+	case <-r.release:
+		return []byte("ok"), "kdig", nil
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+}
+
+func (blockingResolver) QueryTimeout() time.Duration {
+	return time.Second
+}
+
+func request() api.RequestPayload {
+	return api.RequestPayload{
+		Nameserver:       "1.1.1.1",
+		Short:            true,
+		DNSSEC:           false,
+		Type:             "A",
+		Transport:        "",
+		Name:             "example.com",
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not reached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolQueueFull(t *testing.T) {
+	next := blockingResolver{release: make(chan struct{})}
+	p := pool.New(next, pool.Config{MaxRunning: 1, MaxQueued: 1, QueueTimeout: time.Minute})
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, _, err := p.Run(context.Background(), request())
+			errs <- err
+		}()
+	}
+	waitFor(t, func() bool { s := p.Stats(); return s.Running == 1 && s.Queued == 1 })
+
+	if _, _, err := p.Run(context.Background(), request()); !errors.Is(err, pool.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	close(next.release)
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued query failed: %v", err)
+		}
+	}
+	if s := p.Stats(); s.Running != 0 || s.Queued != 0 || s.Rejected != 1 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestPoolQueueTimeout(t *testing.T) {
+	next := blockingResolver{release: make(chan struct{})}
+	defer close(next.release)
+	p := pool.New(next, pool.Config{MaxRunning: 1, MaxQueued: 0, QueueTimeout: 20 * time.Millisecond})
+
+	go func() { _, _, _ = p.Run(context.Background(), request()) }()
+	waitFor(t, func() bool { return p.Stats().Running == 1 })
+
+	_, _, err := p.Run(context.Background(), request())
+	if !errors.Is(err, pool.ErrQueueTimeout) || !pool.Overloaded(err) {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+	if s := p.Stats(); s.TimedOut != 1 || s.Queued != 0 {
+		t.Fatalf("unexpected stats: %+v", s)
+	}
+}
+
+func TestPoolCallerCanceledWhileQueued(t *testing.T) {
+	next := blockingResolver{release: make(chan struct{})}
+	defer close(next.release)
+	p := pool.New(next, pool.Config{MaxRunning: 1, MaxQueued: 1, QueueTimeout: time.Minute})
+
+	go func() { _, _, _ = p.Run(context.Background(), request()) }()
+	waitFor(t, func() bool { return p.Stats().Running == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err := p.Run(ctx, request())
+	if !errors.Is(err, context.DeadlineExceeded) || pool.Overloaded(err) {
+		t.Fatalf("expected the caller deadline, got %v", err)
+	}
+}
+
+func TestPoolCloseCancelsQueuedOnly(t *testing.T) {
+	next := blockingResolver{release: make(chan struct{})}
+	p := pool.New(next, pool.Config{MaxRunning: 1, MaxQueued: 1, QueueTimeout: time.Minute})
+
+	running := make(chan error, 1)
+	queued := make(chan error, 1)
+	go func() {
+		_, _, err := p.Run(context.Background(), request())
+		running <- err
+	}()
+	waitFor(t, func() bool { return p.Stats().Running == 1 })
+	go func() {
+		_, _, err := p.Run(context.Background(), request())
+		queued <- err
+	}()
+	waitFor(t, func() bool { return p.Stats().Queued == 1 })
+
+	p.Close()
+	p.Close()
+	if err := <-queued; !errors.Is(err, pool.ErrClosed) || !pool.Overloaded(err) {
+		t.Fatalf("expected the queued query to fail with ErrClosed, got %v", err)
+	}
+	if _, _, err := p.Run(context.Background(), request()); !errors.Is(err, pool.ErrClosed) {
+		t.Fatalf("expected new queries to be refused, got %v", err)
+	}
+	close(next.release)
+	if err := <-running; err != nil {
+		t.Fatalf("running query must complete, got %v", err)
+	}
+}
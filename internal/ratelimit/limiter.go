@@ -31,6 +31,12 @@ func getIP(hostport string) string {
 
 // Allow reports whether the given remote (host:port or IP) is allowed.
 func (m *Manager) Allow(remote string) bool {
+	return m.AllowN(remote, 1)
+}
+
+// AllowN reports whether the given remote may make n requests at once, and
+// charges them only if so.
+func (m *Manager) AllowN(remote string, n int) bool {
 	ip := getIP(remote)
 	m.mu.Lock()
 	limiter, ok := m.limiters[ip]
@@ -40,7 +46,27 @@ func (m *Manager) Allow(remote string) bool {
 	}
 	m.mu.Unlock()
 
-	return limiter.Allow()
+	return limiter.AllowN(time.Now(), n)
+}
+
+// SetLimits changes the rate and burst of every client, keeping the tokens
+// each client has accumulated.
+func (m *Manager) SetLimits(rps float64, burst int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rps, m.burst = rps, burst
+	now := time.Now()
+	for _, limiter := range m.limiters {
+		limiter.SetLimitAt(now, rate.Limit(rps))
+		limiter.SetBurstAt(now, burst)
+	}
+}
+
+// Len returns the number of clients currently tracked.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.limiters)
 }
 
 // Cleanup removes limiters that have no tokens (not perfect but keeps map small over time).
@@ -23,3 +23,35 @@ func TestAllow(t *testing.T) {
 		t.Fatalf("request after short sleep should be allowed")
 	}
 }
+
+func TestSetLimitsKeepsBuckets(t *testing.T) {
+	mgr := ratelimit.NewManager(0.001, 1)
+	if !mgr.Allow("192.0.2.1") || mgr.Allow("192.0.2.1") {
+		t.Fatalf("expected a burst of one")
+	}
+	mgr.SetLimits(0.001, 3)
+	if mgr.Allow("192.0.2.1") {
+		t.Errorf("existing client must keep its exhausted bucket")
+	}
+	for i := range 3 {
+		if !mgr.Allow("192.0.2.2") {
+			t.Fatalf("new client request %d should be allowed with burst 3", i)
+		}
+	}
+	if mgr.Len() != 2 {
+		t.Errorf("got %d tracked clients, want 2", mgr.Len())
+	}
+}
+
+func TestAllowNAllOrNothing(t *testing.T) {
+	mgr := ratelimit.NewManager(0.001, 3)
+	if mgr.AllowN("192.0.2.1", 4) {
+		t.Fatalf("four requests must not fit a burst of three")
+	}
+	if !mgr.AllowN("192.0.2.1", 2) {
+		t.Fatalf("a refused charge must not consume tokens")
+	}
+	if mgr.AllowN("192.0.2.1", 2) || !mgr.Allow("192.0.2.1") {
+		t.Fatalf("expected exactly one token left")
+	}
+}
@@ -0,0 +1,30 @@
+package resolver
+
+import "context"
+
+// Meta carries per-query metadata reported by Resolver decorators (such as
+// the response cache) back to the caller of Run.
+type Meta struct {
+	// Cached is set when the output was served from the response cache.
+	Cached bool
+	// TTL is the remaining time to live in seconds of a cached response.
+	TTL uint32
+	// Shared is set when the output came from an upstream execution shared
+	// with other concurrent identical queries.
+	Shared bool
+}
+
+type metaKey struct{}
+
+// WithMeta returns a context carrying a fresh Meta that decorators fill in
+// during Run.
+func WithMeta(ctx context.Context) (context.Context, *Meta) {
+	meta := &Meta{Cached: false, TTL: 0, Shared: false}
+	return context.WithValue(ctx, metaKey{}, meta), meta
+}
+
+// MetaFromContext returns the Meta attached by WithMeta, or nil.
+func MetaFromContext(ctx context.Context) *Meta {
+	meta, _ := ctx.Value(metaKey{}).(*Meta)
+	return meta
+}
@@ -0,0 +1,532 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/rrtype"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+const (
+	defaultDNSPort   = "53"
+	defaultTLSPort   = "853"
+	defaultHTTPSPort = "443"
+	dohPath          = "/dns-query"
+	dohContentType   = "application/dns-message"
+	ednsUDPSize      = 1232
+	maxDoHResponse   = 64 * 1024
+	msPerSecond      = 1000.0
+)
+
+// NativeClient executes DNS queries with a pure-Go DNS client instead of
+// forking kdig. It speaks UDP, TCP, DNS-over-TLS and DNS-over-HTTPS directly
+// and renders the response in the same layouts kdig produces so callers do not
+// need to care which backend served a query.
+type NativeClient struct {
+	Timeout   time.Duration
+	MaxOutput int
+	// Upstreams resolves nameservers that name an upstream profile. Nil
+	// treats every nameserver literally.
+	Upstreams *upstream.Catalog
+	// Observer, when set, receives output truncations.
+	Observer Observer
+	logger   *slog.Logger
+}
+
+// NewNativeClient creates a new NativeClient.
+func NewNativeClient(timeout time.Duration, maxOutput int) *NativeClient {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	return &NativeClient{Timeout: timeout, MaxOutput: maxOutput, Upstreams: nil, Observer: nil, logger: logger}
+}
+
+// QueryTimeout reports the timeout applied to each query.
+func (c *NativeClient) QueryTimeout() time.Duration {
+	return c.Timeout
+}
+
+// Ready reports that the client can execute queries; it runs in-process and
+// needs no external dependency.
+func (c *NativeClient) Ready(context.Context) (string, error) {
+	return "native client", nil
+}
+
+// Run builds a DNS message for the request, sends it to the nameserver using
+// the requested transport and returns the rendered response, the equivalent
+// kdig command string and any error.
+func (c *NativeClient) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
+	ctx, span := startSpan(ctx, "NativeClient.Run", req)
+	defer span.End()
+	req, profile := lookupUpstream(c.Upstreams, req)
+	pinned, hostname := pinRequest(ctx, req)
+	cmdStr := buildKdigCommand(pinned, profile, hostname)
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	msg, err := buildQueryMessage(req)
+	if err != nil {
+		return nil, cmdStr, err
+	}
+
+	start := time.Now()
+	resp, server, err := c.exchange(ctx, msg, req.Nameserver, req.Transport)
+	if err != nil {
+		err = fmt.Errorf("native query failed: %w", err)
+		span.SetStatus(codes.Error, err.Error())
+		c.logger.ErrorContext(ctx, "resolver: native query failed",
+			slog.String("nameserver", req.Nameserver),
+			slog.String("name", req.Name),
+			slog.String("transport", req.Transport),
+			slog.Any("err", err),
+		)
+		return nil, cmdStr, err
+	}
+	elapsed := time.Since(start)
+
+	out, err := renderMessage(req, resp, server, elapsed)
+	if err != nil {
+		return nil, cmdStr, err
+	}
+
+	if c.MaxOutput > 0 && len(out) > c.MaxOutput {
+		out = out[:c.MaxOutput]
+		if c.Observer != nil {
+			c.Observer.ObserveTruncated()
+		}
+	}
+
+	return out, cmdStr, nil
+}
+
+// Exchange sends msg to nameserver over transport and returns the raw
+// response. It lets other packages (e.g. DNSSEC validation) issue wire-level
+// queries with the same transport handling as Run.
+func (c *NativeClient) Exchange(ctx context.Context, msg *dns.Msg, nameserver, transport string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	resp, _, err := c.exchange(ctx, msg, nameserver, transport)
+	return resp, err
+}
+
+// buildQueryMessage creates the query message for req. EDNS is always enabled,
+// matching kdig's defaults, and the DO bit is set when DNSSEC is requested.
+func buildQueryMessage(req api.RequestPayload) (*dns.Msg, error) {
+	rrType, ok := rrtype.Lookup(req.Type)
+	if !ok {
+		return nil, fmt.Errorf("unsupported record type %q", req.Type)
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(req.Name), rrType.Code)
+	msg.RecursionDesired = true
+	msg.CheckingDisabled = req.CheckingDisabled
+	msg.SetEdns0(ednsUDPSize, req.DNSSEC)
+	return msg, nil
+}
+
+// exchange sends msg to nameserver over transport and returns the response and
+// the "address@port(PROTO)" description of the server that answered. A
+// nameserver naming an upstream profile is queried over the profile's
+// transport, trying its addresses in order. A nameserver pinned with
+// WithAddress is sent to the pinned address.
+func (c *NativeClient) exchange(
+	ctx context.Context,
+	msg *dns.Msg,
+	nameserver, transport string,
+) (*dns.Msg, string, error) {
+	if profile, ok := c.Upstreams.Lookup(nameserver); ok {
+		return c.exchangeUpstream(ctx, msg, profile)
+	}
+	target, hostname := pinNameserver(ctx, nameserver)
+	return c.exchangeTarget(ctx, msg, target, transport, hostname)
+}
+
+// exchangeUpstream queries the addresses of profile in order and returns the
+// first answer, or the last error.
+func (c *NativeClient) exchangeUpstream(
+	ctx context.Context,
+	msg *dns.Msg,
+	profile upstream.Profile,
+) (*dns.Msg, string, error) {
+	var lastErr error
+	tried := make(map[string]bool, len(profile.Addresses))
+	for _, addr := range profile.Addresses {
+		target, tlsHostname := profile.Target(addr), profile.TLSHostname
+		if profile.Transport == "https" {
+			target, tlsHostname = upstreamEndpoint(profile, addr)
+		}
+		if tried[target] {
+			continue
+		}
+		tried[target] = true
+		resp, server, err := c.exchangeTarget(ctx, msg, target, profile.Transport, tlsHostname)
+		if err == nil {
+			return resp, server, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, "", fmt.Errorf("upstream %s: %w", profile.Name, lastErr)
+}
+
+// upstreamEndpoint returns the DoH URL of profile with addr as its host, so
+// that the configured address is dialed like kdig does, and the hostname of
+// the expanded URL to verify and send as Host instead.
+func upstreamEndpoint(profile upstream.Profile, addr string) (string, string) {
+	endpoint := profile.Endpoint(addr)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return endpoint, profile.TLSHostname
+	}
+	hostname, port := u.Hostname(), u.Port()
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(addr, port)
+	case strings.Contains(addr, ":"):
+		u.Host = "[" + addr + "]"
+	default:
+		u.Host = addr
+	}
+	if hostname == addr {
+		hostname = ""
+	}
+	return u.String(), hostname
+}
+
+// exchangeTarget sends msg to a literal nameserver. tlsHostname overrides the
+// name verified in the server certificate for "tls" and "https".
+func (c *NativeClient) exchangeTarget(
+	ctx context.Context,
+	msg *dns.Msg,
+	nameserver, transport, tlsHostname string,
+) (*dns.Msg, string, error) {
+	switch strings.ToLower(transport) {
+	case "tcp":
+		addr := nameserverAddr(nameserver, defaultDNSPort)
+		resp, err := c.exchangeConn(ctx, msg, "tcp", addr, nil)
+		return resp, serverDesc(addr, "TCP"), err
+	case "tls":
+		addr := nameserverAddr(nameserver, defaultTLSPort)
+		host, _, _ := net.SplitHostPort(addr)
+		if tlsHostname != "" {
+			host = tlsHostname
+		}
+		tlsConf := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		resp, err := c.exchangeConn(ctx, msg, "tcp-tls", addr, tlsConf)
+		return resp, serverDesc(addr, "TLS"), err
+	case "https":
+		endpoint := dohURL(nameserver)
+		resp, err := c.exchangeHTTPS(ctx, msg, endpoint, tlsHostname)
+		return resp, endpoint + "(HTTPS)", err
+	default:
+		addr := nameserverAddr(nameserver, defaultDNSPort)
+		resp, err := c.exchangeConn(ctx, msg, "udp", addr, nil)
+		if err == nil && resp.Truncated {
+			// retry over TCP like kdig does when the UDP answer is truncated
+			resp, err = c.exchangeConn(ctx, msg, "tcp", addr, nil)
+			return resp, serverDesc(addr, "TCP"), err
+		}
+		return resp, serverDesc(addr, "UDP"), err
+	}
+}
+
+func (c *NativeClient) exchangeConn(
+	ctx context.Context,
+	msg *dns.Msg,
+	network, addr string,
+	tlsConf *tls.Config,
+) (*dns.Msg, error) {
+	client := &dns.Client{Net: network, Timeout: c.Timeout, TLSConfig: tlsConf}
+	resp, _, err := client.ExchangeContext(ctx, msg, addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s exchange with %s: %w", network, addr, err)
+	}
+	return resp, nil
+}
+
+// exchangeHTTPS performs an RFC 8484 POST request against endpoint. A
+// non-empty tlsHostname overrides the name verified in the certificate and
+// the Host header, for endpoints addressed by IP.
+func (c *NativeClient) exchangeHTTPS(ctx context.Context, msg *dns.Msg, endpoint, tlsHostname string) (*dns.Msg, error) {
+	// RFC 8484 recommends a zero ID for cache friendliness; the caller's
+	// message is left untouched and its ID restored on the response
+	query := msg.Copy()
+	query.Id = 0
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("pack query: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+	if err != nil {
+		return nil, fmt.Errorf("build DoH request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", dohContentType)
+	httpReq.Header.Set("Accept", dohContentType)
+	if tlsHostname != "" {
+		httpReq.Host = tlsHostname
+	}
+
+	// redirects are not followed: their targets bypass the nameserver policy
+	// and the pinned address
+	client := &http.Client{
+		Timeout: c.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if tlsHostname != "" {
+		transport := http.DefaultTransport.(*http.Transport).Clone() //nolint:forcetypeassert // DefaultTransport is an *http.Transport
+		tlsConf := &tls.Config{MinVersion: tls.VersionTLS12}
+		if transport.TLSClientConfig != nil {
+			tlsConf = transport.TLSClientConfig.Clone()
+		}
+		tlsConf.ServerName = tlsHostname
+		transport.TLSClientConfig = tlsConf
+		client.Transport = transport
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("DoH request to %s: %w", endpoint, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH request to %s: unexpected status %s", endpoint, httpResp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxDoHResponse))
+	if err != nil {
+		return nil, fmt.Errorf("read DoH response: %w", err)
+	}
+	resp := new(dns.Msg)
+	if err = resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("unpack DoH response: %w", err)
+	}
+	resp.Id = msg.Id
+	return resp, nil
+}
+
+// nameserverAddr turns a nameserver string into a dialable host:port. It
+// accepts bare hosts and IPs, host:port, [v6]:port and kdig's host#port form.
+func nameserverAddr(nameserver, defaultPort string) string {
+	ns := strings.TrimSpace(nameserver)
+	if host, port, ok := strings.Cut(ns, "#"); ok {
+		return net.JoinHostPort(strings.Trim(host, "[]"), port)
+	}
+	if host, port, err := net.SplitHostPort(ns); err == nil {
+		return net.JoinHostPort(host, port)
+	}
+	return net.JoinHostPort(strings.Trim(ns, "[]"), defaultPort)
+}
+
+// dohURL returns the DoH endpoint for nameserver. Full URLs are used as-is,
+// otherwise the RFC 8484 default path on the nameserver host is assumed.
+func dohURL(nameserver string) string {
+	ns := strings.TrimSpace(nameserver)
+	if strings.HasPrefix(ns, "https://") {
+		return ns
+	}
+	addr := nameserverAddr(ns, defaultHTTPSPort)
+	host, port, _ := net.SplitHostPort(addr)
+	if port == defaultHTTPSPort {
+		addr = host
+		if strings.Contains(host, ":") {
+			addr = "[" + host + "]"
+		}
+	}
+	return "https://" + addr + dohPath
+}
+
+func serverDesc(addr, proto string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr + "(" + proto + ")"
+	}
+	return host + "@" + port + "(" + proto + ")"
+}
+
+// renderMessage formats resp according to the output mode requested in req.
+func renderMessage(req api.RequestPayload, resp *dns.Msg, server string, elapsed time.Duration) ([]byte, error) {
+	switch {
+	case req.Short:
+		return renderShort(resp), nil
+	case req.AsJSON:
+		return renderJSON(resp)
+	default:
+		return renderText(resp, server, elapsed), nil
+	}
+}
+
+// renderShort mirrors kdig +short: one answer RDATA per line.
+func renderShort(resp *dns.Msg) []byte {
+	var buf bytes.Buffer
+	for _, rr := range resp.Answer {
+		buf.WriteString(rdataString(rr))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// renderText mirrors kdig's default multi-section text output.
+func renderText(resp *dns.Msg, server string, elapsed time.Duration) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, ";; ->>HEADER<<- opcode: %s; status: %s; id: %d\n",
+		dns.OpcodeToString[resp.Opcode], rcodeString(resp), resp.Id)
+	fmt.Fprintf(&buf, ";; Flags: %s; QUERY: %d; ANSWER: %d; AUTHORITY: %d; ADDITIONAL: %d\n",
+		headerFlags(resp), len(resp.Question), len(resp.Answer), len(resp.Ns), len(resp.Extra))
+
+	if opt := resp.IsEdns0(); opt != nil {
+		buf.WriteString("\n;; EDNS PSEUDOSECTION:\n")
+		flags := ""
+		if opt.Do() {
+			flags = "do"
+		}
+		fmt.Fprintf(&buf, ";; Version: %d; flags: %s; UDP size: %d B; ext-rcode: %s\n",
+			opt.Version(), flags, opt.UDPSize(), rcodeString(resp))
+	}
+
+	buf.WriteString("\n;; QUESTION SECTION:\n")
+	for _, q := range resp.Question {
+		fmt.Fprintf(&buf, ";; %s\t\t%s\t%s\n", q.Name, dns.ClassToString[q.Qclass], dns.TypeToString[q.Qtype])
+	}
+	writeSection(&buf, "ANSWER", resp.Answer)
+	writeSection(&buf, "AUTHORITY", resp.Ns)
+	writeSection(&buf, "ADDITIONAL", withoutOPT(resp.Extra))
+
+	fmt.Fprintf(&buf, "\n;; Received %d B\n", resp.Len())
+	fmt.Fprintf(&buf, ";; Time %s\n", time.Now().UTC().Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&buf, ";; From %s in %.1f ms\n", server, elapsed.Seconds()*msPerSecond)
+	return buf.Bytes()
+}
+
+func writeSection(buf *bytes.Buffer, name string, rrs []dns.RR) {
+	if len(rrs) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "\n;; %s SECTION:\n", name)
+	for _, rr := range rrs {
+		buf.WriteString(rr.String())
+		buf.WriteByte('\n')
+	}
+}
+
+// renderJSON encodes resp in the RFC 8427 layout emitted by kdig +json.
+func renderJSON(resp *dns.Msg) ([]byte, error) {
+	obj := map[string]interface{}{
+		"ID":      resp.Id,
+		"QR":      resp.Response,
+		"Opcode":  resp.Opcode,
+		"AA":      resp.Authoritative,
+		"TC":      resp.Truncated,
+		"RD":      resp.RecursionDesired,
+		"RA":      resp.RecursionAvailable,
+		"AD":      resp.AuthenticatedData,
+		"CD":      resp.CheckingDisabled,
+		"RCODE":   resp.Rcode,
+		"QDCOUNT": len(resp.Question),
+		"ANCOUNT": len(resp.Answer),
+		"NSCOUNT": len(resp.Ns),
+		"ARCOUNT": len(resp.Extra),
+	}
+	if len(resp.Question) > 0 {
+		q := resp.Question[0]
+		obj["QNAME"] = q.Name
+		obj["QTYPE"] = q.Qtype
+		obj["QTYPEname"] = dns.TypeToString[q.Qtype]
+		obj["QCLASS"] = q.Qclass
+		obj["QCLASSname"] = dns.ClassToString[q.Qclass]
+	}
+	if len(resp.Answer) > 0 {
+		obj["answerRRs"] = jsonRRs(resp.Answer)
+	}
+	if len(resp.Ns) > 0 {
+		obj["authorityRRs"] = jsonRRs(resp.Ns)
+	}
+	if extra := withoutOPT(resp.Extra); len(extra) > 0 {
+		obj["additionalRRs"] = jsonRRs(extra)
+	}
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("encode json answer: %w", err)
+	}
+	return append(out, '\n'), nil
+}
+
+func jsonRRs(rrs []dns.RR) []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(rrs))
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		typeName := dns.TypeToString[hdr.Rrtype]
+		out = append(out, map[string]interface{}{
+			"NAME":             hdr.Name,
+			"TYPE":             hdr.Rrtype,
+			"TYPEname":         typeName,
+			"CLASS":            hdr.Class,
+			"CLASSname":        dns.ClassToString[hdr.Class],
+			"TTL":              hdr.Ttl,
+			"rdata" + typeName: rdataString(rr),
+		})
+	}
+	return out
+}
+
+// rdataString returns the presentation format of the RDATA of rr.
+func rdataString(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+func headerFlags(resp *dns.Msg) string {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{resp.Response, "qr"},
+		{resp.Authoritative, "aa"},
+		{resp.Truncated, "tc"},
+		{resp.RecursionDesired, "rd"},
+		{resp.RecursionAvailable, "ra"},
+		{resp.Zero, "z"},
+		{resp.AuthenticatedData, "ad"},
+		{resp.CheckingDisabled, "cd"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	return strings.Join(flags, " ")
+}
+
+func rcodeString(resp *dns.Msg) string {
+	if s, ok := dns.RcodeToString[resp.Rcode]; ok {
+		return s
+	}
+	return "RCODE" + strconv.Itoa(resp.Rcode)
+}
+
+func withoutOPT(rrs []dns.RR) []dns.RR {
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if _, isOPT := rr.(*dns.OPT); !isOPT {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,311 @@
+package resolver_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/testutil"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+func TestNativeClientLocalUDP(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	req := api.RequestPayload{
+		Nameserver:       addr,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "udp",
+		Short:            true,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+
+	out, cmd, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "93.184.216.34" {
+		t.Fatalf("unexpected short answer: %q", got)
+	}
+	if want := resolver.BuildKdigCommandForTest(req); cmd != want {
+		t.Fatalf("expected command %q, got %q", want, cmd)
+	}
+}
+
+func TestNativeClientTextOutput(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	req := api.RequestPayload{
+		Nameserver:       addr,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+
+	out, _, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"status: NOERROR", ";; ANSWER SECTION:", "93.184.216.34", "(UDP)"} {
+		if !strings.Contains(string(out), want) {
+			t.Fatalf("expected %q in output, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestNativeClientJSONOutput(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	req := api.RequestPayload{
+		Nameserver:       addr,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "udp",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           true,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+
+	out, _, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var parsed struct {
+		Answers []map[string]interface{} `json:"answerRRs"`
+	}
+	if err = json.Unmarshal(out, &parsed); err != nil {
+		t.Fatalf("invalid json output: %v\n%s", err, out)
+	}
+	if len(parsed.Answers) != 1 || parsed.Answers[0]["rdataA"] != "93.184.216.34" {
+		t.Fatalf("unexpected answers: %+v", parsed.Answers)
+	}
+}
+
+func TestNewUnknownBackend(t *testing.T) {
+	if _, err := resolver.New("bogus", time.Second, 0, nil); err == nil {
+		t.Fatalf("expected error for unknown backend")
+	}
+}
+
+func TestNativeClientUpstreamProfile(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("split %q: %v", addr, err)
+	}
+	portNum, _ := strconv.Atoi(port)
+	catalog, err := upstream.NewCatalog([]upstream.Profile{{
+		Name:        "local",
+		Description: "",
+		Addresses:   []string{host},
+		Port:        portNum,
+		Transport:   "",
+		TLSHostname: "",
+		DoHURL:      "",
+		DNSSEC:      false,
+	}})
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	client.Upstreams = catalog
+	req := api.RequestPayload{
+		Nameserver:       "local",
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "",
+		Short:            true,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+
+	out, cmd, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "93.184.216.34" {
+		t.Fatalf("unexpected short answer: %q", got)
+	}
+	if !strings.HasPrefix(cmd, "kdig @"+host+"#"+port+" ") {
+		t.Fatalf("expected the profile to be expanded in %q", cmd)
+	}
+}
+
+func TestNativeClientPinnedAddress(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// the hostname does not resolve; the query must go to the pinned address
+	nameserver := "ns.pinned.invalid:" + port
+	ctx := resolver.WithAddress(context.Background(), nameserver, netip.MustParseAddr("127.0.0.1"))
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	req := api.RequestPayload{
+		Nameserver:       nameserver,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "udp",
+		Short:            true,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+	out, cmd, err := client.Run(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "93.184.216.34" {
+		t.Fatalf("unexpected short answer: %q", got)
+	}
+	if !strings.HasPrefix(cmd, "kdig @127.0.0.1:"+port+" ") {
+		t.Fatalf("expected the pinned address in %q", cmd)
+	}
+}
+
+func TestNativeClientDoHKeepsMessageID(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil || query.Id != 0 {
+			http.Error(w, "expected a query with ID 0", http.StatusBadRequest)
+			return
+		}
+		wire, _ := new(dns.Msg).SetReply(query).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(wire)
+	}))
+	defer srv.Close()
+	// trust the test server's certificate for the duration of the test
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	msg := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	msg.Id = 4242
+	resp, err := resolver.NewNativeClient(2*time.Second, 4096).Exchange(context.Background(), msg, srv.URL+"/dns-query", "https")
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if msg.Id != 4242 || resp.Id != 4242 {
+		t.Fatalf("got query ID %d and response ID %d, want 4242", msg.Id, resp.Id)
+	}
+}
+
+func TestNativeClientDoHProfileDialsAddresses(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "example.com" {
+			http.Error(w, "unexpected host "+r.Host, http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if err := query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wire, _ := new(dns.Msg).SetReply(query).Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(wire)
+	}))
+	defer srv.Close()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	// example.com is only the certificate name; the address must be dialed
+	catalog, err := upstream.NewCatalog([]upstream.Profile{{
+		Name:        "doh",
+		Description: "",
+		Addresses:   []string{"127.0.0.1"},
+		Port:        portNum,
+		Transport:   "https",
+		TLSHostname: "example.com",
+		DoHURL:      "https://{hostname}:{port}/dns-query",
+		DNSSEC:      false,
+	}})
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	client.Upstreams = catalog
+	msg := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	if _, err := client.Exchange(context.Background(), msg, "doh", ""); err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+}
+
+func TestNativeClientDoHRefusesRedirect(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// e.g. a cloud metadata endpoint the nameserver policy refuses
+		http.Redirect(w, r, target.URL+"/latest/meta-data/", http.StatusTemporaryRedirect)
+	}))
+	defer srv.Close()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	msg := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	_, err := resolver.NewNativeClient(2*time.Second, 4096).Exchange(context.Background(), msg, srv.URL+"/dns-query", "https")
+	if err == nil || !strings.Contains(err.Error(), "307") {
+		t.Fatalf("expected the redirect to fail the query, got %v", err)
+	}
+	if followed {
+		t.Fatal("the redirect was followed")
+	}
+}
@@ -0,0 +1,367 @@
+package resolver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Section names used while walking kdig output.
+const (
+	sectionNone       = ""
+	sectionEDNS       = "EDNS PSEUDOSECTION"
+	sectionQuestion   = "QUESTION SECTION"
+	sectionAnswer     = "ANSWER SECTION"
+	sectionAuthority  = "AUTHORITY SECTION"
+	sectionAdditional = "ADDITIONAL SECTION"
+)
+
+// ErrNoHeader is returned by ParseKdigOutput when the output contains no
+// message header, e.g. for +short or +json output or a failed query.
+var ErrNoHeader = errors.New("kdig output contains no message header")
+
+// Message is the structured, kdig-version independent representation of a
+// DNS response as returned by the `structured` response mode.
+type Message struct {
+	Header     Header     `json:"header"`
+	EDNS       *EDNS      `json:"edns,omitempty"`
+	Question   []Question `json:"question"`
+	Answer     []Record   `json:"answer"`
+	Authority  []Record   `json:"authority"`
+	Additional []Record   `json:"additional"`
+	Server     *Server    `json:"server,omitempty"`
+	Timing     *Timing    `json:"timing,omitempty"`
+	// TLS describes the negotiated TLS session for DoT/DoH queries.
+	TLS string `json:"tls,omitempty"`
+}
+
+// Header holds the DNS message header.
+type Header struct {
+	Opcode          string   `json:"opcode"`
+	Rcode           string   `json:"rcode"`
+	ID              int      `json:"id"`
+	Flags           []string `json:"flags"`
+	QueryCount      int      `json:"query_count"`
+	AnswerCount     int      `json:"answer_count"`
+	AuthorityCount  int      `json:"authority_count"`
+	AdditionalCount int      `json:"additional_count"`
+}
+
+// EDNS holds the OPT pseudo-record of the response.
+type EDNS struct {
+	Version  int          `json:"version"`
+	Flags    []string     `json:"flags"`
+	UDPSize  int          `json:"udp_size"`
+	ExtRcode string       `json:"ext_rcode"`
+	Options  []EDNSOption `json:"options,omitempty"`
+}
+
+// EDNSOption is a single EDNS option as printed by kdig.
+type EDNSOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Question is an entry of the question section.
+type Question struct {
+	Name  string `json:"name"`
+	Class string `json:"class"`
+	Type  string `json:"type"`
+}
+
+// Record is a resource record. RData holds the presentation format while
+// Data holds per-type fields (e.g. "preference" and "exchange" for MX) for
+// types the parser understands.
+type Record struct {
+	Name  string                 `json:"name"`
+	TTL   uint32                 `json:"ttl"`
+	Class string                 `json:"class"`
+	Type  string                 `json:"type"`
+	RData string                 `json:"rdata"`
+	Data  map[string]interface{} `json:"data,omitempty"`
+}
+
+// Server describes the server that answered the query.
+type Server struct {
+	Address  string `json:"address"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`
+}
+
+// Timing holds size and timing information about the exchange.
+type Timing struct {
+	ReceivedBytes int     `json:"received_bytes"`
+	Time          string  `json:"time,omitempty"`
+	RTTMillis     float64 `json:"rtt_ms"`
+}
+
+// ParseKdigOutput parses kdig's default text output (as also rendered by the
+// native backend) into a Message. Unknown lines are ignored so that additions
+// in newer kdig versions do not break parsing.
+func ParseKdigOutput(out []byte) (*Message, error) {
+	p := &kdigParser{
+		msg: &Message{
+			Header:     Header{Opcode: "", Rcode: "", ID: 0, Flags: []string{}},
+			EDNS:       nil,
+			Question:   []Question{},
+			Answer:     []Record{},
+			Authority:  []Record{},
+			Additional: []Record{},
+			Server:     nil,
+			Timing:     nil,
+			TLS:        "",
+		},
+		section:    sectionNone,
+		seenHeader: false,
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if comment, isComment := strings.CutPrefix(line, ";;"); isComment {
+			p.comment(strings.TrimSpace(comment))
+			continue
+		}
+		if err := p.record(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read kdig output: %w", err)
+	}
+	if !p.seenHeader {
+		return nil, ErrNoHeader
+	}
+	return p.msg, nil
+}
+
+// kdigParser holds the state while walking kdig output line by line.
+type kdigParser struct {
+	msg        *Message
+	section    string
+	seenHeader bool
+}
+
+// record handles a resource record line of the current section.
+func (p *kdigParser) record(line string) error {
+	if !p.seenHeader {
+		return ErrNoHeader
+	}
+	rec, err := parseRecord(line)
+	if err != nil {
+		return err
+	}
+	switch p.section {
+	case sectionAnswer:
+		p.msg.Answer = append(p.msg.Answer, rec)
+	case sectionAuthority:
+		p.msg.Authority = append(p.msg.Authority, rec)
+	case sectionAdditional:
+		p.msg.Additional = append(p.msg.Additional, rec)
+	default:
+		return fmt.Errorf("record outside of a section: %q", line)
+	}
+	return nil
+}
+
+// comment handles a ";;" line with the prefix removed.
+func (p *kdigParser) comment(line string) {
+	if name, ok := strings.CutSuffix(line, ":"); ok && strings.HasSuffix(name, "SECTION") {
+		p.section = name
+		if p.section == sectionEDNS {
+			p.msg.EDNS = &EDNS{Version: 0, Flags: []string{}, UDPSize: 0, ExtRcode: "", Options: nil}
+		}
+		return
+	}
+	msg := p.msg
+	switch {
+	case strings.HasPrefix(line, "->>HEADER<<-"):
+		parseHeaderLine(&msg.Header, line)
+		p.seenHeader = true
+	case strings.HasPrefix(line, "Flags:"):
+		parseFlagsLine(&msg.Header, line)
+	case strings.HasPrefix(line, "TLS session"):
+		msg.TLS = strings.TrimSpace(strings.TrimPrefix(line, "TLS session"))
+	case strings.HasPrefix(line, "Received "):
+		msg.Timing = ensureTiming(msg.Timing)
+		msg.Timing.ReceivedBytes = atoi(strings.TrimSuffix(strings.TrimPrefix(line, "Received "), " B"))
+	case strings.HasPrefix(line, "Time "):
+		msg.Timing = ensureTiming(msg.Timing)
+		msg.Timing.Time = strings.TrimPrefix(line, "Time ")
+	case strings.HasPrefix(line, "From "):
+		msg.Timing = ensureTiming(msg.Timing)
+		msg.Server = parseFromLine(line, msg.Timing)
+	case p.section == sectionEDNS && msg.EDNS != nil:
+		parseEDNSLine(msg.EDNS, line)
+	case p.section == sectionQuestion:
+		if q, ok := parseQuestion(line); ok {
+			msg.Question = append(msg.Question, q)
+		}
+	default:
+		// unknown informational line, e.g. warnings
+	}
+}
+
+// parseHeaderLine parses ";; ->>HEADER<<- opcode: QUERY; status: NOERROR; id: 1".
+func parseHeaderLine(hdr *Header, line string) {
+	for key, value := range keyValues(strings.TrimPrefix(line, "->>HEADER<<-")) {
+		switch key {
+		case "opcode":
+			hdr.Opcode = value
+		case "status":
+			hdr.Rcode = value
+		case "id":
+			hdr.ID = atoi(value)
+		default:
+		}
+	}
+}
+
+// parseFlagsLine parses ";; Flags: qr rd ra; QUERY: 1; ANSWER: 1; ...".
+func parseFlagsLine(hdr *Header, line string) {
+	for key, value := range keyValues(line) {
+		switch key {
+		case "Flags":
+			hdr.Flags = strings.Fields(value)
+		case "QUERY":
+			hdr.QueryCount = atoi(value)
+		case "ANSWER":
+			hdr.AnswerCount = atoi(value)
+		case "AUTHORITY":
+			hdr.AuthorityCount = atoi(value)
+		case "ADDITIONAL":
+			hdr.AdditionalCount = atoi(value)
+		default:
+		}
+	}
+}
+
+// parseEDNSLine parses the version line of the EDNS pseudosection or, for any
+// other line, records it as an option ("NSID: ...", "PADDING: 12 B").
+func parseEDNSLine(edns *EDNS, line string) {
+	if !strings.HasPrefix(line, "Version:") {
+		name, value, _ := strings.Cut(line, ":")
+		edns.Options = append(edns.Options, EDNSOption{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+		return
+	}
+	for key, value := range keyValues(line) {
+		switch key {
+		case "Version":
+			edns.Version = atoi(value)
+		case "flags":
+			edns.Flags = strings.Fields(value)
+		case "UDP size":
+			edns.UDPSize = atoi(strings.TrimSuffix(value, " B"))
+		case "ext-rcode":
+			edns.ExtRcode = value
+		default:
+		}
+	}
+}
+
+// parseFromLine parses "From 9.9.9.9@853(TLS) in 67.2 ms".
+func parseFromLine(line string, timing *Timing) *Server {
+	rest := strings.TrimPrefix(line, "From ")
+	target, rtt, _ := strings.Cut(rest, " in ")
+	if ms, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(rtt), " ms"), 64); err == nil {
+		timing.RTTMillis = ms
+	}
+	server := &Server{Address: target, Port: 0, Protocol: ""}
+	if open := strings.LastIndex(target, "("); open >= 0 && strings.HasSuffix(target, ")") {
+		server.Protocol = target[open+1 : len(target)-1]
+		server.Address = target[:open]
+	}
+	if at := strings.LastIndex(server.Address, "@"); at >= 0 {
+		if port, err := strconv.Atoi(server.Address[at+1:]); err == nil {
+			server.Port = port
+			server.Address = server.Address[:at]
+		}
+	}
+	return server
+}
+
+// parseQuestion parses "example.com.   IN  AAAA".
+func parseQuestion(line string) (Question, bool) {
+	const questionFields = 3 // name, class, type
+	fields := strings.Fields(line)
+	if len(fields) < questionFields {
+		return Question{}, false
+	}
+	return Question{Name: fields[0], Class: fields[1], Type: fields[2]}, true
+}
+
+// parseRecord parses a presentation-format record line
+// "name ttl class type rdata...".
+func parseRecord(line string) (Record, error) {
+	const headerFields = 4
+	fields, rdata := splitFields(line, headerFields)
+	if len(fields) < headerFields {
+		return Record{}, fmt.Errorf("malformed record line: %q", line)
+	}
+	ttl, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return Record{}, fmt.Errorf("malformed ttl in record line %q: %w", line, err)
+	}
+	rec := Record{
+		Name:  fields[0],
+		TTL:   uint32(ttl),
+		Class: fields[2],
+		Type:  fields[3],
+		RData: rdata,
+		Data:  nil,
+	}
+	rec.Data = rdataFields(rec.Type, rdata)
+	return rec, nil
+}
+
+// splitFields returns the first n whitespace-separated fields of line and the
+// remainder with its inner spacing preserved.
+func splitFields(line string, n int) ([]string, string) {
+	fields := make([]string, 0, n)
+	rest := strings.TrimLeft(line, " \t")
+	for len(fields) < n && rest != "" {
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+		fields = append(fields, rest[:end])
+		rest = strings.TrimLeft(rest[end:], " \t")
+	}
+	return fields, rest
+}
+
+// keyValues splits "a: 1; b: 2" into a map. Keys keep inner spaces.
+func keyValues(s string) map[string]string {
+	out := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, ":")
+		if !ok {
+			continue
+		}
+		out[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return out
+}
+
+func ensureTiming(t *Timing) *Timing {
+	if t != nil {
+		return t
+	}
+	return &Timing{ReceivedBytes: 0, Time: "", RTTMillis: 0}
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,140 @@
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/testutil"
+)
+
+const kdigTLSOutput = `;; TLS session (TLS1.3)-(ECDHE-X25519)-(ECDSA-SECP256R1-SHA256)-(AES-256-GCM)
+;; ->>HEADER<<- opcode: QUERY; status: NOERROR; id: 42033
+;; Flags: qr rd ra ad; QUERY: 1; ANSWER: 2; AUTHORITY: 0; ADDITIONAL: 1
+
+;; EDNS PSEUDOSECTION:
+;; Version: 0; flags: do; UDP size: 1232 B; ext-rcode: NOERROR
+;; PADDING: 385 B
+
+;; QUESTION SECTION:
+;; example.com.        		IN	MX
+
+;; ANSWER SECTION:
+example.com.        	25	IN	MX	10 mail.example.com.
+example.com.        	25	IN	TXT	"v=spf1 -all" "second string"
+
+;; Received 96 B
+;; Time 2026-02-05 11:22:52 UTC
+;; From 9.9.9.9@853(TLS) in 67.2 ms
+`
+
+const kdigNXDomainOutput = `;; ->>HEADER<<- opcode: QUERY; status: NXDOMAIN; id: 7
+;; Flags: qr rd ra; QUERY: 1; ANSWER: 0; AUTHORITY: 1; ADDITIONAL: 0
+
+;; QUESTION SECTION:
+;; nope.example.		IN	A
+
+;; AUTHORITY SECTION:
+example.		3600	IN	SOA	ns.example. admin.example. 2024010101 7200 3600 1209600 300
+
+;; Received 90 B
+;; Time 2026-02-05 11:22:52 UTC
+;; From 1.1.1.1@53(UDP) in 1.5 ms
+`
+
+func TestParseKdigOutput(t *testing.T) {
+	msg, err := resolver.ParseKdigOutput([]byte(kdigTLSOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Header.Rcode != "NOERROR" || msg.Header.ID != 42033 || msg.Header.AnswerCount != 2 {
+		t.Fatalf("unexpected header: %+v", msg.Header)
+	}
+	if len(msg.Header.Flags) != 4 || msg.Header.Flags[3] != "ad" {
+		t.Fatalf("unexpected flags: %v", msg.Header.Flags)
+	}
+	if msg.EDNS == nil || msg.EDNS.UDPSize != 1232 || len(msg.EDNS.Flags) != 1 || len(msg.EDNS.Options) != 1 {
+		t.Fatalf("unexpected edns: %+v", msg.EDNS)
+	}
+	if len(msg.Question) != 1 || msg.Question[0].Type != "MX" {
+		t.Fatalf("unexpected question: %+v", msg.Question)
+	}
+	if len(msg.Answer) != 2 {
+		t.Fatalf("expected 2 answers, got %d", len(msg.Answer))
+	}
+	mx := msg.Answer[0]
+	if mx.TTL != 25 || mx.Data["preference"] != uint64(10) || mx.Data["exchange"] != "mail.example.com." {
+		t.Fatalf("unexpected MX record: %+v", mx)
+	}
+	txt, ok := msg.Answer[1].Data["strings"].([]string)
+	if !ok || len(txt) != 2 || txt[0] != "v=spf1 -all" {
+		t.Fatalf("unexpected TXT strings: %+v", msg.Answer[1].Data)
+	}
+	if msg.Server == nil || msg.Server.Address != "9.9.9.9" || msg.Server.Port != 853 || msg.Server.Protocol != "TLS" {
+		t.Fatalf("unexpected server: %+v", msg.Server)
+	}
+	if msg.Timing == nil || msg.Timing.RTTMillis != 67.2 || msg.Timing.ReceivedBytes != 96 {
+		t.Fatalf("unexpected timing: %+v", msg.Timing)
+	}
+	if msg.TLS == "" {
+		t.Fatalf("expected TLS session description")
+	}
+}
+
+func TestParseKdigOutput_Authority(t *testing.T) {
+	msg, err := resolver.ParseKdigOutput([]byte(kdigNXDomainOutput))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Header.Rcode != "NXDOMAIN" || len(msg.Answer) != 0 || len(msg.Authority) != 1 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if msg.Authority[0].Data["minimum"] != uint64(300) {
+		t.Fatalf("unexpected SOA fields: %+v", msg.Authority[0].Data)
+	}
+}
+
+func TestParseKdigOutput_NoHeader(t *testing.T) {
+	_, err := resolver.ParseKdigOutput([]byte("93.184.216.34\n"))
+	if !errors.Is(err, resolver.ErrNoHeader) {
+		t.Fatalf("expected ErrNoHeader, got %v", err)
+	}
+}
+
+func TestParseNativeOutput(t *testing.T) {
+	addr, stop := testutil.StartLocalDNSServer(t)
+	defer stop()
+
+	client := resolver.NewNativeClient(2*time.Second, 4096)
+	req := api.RequestPayload{
+		Nameserver:       addr,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       true,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+	out, _, err := client.Run(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := resolver.ParseKdigOutput(out)
+	if err != nil {
+		t.Fatalf("parse native output: %v\n%s", err, out)
+	}
+	if len(msg.Answer) != 1 || msg.Answer[0].Data["address"] != "93.184.216.34" {
+		t.Fatalf("unexpected answer: %+v", msg.Answer)
+	}
+	if msg.Server == nil || msg.Server.Protocol != "UDP" {
+		t.Fatalf("unexpected server: %+v", msg.Server)
+	}
+}
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"context"
+	"maps"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/exiguus/wdns/internal/api"
+)
+
+type pinKey struct{}
+
+// WithAddress returns a context in which queries to nameserver are sent to
+// addr instead of resolving the nameserver's host again. It carries the
+// address a nameserver policy checked down to the backends, so that a DNS
+// record changing between the check and the query cannot redirect it. The
+// host remains the name verified in TLS certificates and sent to DoH servers.
+func WithAddress(ctx context.Context, nameserver string, addr netip.Addr) context.Context {
+	pins := maps.Clone(pinnedAddresses(ctx))
+	if pins == nil {
+		pins = make(map[string]netip.Addr, 1)
+	}
+	pins[nameserver] = addr
+	return context.WithValue(ctx, pinKey{}, pins)
+}
+
+func pinnedAddresses(ctx context.Context) map[string]netip.Addr {
+	pins, _ := ctx.Value(pinKey{}).(map[string]netip.Addr)
+	return pins
+}
+
+// pinNameserver returns nameserver with its host replaced by the address
+// attached with WithAddress, and the host name it replaced. Without an
+// address it returns nameserver unchanged and "".
+func pinNameserver(ctx context.Context, nameserver string) (string, string) {
+	addr, ok := pinnedAddresses(ctx)[nameserver]
+	if !ok {
+		return nameserver, ""
+	}
+	ns := strings.TrimSpace(nameserver)
+	if strings.HasPrefix(ns, "https://") {
+		u, err := url.Parse(ns)
+		if err != nil {
+			return nameserver, ""
+		}
+		host, port := u.Hostname(), u.Port()
+		u.Host = "[" + addr.String() + "]"
+		if addr.Is4() {
+			u.Host = addr.String()
+		}
+		if port != "" {
+			u.Host = net.JoinHostPort(addr.String(), port)
+		}
+		return u.String(), host
+	}
+	if host, port, ok := strings.Cut(ns, "#"); ok {
+		return addr.String() + "#" + port, strings.Trim(host, "[]")
+	}
+	if host, port, err := net.SplitHostPort(ns); err == nil {
+		return net.JoinHostPort(addr.String(), port), host
+	}
+	return addr.String(), strings.Trim(ns, "[]")
+}
+
+// pinRequest applies pinNameserver to req.
+func pinRequest(ctx context.Context, req api.RequestPayload) (api.RequestPayload, string) {
+	var hostname string
+	req.Nameserver, hostname = pinNameserver(ctx, req.Nameserver)
+	return req, hostname
+}
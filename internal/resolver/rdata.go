@@ -0,0 +1,150 @@
+package resolver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// rdataFields splits presentation-format RDATA into named per-type fields. It
+// returns nil for types without a known layout or for malformed RDATA, in
+// which case callers still have the raw RDATA string.
+func rdataFields(rrType, rdata string) map[string]interface{} {
+	tokens := tokenizeRData(rdata)
+	switch strings.ToUpper(rrType) {
+	case "A", "AAAA":
+		return namedFields(tokens, "address")
+	case "NS", "CNAME", "PTR", "DNAME":
+		return namedFields(tokens, "target")
+	case "MX":
+		return namedFields(tokens, "preference#", "exchange")
+	case "TXT", "SPF":
+		return map[string]interface{}{"strings": tokens}
+	case "SOA":
+		return namedFields(tokens, "mname", "rname", "serial#", "refresh#", "retry#", "expire#", "minimum#")
+	case "SRV":
+		return namedFields(tokens, "priority#", "weight#", "port#", "target")
+	case "CAA":
+		return namedFields(tokens, "flags#", "tag", "value")
+	case "DS", "CDS":
+		return namedFieldsJoined(tokens, "key_tag#", "algorithm#", "digest_type#", "digest")
+	case "DNSKEY", "CDNSKEY":
+		return namedFieldsJoined(tokens, "flags#", "protocol#", "algorithm#", "public_key")
+	case "RRSIG":
+		return namedFieldsJoined(tokens,
+			"type_covered", "algorithm#", "labels#", "original_ttl#", "expiration",
+			"inception", "key_tag#", "signer_name", "signature")
+	case "NSEC":
+		return nsecFields(tokens)
+	case "TLSA", "SMIMEA":
+		return namedFieldsJoined(tokens, "usage#", "selector#", "matching_type#", "data")
+	case "SSHFP":
+		return namedFieldsJoined(tokens, "algorithm#", "fingerprint_type#", "fingerprint")
+	case "NAPTR":
+		return namedFields(tokens, "order#", "preference#", "flags", "services", "regexp", "replacement")
+	case "HTTPS", "SVCB":
+		return svcbFields(tokens)
+	default:
+		return nil
+	}
+}
+
+// namedFields maps tokens to names one-to-one. Names ending in '#' are parsed
+// as unsigned integers. A token count mismatch yields nil.
+func namedFields(tokens []string, names ...string) map[string]interface{} {
+	if len(tokens) != len(names) {
+		return nil
+	}
+	out := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		key, numeric := strings.CutSuffix(name, "#")
+		if !numeric {
+			out[key] = tokens[i]
+			continue
+		}
+		n, err := strconv.ParseUint(tokens[i], 10, 32)
+		if err != nil {
+			return nil
+		}
+		out[key] = n
+	}
+	return out
+}
+
+// namedFieldsJoined is like namedFields but joins any surplus tokens into the
+// last field, for base64/hex blobs that kdig may split on whitespace.
+func namedFieldsJoined(tokens []string, names ...string) map[string]interface{} {
+	if len(tokens) < len(names) {
+		return nil
+	}
+	last := len(names) - 1
+	joined := append(append([]string{}, tokens[:last]...), strings.Join(tokens[last:], ""))
+	return namedFields(joined, names...)
+}
+
+// nsecFields parses "next.example. A NS RRSIG NSEC".
+func nsecFields(tokens []string) map[string]interface{} {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"next_domain": tokens[0],
+		"types":       append([]string{}, tokens[1:]...),
+	}
+}
+
+// svcbFields parses `1 . alpn="h2,h3" ipv4hint=1.2.3.4`.
+func svcbFields(tokens []string) map[string]interface{} {
+	const fixed = 2
+	if len(tokens) < fixed {
+		return nil
+	}
+	priority, err := strconv.ParseUint(tokens[0], 10, 16)
+	if err != nil {
+		return nil
+	}
+	params := make(map[string]string, len(tokens)-fixed)
+	for _, tok := range tokens[fixed:] {
+		key, value, _ := strings.Cut(tok, "=")
+		params[key] = value
+	}
+	return map[string]interface{}{
+		"priority": priority,
+		"target":   tokens[1],
+		"params":   params,
+	}
+}
+
+// tokenizeRData splits RDATA on whitespace while keeping quoted
+// character-strings together and removing their quotes. Backslash escapes
+// inside quotes are preserved verbatim.
+func tokenizeRData(rdata string) []string {
+	tokens := []string{}
+	var cur strings.Builder
+	inQuotes, escaped, hasToken := false, false, false
+	for _, r := range rdata {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			cur.WriteRune(r)
+			escaped = true
+		case r == '"':
+			inQuotes = !inQuotes
+			hasToken = true
+		case !inQuotes && (r == ' ' || r == '\t'):
+			if hasToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				hasToken = false
+			}
+		default:
+			cur.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens
+}
@@ -0,0 +1,95 @@
+// Package resolver implements the DNS query backends used by the HTTP handlers.
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/telemetry"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+// Backend names accepted by New.
+const (
+	BackendKdig   = "kdig"
+	BackendNative = "native"
+)
+
+// Resolver executes DNS queries described by an api.RequestPayload.
+//
+// Implementations return the query output, a kdig-equivalent command string
+// that reproduces the query and any error encountered.
+type Resolver interface {
+	// Run executes the query described by req.
+	Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error)
+	// QueryTimeout reports the per-query timeout applied by the backend.
+	QueryTimeout() time.Duration
+}
+
+// Observer receives the outcome of backend executions, e.g. to export
+// metrics. *metrics.Metrics implements it.
+type Observer interface {
+	// ObserveExecution records a kdig execution against upstream. exitCode
+	// is -1 when kdig did not exit normally; timedOut marks executions
+	// killed by their deadline.
+	ObserveExecution(upstream string, elapsed time.Duration, exitCode int, timedOut bool)
+	// ObserveTruncated records an output cut to MaxOutput.
+	ObserveTruncated()
+}
+
+// Exchanger sends a wire-format DNS message to a nameserver and returns the
+// raw response. NativeClient implements it.
+type Exchanger interface {
+	Exchange(ctx context.Context, msg *dns.Msg, nameserver, transport string) (*dns.Msg, error)
+}
+
+// New returns the Resolver implementation registered under backend. An empty
+// backend selects the kdig runner. Nameservers naming a profile in upstreams
+// (which may be nil) are expanded by the backend.
+//
+//nolint:ireturn // the backend is selected at runtime from configuration
+func New(backend string, timeout time.Duration, maxOutput int, upstreams *upstream.Catalog) (Resolver, error) {
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case "", BackendKdig:
+		runner := NewRunner(timeout, maxOutput)
+		runner.Upstreams = upstreams
+		return runner, nil
+	case BackendNative:
+		client := NewNativeClient(timeout, maxOutput)
+		client.Upstreams = upstreams
+		return client, nil
+	default:
+		return nil, fmt.Errorf("unknown resolver backend %q", backend)
+	}
+}
+
+// startSpan starts a span for a backend execution of req.
+//
+//nolint:ireturn // the OpenTelemetry API hands out spans as interfaces
+func startSpan(ctx context.Context, name string, req api.RequestPayload) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(ctx, name, trace.WithAttributes(
+		attribute.String("dns.nameserver", req.Nameserver),
+		attribute.String("dns.question.name", req.Name),
+		attribute.String("dns.question.type", req.Type),
+		attribute.String("dns.transport", req.Transport),
+	))
+}
+
+// lookupUpstream returns the profile req.Nameserver names, if any, together
+// with req adjusted to the profile's transport and DNSSEC default.
+func lookupUpstream(upstreams *upstream.Catalog, req api.RequestPayload) (api.RequestPayload, *upstream.Profile) {
+	profile, ok := upstreams.Lookup(req.Nameserver)
+	if !ok {
+		return req, nil
+	}
+	req.Transport = profile.Transport
+	req.DNSSEC = req.DNSSEC || profile.DNSSEC
+	return req, &profile
+}
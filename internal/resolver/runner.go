@@ -5,41 +5,90 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/rrtype"
+	"github.com/exiguus/wdns/internal/telemetry"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+// Oldest supported kdig release: +json and +https need Knot DNS 3.1.
+const (
+	minKdigMajor = 3
+	minKdigMinor = 1
 )
 
+// ErrUnsupportedKdig is returned by Ready and CheckKdigVersion for a kdig
+// release older than 3.1 or an unrecognised version output.
+var ErrUnsupportedKdig = errors.New("unsupported kdig version")
+
 // Runner executes DNS queries by invoking the external `kdig` binary.
 // It returns the raw stdout from kdig and a human-friendly command string
 // useful for debugging and reproducing queries.
 type Runner struct {
 	Timeout   time.Duration
 	MaxOutput int
-	logger    *slog.Logger
+	// Upstreams resolves nameservers that name an upstream profile. Nil
+	// treats every nameserver literally.
+	Upstreams *upstream.Catalog
+	// Observer, when set, receives every execution and truncation.
+	Observer Observer
+	logger   *slog.Logger
 }
 
 // NewRunner creates a new Runner.
 func NewRunner(timeout time.Duration, maxOutput int) *Runner {
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
-	return &Runner{Timeout: timeout, MaxOutput: maxOutput, logger: logger}
+	return &Runner{Timeout: timeout, MaxOutput: maxOutput, Upstreams: nil, Observer: nil, logger: logger}
+}
+
+// QueryTimeout reports the timeout applied to each kdig execution.
+func (r *Runner) QueryTimeout() time.Duration {
+	return r.Timeout
+}
+
+// Ready reports whether kdig can be executed and is a supported release,
+// returning its version.
+func (r *Runner) Ready(ctx context.Context) (string, error) {
+	out, err := exec.CommandContext(ctx, "kdig", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("kdig not available: %w", err)
+	}
+	return CheckKdigVersion(string(out))
 }
 
 // Run builds and executes a corresponding kdig command for the request.
 // It returns the command's stdout, the human command string, and any error.
 func (r *Runner) Run(ctx context.Context, req api.RequestPayload) ([]byte, string, error) {
-	cmdStr := buildKdigCommand(req)
+	ctx, span := startSpan(ctx, "Runner.Run", req)
+	defer span.End()
+	req, profile := lookupUpstream(r.Upstreams, req)
+	pinned, hostname := pinRequest(ctx, req)
+	cmdStr := buildKdigCommand(pinned, profile, hostname)
 
-	args := buildKdigArgs(req)
+	args := buildKdigArgs(pinned, profile, hostname)
 	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "kdig", args...)
+	_, kdigSpan := telemetry.Tracer().Start(ctx, "kdig", trace.WithAttributes(attribute.String("kdig.command", cmdStr)))
+	start := time.Now()
 	out, err := cmd.Output()
+	r.observe(ctx, req, profile, time.Since(start), err)
+	endKdigSpan(kdigSpan, cmd, err)
 	if err != nil {
+		span.SetStatus(codes.Error, "kdig failed")
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
 			err = fmt.Errorf("kdig failed: %w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
@@ -57,54 +106,92 @@ func (r *Runner) Run(ctx context.Context, req api.RequestPayload) ([]byte, strin
 
 	if r.MaxOutput > 0 && len(out) > r.MaxOutput {
 		out = out[:r.MaxOutput]
+		if r.Observer != nil {
+			r.Observer.ObserveTruncated()
+		}
 	}
 
 	return out, cmdStr, nil
 }
 
-// buildKdigCommand creates a human-readable kdig command string.
-func buildKdigCommand(req api.RequestPayload) string {
-	var builder strings.Builder
-	builder.WriteString("kdig")
-
-	builder.WriteString(" @")
-	builder.WriteString(req.Nameserver)
-	builder.WriteString(" ")
-	builder.WriteString(req.Name)
-	builder.WriteString(" ")
-	builder.WriteString(req.Type)
-	switch strings.ToLower(req.Transport) {
-	case "tcp":
-		builder.WriteString(" +tcp")
-	case "tls":
-		builder.WriteString(" +tls")
-	case "https":
-		builder.WriteString(" +https")
-	default:
-		// UDP/default: no extra flags
+// CheckKdigVersion extracts the version from `kdig --version` output such as
+// "kdig (Knot DNS), version 3.4.4" and checks that it is supported.
+func CheckKdigVersion(output string) (string, error) {
+	_, rest, found := strings.Cut(output, "version ")
+	fields := strings.Fields(rest)
+	if !found || len(fields) == 0 {
+		return "", fmt.Errorf("%w: no version in %q", ErrUnsupportedKdig, strings.TrimSpace(output))
 	}
-	if req.DNSSEC {
-		builder.WriteString(" +dnssec +do")
+	version := fields[0]
+	parts := strings.SplitN(version, ".", 3) //nolint:mnd // major.minor.rest
+	if len(parts) < 2 {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedKdig, version)
 	}
-	if req.AsJSON {
-		builder.WriteString(" +json")
+	major, majorErr := strconv.Atoi(parts[0])
+	minor, minorErr := strconv.Atoi(parts[1])
+	if majorErr != nil || minorErr != nil {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedKdig, version)
 	}
-	if req.Short {
-		builder.WriteString(" +short")
+	if major < minKdigMajor || (major == minKdigMajor && minor < minKdigMinor) {
+		return version, fmt.Errorf("%w: %s is older than %d.%d", ErrUnsupportedKdig, version, minKdigMajor, minKdigMinor)
+	}
+	return version, nil
+}
+
+// endKdigSpan records the exit code of cmd on span and ends it.
+func endKdigSpan(span trace.Span, cmd *exec.Cmd, err error) {
+	if cmd.ProcessState != nil {
+		span.SetAttributes(attribute.Int("kdig.exit_code", cmd.ProcessState.ExitCode()))
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// observe reports an execution to r.Observer. The upstream is the profile
+// name, or the nameserver as given.
+func (r *Runner) observe(ctx context.Context, req api.RequestPayload, profile *upstream.Profile, elapsed time.Duration, err error) {
+	if r.Observer == nil {
+		return
+	}
+	upstreamName := req.Nameserver
+	if profile != nil {
+		upstreamName = profile.Name
+	}
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
 	}
+	r.Observer.ObserveExecution(upstreamName, elapsed, exitCode, errors.Is(ctx.Err(), context.DeadlineExceeded))
+}
 
-	return builder.String()
+// buildKdigCommand creates a human-readable kdig command string.
+func buildKdigCommand(req api.RequestPayload, profile *upstream.Profile, hostname string) string {
+	return "kdig " + strings.Join(buildKdigArgs(req, profile, hostname), " ")
 }
 
 // buildKdigArgs returns an args slice suitable for exec.Command, keeping
 // flags as separate elements. The nameserver string is used verbatim
-// (no http(s)/dns-query/port conversions) per project requirement.
-func buildKdigArgs(req api.RequestPayload) []string {
+// (no http(s)/dns-query/port conversions) per project requirement, unless it
+// names an upstream profile, which is expanded into its first address, port
+// and TLS/HTTPS flags. hostname is the certificate name of a nameserver
+// pinned to an address by pinRequest. The record type is normalized through
+// the rrtype registry (e.g. "mx" becomes "MX").
+func buildKdigArgs(req api.RequestPayload, profile *upstream.Profile, hostname string) []string {
 	var args []string
-	args = append(args, "@"+req.Nameserver)
+	if profile != nil {
+		args = append(args, "@"+profile.Target(profile.Addresses[0]))
+	} else {
+		args = append(args, "@"+req.Nameserver)
+	}
 
 	args = append(args, req.Name)
-	args = append(args, req.Type)
+	args = append(args, rrtype.Canonical(req.Type))
 
 	switch strings.ToLower(req.Transport) {
 	case "tcp":
@@ -112,14 +199,25 @@ func buildKdigArgs(req api.RequestPayload) []string {
 	case "tls":
 		args = append(args, "+tls")
 	case "https":
-		args = append(args, "+https")
+		args = append(args, httpsFlag(profile))
 	default:
 		// UDP/default: no transport flags
 	}
+	if profile != nil {
+		hostname = tlsHostname(*profile)
+	} else if transport := strings.ToLower(req.Transport); transport != "tls" && transport != "https" {
+		hostname = ""
+	}
+	if hostname != "" {
+		args = append(args, "+tls-hostname="+hostname)
+	}
 
 	if req.DNSSEC {
 		args = append(args, "+dnssec", "+do")
 	}
+	if req.CheckingDisabled {
+		args = append(args, "+cdflag")
+	}
 	if req.AsJSON {
 		args = append(args, "+json")
 	}
@@ -130,12 +228,57 @@ func buildKdigArgs(req api.RequestPayload) []string {
 	return args
 }
 
+// httpsFlag returns kdig's DoH option, carrying the URL path of profile's DoH
+// endpoint when there is one.
+func httpsFlag(profile *upstream.Profile) string {
+	if profile == nil {
+		return "+https"
+	}
+	u, err := url.Parse(profile.Endpoint(profile.Addresses[0]))
+	if err != nil || u.Path == "" {
+		return "+https"
+	}
+	return "+https=" + u.Path
+}
+
+// tlsHostname returns the certificate hostname kdig should verify for a TLS
+// or HTTPS profile: the configured TLS hostname or the host of the DoH URL.
+func tlsHostname(profile upstream.Profile) string {
+	switch {
+	case profile.Transport != "tls" && profile.Transport != "https":
+		return ""
+	case profile.TLSHostname != "":
+		return profile.TLSHostname
+	case profile.Transport == "https":
+		if u, err := url.Parse(profile.Endpoint(profile.Addresses[0])); err == nil && net.ParseIP(u.Hostname()) == nil {
+			return u.Hostname()
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
 // BuildKdigArgsForTest exposes buildKdigArgs for tests in the external test package.
 func BuildKdigArgsForTest(req api.RequestPayload) []string {
-	return buildKdigArgs(req)
+	return buildKdigArgs(req, nil, "")
 }
 
 // BuildKdigCommandForTest exposes buildKdigCommand for tests in the external test package.
 func BuildKdigCommandForTest(req api.RequestPayload) string {
-	return buildKdigCommand(req)
+	return buildKdigCommand(req, nil, "")
+}
+
+// BuildUpstreamKdigArgsForTest exposes the profile expansion of buildKdigArgs
+// for tests in the external test package.
+func BuildUpstreamKdigArgsForTest(catalog *upstream.Catalog, req api.RequestPayload) []string {
+	req, profile := lookupUpstream(catalog, req)
+	return buildKdigArgs(req, profile, "")
+}
+
+// BuildPinnedKdigArgsForTest exposes buildKdigArgs for a request pinned with
+// WithAddress, for tests in the external test package.
+func BuildPinnedKdigArgsForTest(ctx context.Context, req api.RequestPayload) []string {
+	req, hostname := pinRequest(ctx, req)
+	return buildKdigArgs(req, nil, hostname)
 }
@@ -2,6 +2,8 @@ package resolver_test
 
 import (
 	"context"
+	"errors"
+	"net/netip"
 	"strings"
 	"testing"
 	"time"
@@ -9,17 +11,23 @@ import (
 	"github.com/exiguus/wdns/internal/api"
 	"github.com/exiguus/wdns/internal/resolver"
 	"github.com/exiguus/wdns/internal/testutil"
+	"github.com/exiguus/wdns/internal/upstream"
 )
 
 func TestBuildKdigArgs_IncludesJSONFlag(t *testing.T) {
 	req := api.RequestPayload{
-		Nameserver: "ns1.example",
-		Name:       "example.com",
-		Type:       "AAAA",
-		Transport:  "tls",
-		Short:      false,
-		DNSSEC:     false,
-		AsJSON:     true,
+		Nameserver:       "ns1.example",
+		Name:             "example.com",
+		Type:             "AAAA",
+		Transport:        "tls",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           true,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
 	}
 
 	args := resolver.BuildKdigArgsForTest(req)
@@ -37,13 +45,18 @@ func TestBuildKdigArgs_IncludesJSONFlag(t *testing.T) {
 
 func TestBuildKdigCommand_IncludesJSONFlag(t *testing.T) {
 	req := api.RequestPayload{
-		Nameserver: "ns1.example",
-		Name:       "example.com",
-		Type:       "AAAA",
-		Transport:  "tls",
-		Short:      false,
-		DNSSEC:     false,
-		AsJSON:     true,
+		Nameserver:       "ns1.example",
+		Name:             "example.com",
+		Type:             "AAAA",
+		Transport:        "tls",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           true,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
 	}
 
 	cmd := resolver.BuildKdigCommandForTest(req)
@@ -66,13 +79,18 @@ func TestRunnerLocalUDP(t *testing.T) {
 
 	runner := resolver.NewRunner(2*time.Second, 1024)
 	req := api.RequestPayload{
-		Nameserver: addr,
-		Name:       "example.com",
-		Type:       "A",
-		Transport:  "udp",
-		Short:      true,
-		DNSSEC:     false,
-		AsJSON:     false,
+		Nameserver:       addr,
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "udp",
+		Short:            true,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
 	}
 
 	out, cmd, err := runner.Run(context.Background(), req)
@@ -84,3 +102,124 @@ func TestRunnerLocalUDP(t *testing.T) {
 		t.Fatalf("unexpected empty result: %q (cmd: %q)", outStr, cmd)
 	}
 }
+
+func TestBuildKdigArgs_CanonicalType(t *testing.T) {
+	req := api.RequestPayload{
+		Nameserver:       "ns1.example",
+		Name:             "example.com",
+		Type:             "mx",
+		Transport:        "",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+
+	args := resolver.BuildKdigArgsForTest(req)
+	if len(args) < 3 || args[2] != "MX" {
+		t.Fatalf("expected canonical type MX in args, got: %v", args)
+	}
+}
+
+func TestBuildKdigArgs_ExpandsUpstreamProfile(t *testing.T) {
+	req := api.RequestPayload{
+		Nameserver:       "quad9-dot",
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "",
+		Short:            true,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+	got := strings.Join(resolver.BuildUpstreamKdigArgsForTest(upstream.Defaults(), req), " ")
+	if want := "@9.9.9.9#853 example.com A +tls +tls-hostname=dns.quad9.net +short"; got != want {
+		t.Fatalf("unexpected args:\n got %s\nwant %s", got, want)
+	}
+
+	catalog, err := upstream.NewCatalog([]upstream.Profile{{
+		Name:        "internal-doh",
+		Description: "",
+		Addresses:   []string{"192.0.2.53"},
+		Port:        8443,
+		Transport:   "https",
+		TLSHostname: "",
+		DoHURL:      "https://doh.example:{port}/custom",
+		DNSSEC:      true,
+	}})
+	if err != nil {
+		t.Fatalf("catalog: %v", err)
+	}
+	req.Nameserver, req.Short = "internal-doh", false
+	got = strings.Join(resolver.BuildUpstreamKdigArgsForTest(catalog, req), " ")
+	if want := "@192.0.2.53#8443 example.com A +https=/custom +tls-hostname=doh.example +dnssec +do"; got != want {
+		t.Fatalf("unexpected args:\n got %s\nwant %s", got, want)
+	}
+
+	req.Nameserver = "192.0.2.1"
+	if got = strings.Join(resolver.BuildUpstreamKdigArgsForTest(catalog, req), " "); got != "@192.0.2.1 example.com A" {
+		t.Fatalf("literal nameservers must not be expanded, got %s", got)
+	}
+}
+
+func TestBuildKdigArgs_PinnedAddress(t *testing.T) {
+	req := api.RequestPayload{
+		Nameserver:       "dns.example#853",
+		Name:             "example.com",
+		Type:             "A",
+		Transport:        "tls",
+		Short:            false,
+		DNSSEC:           false,
+		AsJSON:           false,
+		Structured:       false,
+		ValidateDNSSEC:   false,
+		Trace:            false,
+		CheckingDisabled: false,
+		NoCache:          false,
+	}
+	cases := []struct {
+		nameserver, transport, want string
+	}{
+		{"dns.example#853", "tls", "@192.0.2.53#853 example.com A +tls +tls-hostname=dns.example"},
+		{"https://dns.example/dns-query", "https", "@https://192.0.2.53/dns-query example.com A +https +tls-hostname=dns.example"},
+		{"dns.example:5353", "udp", "@192.0.2.53:5353 example.com A"},
+		{"dns.example", "", "@192.0.2.53 example.com A"},
+	}
+	for _, tc := range cases {
+		req.Nameserver, req.Transport = tc.nameserver, tc.transport
+		ctx := resolver.WithAddress(context.Background(), tc.nameserver, netip.MustParseAddr("192.0.2.53"))
+		if got := strings.Join(resolver.BuildPinnedKdigArgsForTest(ctx, req), " "); got != tc.want {
+			t.Errorf("unexpected args:\n got %s\nwant %s", got, tc.want)
+		}
+	}
+}
+
+func TestCheckKdigVersion(t *testing.T) {
+	for output, want := range map[string]string{
+		"kdig (Knot DNS), version 3.4.4\n": "3.4.4",
+		"kdig (Knot DNS), version 3.1.0":   "3.1.0",
+		"kdig (Knot DNS), version 4.0":     "4.0",
+	} {
+		if got, err := resolver.CheckKdigVersion(output); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", output, got, err, want)
+		}
+	}
+	for _, output := range []string{
+		"kdig (Knot DNS), version 2.9.9",
+		"kdig (Knot DNS), version 3.0.12",
+		"kdig (Knot DNS), version dev",
+		"command not found",
+	} {
+		if _, err := resolver.CheckKdigVersion(output); !errors.Is(err, resolver.ErrUnsupportedKdig) {
+			t.Errorf("%q: got %v, want ErrUnsupportedKdig", output, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Package rrtype is the registry of DNS resource record types known to wdns.
+//
+// It maps type mnemonics to their numeric codes (and back), understands the
+// RFC 3597 generic `TYPE<n>` syntax and carries per-type notes surfaced to API
+// callers, e.g. that a type requires DNSSEC or is only valid for zone transfers.
+package rrtype
+
+import (
+	"strconv"
+	"strings"
+)
+
+const genericPrefix = "TYPE"
+
+// Type describes a DNS resource record type.
+type Type struct {
+	// Name is the canonical mnemonic, or TYPE<n> for unregistered codes.
+	Name string
+	// Code is the numeric RR type code.
+	Code uint16
+	// Note is an optional hint shown to callers querying this type.
+	Note string
+	// Meta marks pseudo types (e.g. OPT) that cannot be queried.
+	Meta bool
+	// ZoneTransfer marks AXFR/IXFR, which need a stream transport.
+	ZoneTransfer bool
+}
+
+// String returns the canonical name of the type.
+func (t Type) String() string {
+	return t.Name
+}
+
+// Lookup resolves a type mnemonic (case-insensitive) or RFC 3597 generic
+// TYPE<n> string. Generic names of registered codes resolve to the registered
+// type, e.g. "TYPE1" returns A.
+func Lookup(name string) (Type, bool) {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	if upper == "" {
+		return Type{}, false
+	}
+	for _, t := range registry() {
+		if t.Name == upper {
+			return t, true
+		}
+	}
+	if digits, ok := strings.CutPrefix(upper, genericPrefix); ok {
+		code, err := strconv.ParseUint(digits, 10, 16)
+		if err != nil {
+			return Type{}, false
+		}
+		return ByCode(uint16(code)), true
+	}
+	return Type{}, false
+}
+
+// ByCode returns the type registered under code, or a generic TYPE<n> type
+// when the code is not registered.
+func ByCode(code uint16) Type {
+	for _, t := range registry() {
+		if t.Code == code {
+			return t
+		}
+	}
+	return Type{
+		Name:         genericPrefix + strconv.FormatUint(uint64(code), 10),
+		Code:         code,
+		Note:         "",
+		Meta:         false,
+		ZoneTransfer: false,
+	}
+}
+
+// Canonical returns the canonical name for name, or name unchanged when it is
+// not a known type.
+func Canonical(name string) string {
+	if t, ok := Lookup(name); ok {
+		return t.Name
+	}
+	return name
+}
+
+// All returns every registered type ordered by code.
+func All() []Type {
+	return registry()
+}
+
+// registry returns the table of registered types. It is rebuilt per call to
+// avoid package-level state; the table is small enough for linear scans.
+func registry() []Type {
+	const (
+		dnssecNote   = "DNSSEC record; most servers only return it with \"dnssec\": true"
+		transferNote = "zone-transfer only; requires a tcp or tls transport and a server that permits transfers"
+		metaNote     = "pseudo-record; cannot be queried"
+	)
+	plain := func(name string, code uint16) Type {
+		return Type{Name: name, Code: code, Note: "", Meta: false, ZoneTransfer: false}
+	}
+	noted := func(name string, code uint16, note string) Type {
+		return Type{Name: name, Code: code, Note: note, Meta: false, ZoneTransfer: false}
+	}
+	meta := func(name string, code uint16) Type {
+		return Type{Name: name, Code: code, Note: metaNote, Meta: true, ZoneTransfer: false}
+	}
+	transfer := func(name string, code uint16) Type {
+		return Type{Name: name, Code: code, Note: transferNote, Meta: false, ZoneTransfer: true}
+	}
+	return []Type{
+		plain("A", 1),
+		plain("NS", 2),
+		plain("CNAME", 5),
+		plain("SOA", 6),
+		plain("PTR", 12),
+		plain("HINFO", 13),
+		plain("MX", 15),
+		plain("TXT", 16),
+		plain("RP", 17),
+		plain("AFSDB", 18),
+		noted("SIG", 24, "obsolete (RFC 3755); use RRSIG"),
+		noted("KEY", 25, "obsolete for DNSSEC (RFC 3755); use DNSKEY"),
+		plain("AAAA", 28),
+		plain("LOC", 29),
+		plain("SRV", 33),
+		plain("NAPTR", 35),
+		plain("KX", 36),
+		plain("CERT", 37),
+		plain("DNAME", 39),
+		meta("OPT", 41),
+		plain("APL", 42),
+		noted("DS", 43, dnssecNote),
+		plain("SSHFP", 44),
+		plain("IPSECKEY", 45),
+		noted("RRSIG", 46, dnssecNote),
+		noted("NSEC", 47, dnssecNote),
+		noted("DNSKEY", 48, dnssecNote),
+		plain("DHCID", 49),
+		noted("NSEC3", 50, dnssecNote),
+		noted("NSEC3PARAM", 51, dnssecNote),
+		plain("TLSA", 52),
+		plain("SMIMEA", 53),
+		plain("HIP", 55),
+		noted("CDS", 59, dnssecNote),
+		noted("CDNSKEY", 60, dnssecNote),
+		plain("OPENPGPKEY", 61),
+		plain("CSYNC", 62),
+		plain("ZONEMD", 63),
+		plain("SVCB", 64),
+		plain("HTTPS", 65),
+		noted("SPF", 99, "obsolete (RFC 7208); publish SPF policies as TXT"),
+		plain("EUI48", 108),
+		plain("EUI64", 109),
+		meta("TKEY", 249),
+		meta("TSIG", 250),
+		transfer("IXFR", 251),
+		transfer("AXFR", 252),
+		noted("ANY", 255, "servers may return a minimal or empty answer (RFC 8482)"),
+		plain("URI", 256),
+		plain("CAA", 257),
+		noted("DLV", 32769, "obsolete (RFC 8749)"),
+	}
+}
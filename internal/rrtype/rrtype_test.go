@@ -0,0 +1,68 @@
+package rrtype_test
+
+import (
+	"testing"
+
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		ok   bool
+		name string
+		code uint16
+	}{
+		{"A", true, "A", 1},
+		{"mx", true, "MX", 15},
+		{"HTTPS", true, "HTTPS", 65},
+		{"TYPE1", true, "A", 1},
+		{"type65534", true, "TYPE65534", 65534},
+		{"TYPE65536", false, "", 0},
+		{"TYPE", false, "", 0},
+		{"BOGUS", false, "", 0},
+		{"", false, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			t.Parallel()
+			got, ok := rrtype.Lookup(tt.in)
+			if ok != tt.ok {
+				t.Fatalf("expected ok=%v, got %v", tt.ok, ok)
+			}
+			if ok && (got.Name != tt.name || got.Code != tt.code) {
+				t.Fatalf("expected %s/%d, got %s/%d", tt.name, tt.code, got.Name, got.Code)
+			}
+		})
+	}
+}
+
+func TestByCodeRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, typ := range rrtype.All() {
+		if got := rrtype.ByCode(typ.Code); got.Name != typ.Name {
+			t.Fatalf("code %d: expected %s, got %s", typ.Code, typ.Name, got.Name)
+		}
+		if got, ok := rrtype.Lookup(typ.Name); !ok || got.Code != typ.Code {
+			t.Fatalf("name %s: expected code %d, got %d (ok=%v)", typ.Name, typ.Code, got.Code, ok)
+		}
+	}
+}
+
+func TestNotes(t *testing.T) {
+	t.Parallel()
+	axfr, _ := rrtype.Lookup("AXFR")
+	if !axfr.ZoneTransfer || axfr.Note == "" {
+		t.Fatalf("expected AXFR to be a noted zone-transfer type, got %+v", axfr)
+	}
+	ds, _ := rrtype.Lookup("DS")
+	if ds.Note == "" {
+		t.Fatalf("expected DS to carry a DNSSEC note")
+	}
+	opt, _ := rrtype.Lookup("OPT")
+	if !opt.Meta {
+		t.Fatalf("expected OPT to be a meta type")
+	}
+}
@@ -0,0 +1,164 @@
+// Package telemetry configures OpenTelemetry tracing: the span exporter, the
+// sampler and W3C trace context propagation. Instrumented packages obtain
+// their tracer with Tracer; without Setup the global no-op provider makes
+// spans free.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted in Config.Exporter.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const (
+	instrumentationName = "github.com/exiguus/wdns"
+	serviceName         = "wdns"
+)
+
+// ErrInvalidExporter is returned by Setup for an unsupported or incomplete
+// exporter configuration.
+var ErrInvalidExporter = errors.New("invalid trace exporter")
+
+// Config selects and configures the span exporter.
+type Config struct {
+	// Exporter is "none" (or empty), "otlp", "stdout" or "file".
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector URL, e.g.
+	// "http://otel-collector:4318/v1/traces". Empty uses the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	Endpoint string
+	// File receives JSON encoded spans for the "file" exporter.
+	File string
+	// SampleRatio is the fraction of new traces recorded; traces started by
+	// a sampled caller are always recorded. Values outside (0, 1] mean 1.
+	SampleRatio float64
+}
+
+// Shutdown flushes pending spans and releases the exporter.
+type Shutdown func(ctx context.Context) error
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. With the "none" exporter only the propagator is installed so
+// that incoming trace IDs are still reported.
+func Setup(ctx context.Context, cfg Config) (Shutdown, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, closer, err := newExporter(ctx, cfg)
+	if err != nil || exporter == nil {
+		return func(context.Context) error { return nil }, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("trace resource: %w", err)
+	}
+	ratio := cfg.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// newExporter returns the exporter named by cfg, or nil for "none", together
+// with a file to close on shutdown.
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Exporter)) {
+	case "", ExporterNone:
+		return nil, nil, nil
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("otlp trace exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("stdout trace exporter: %w", err)
+		}
+		return exporter, nil, nil
+	case ExporterFile:
+		if cfg.File == "" {
+			return nil, nil, fmt.Errorf("%w: %q requires a file", ErrInvalidExporter, cfg.Exporter)
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600) //nolint:gosec // path comes from operator configuration
+		if err != nil {
+			return nil, nil, fmt.Errorf("open trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, nil, fmt.Errorf("file trace exporter: %w", err)
+		}
+		return exporter, f, nil
+	default:
+		return nil, nil, fmt.Errorf("%w %q", ErrInvalidExporter, cfg.Exporter)
+	}
+}
+
+// Tracer returns the tracer used by the service's instrumentation.
+//
+//nolint:ireturn // the OpenTelemetry API hands out tracers as interfaces
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Extract returns ctx carrying the remote span context of W3C trace context
+// headers, if any.
+func Extract(ctx context.Context, header map[string][]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// TraceID returns the hex trace ID of the span in ctx, or "" when there is
+// none.
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
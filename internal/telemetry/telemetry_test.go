@@ -0,0 +1,65 @@
+package telemetry_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/telemetry"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestFileExporterContinuesTraceparent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{
+		Exporter:    telemetry.ExporterFile,
+		Endpoint:    "",
+		File:        path,
+		SampleRatio: 0,
+	})
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Traceparent", traceparent)
+	ctx := telemetry.Extract(context.Background(), header)
+	ctx, span := telemetry.Tracer().Start(ctx, "unit")
+	if got := telemetry.TraceID(ctx); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("trace id = %q", got)
+	}
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read spans: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"unit"`) || !strings.Contains(string(data), "4bf92f3577b34da6a3ce929d0e0e4736") {
+		t.Fatalf("span not exported: %s", data)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, cfg := range []telemetry.Config{
+		{Exporter: "jaeger", Endpoint: "", File: "", SampleRatio: 1},
+		{Exporter: telemetry.ExporterFile, Endpoint: "", File: "", SampleRatio: 1},
+	} {
+		if _, err := telemetry.Setup(context.Background(), cfg); !errors.Is(err, telemetry.ErrInvalidExporter) {
+			t.Errorf("%+v: expected ErrInvalidExporter, got %v", cfg, err)
+		}
+	}
+}
+
+func TestTraceIDWithoutSpan(t *testing.T) {
+	if got := telemetry.TraceID(context.Background()); got != "" {
+		t.Fatalf("expected no trace id, got %q", got)
+	}
+}
@@ -0,0 +1,365 @@
+// Package trace performs iterative DNS resolution from the root servers and
+// records every delegation step, similar to `kdig +trace`.
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/rrtype"
+)
+
+const (
+	defaultMaxHops = 32
+	// maxGlueDepth bounds nested lookups of nameserver addresses for
+	// referrals without glue.
+	maxGlueDepth  = 3
+	ednsUDPSize   = 1232
+	millisPerUsec = 1000.0
+)
+
+var (
+	// ErrTooManyHops is returned when resolution does not finish within
+	// Tracer.MaxHops queries.
+	ErrTooManyHops = errors.New("too many hops")
+	// ErrNoServers is returned when a referral has no usable server address.
+	ErrNoServers = errors.New("no reachable nameserver addresses")
+)
+
+// Exchanger sends a DNS message to a nameserver over the given transport and
+// returns the response. resolver.NativeClient implements it.
+type Exchanger interface {
+	Exchange(ctx context.Context, msg *dns.Msg, nameserver, transport string) (*dns.Msg, error)
+}
+
+// Checker decides whether a nameserver may be queried over the given
+// transport. policy.Policy implements it.
+type Checker interface {
+	Check(ctx context.Context, nameserver, transport string) error
+}
+
+// Server is a nameserver queried during a trace.
+type Server struct {
+	// Name is the nameserver host name, e.g. "a.root-servers.net.".
+	Name string `json:"name"`
+	// Address is the IP address, optionally with a port ("127.0.0.1:5300").
+	Address string `json:"address"`
+}
+
+// Glue is an address of a referral nameserver.
+type Glue struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Resolved is set when the address was not in the referral's additional
+	// section and had to be looked up separately.
+	Resolved bool `json:"resolved,omitempty"`
+}
+
+// Referral is the delegation returned by a hop.
+type Referral struct {
+	Zone string   `json:"zone"`
+	NS   []string `json:"ns"`
+	Glue []Glue   `json:"glue,omitempty"`
+}
+
+// Hop is a single query sent during the trace.
+type Hop struct {
+	// Zone is the zone the server was queried as authoritative for.
+	Zone    string `json:"zone"`
+	Server  string `json:"server"`
+	Address string `json:"address"`
+	Rcode   string `json:"rcode,omitempty"`
+	// Authoritative reports the AA flag of the response.
+	Authoritative bool      `json:"authoritative"`
+	LatencyMillis float64   `json:"latency_ms"`
+	Referral      *Referral `json:"referral,omitempty"`
+	// Answer holds the answer records in presentation format.
+	Answer []string `json:"answer,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Result is the full delegation path for a query.
+type Result struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Rcode is the final response code; empty if the trace did not finish.
+	Rcode  string   `json:"rcode,omitempty"`
+	Answer []string `json:"answer,omitempty"`
+	Hops   []Hop    `json:"hops"`
+	// Error explains why the trace did not reach an authoritative answer.
+	Error string `json:"error,omitempty"`
+}
+
+// Tracer resolves names iteratively starting at Roots.
+type Tracer struct {
+	Exchange Exchanger
+	// Roots are the root hints. Tests point them at local fake roots.
+	Roots []Server
+	// MaxHops bounds the number of queries of a single trace.
+	MaxHops int
+	// Policy, when set, is checked for every server before it is queried,
+	// including servers taken from referral glue. Refused servers fail
+	// their hop.
+	Policy Checker
+}
+
+// NewTracer creates a Tracer. Nil or empty roots default to RootHints.
+func NewTracer(exchanger Exchanger, roots []Server) *Tracer {
+	if len(roots) == 0 {
+		roots = RootHints()
+	}
+	return &Tracer{Exchange: exchanger, Roots: roots, MaxHops: defaultMaxHops, Policy: nil}
+}
+
+// RootHints returns the IPv4 addresses of the IANA root servers.
+func RootHints() []Server {
+	return []Server{
+		{Name: "a.root-servers.net.", Address: "198.41.0.4"},
+		{Name: "b.root-servers.net.", Address: "170.247.170.2"},
+		{Name: "c.root-servers.net.", Address: "192.33.4.12"},
+		{Name: "d.root-servers.net.", Address: "199.7.91.13"},
+		{Name: "e.root-servers.net.", Address: "192.203.230.10"},
+		{Name: "f.root-servers.net.", Address: "192.5.5.241"},
+		{Name: "g.root-servers.net.", Address: "192.112.36.4"},
+		{Name: "h.root-servers.net.", Address: "198.97.190.53"},
+		{Name: "i.root-servers.net.", Address: "192.36.148.17"},
+		{Name: "j.root-servers.net.", Address: "192.58.128.30"},
+		{Name: "k.root-servers.net.", Address: "193.0.14.129"},
+		{Name: "l.root-servers.net.", Address: "199.7.83.42"},
+		{Name: "m.root-servers.net.", Address: "202.12.27.33"},
+	}
+}
+
+// ParseRootHints parses a comma-separated list of root server addresses
+// (optionally with ports) as used by the TRACE_ROOT_HINTS setting.
+func ParseRootHints(s string) []Server {
+	var servers []Server
+	for _, part := range strings.Split(s, ",") {
+		addr := strings.TrimSpace(part)
+		if addr == "" {
+			continue
+		}
+		servers = append(servers, Server{Name: addr, Address: addr})
+	}
+	return servers
+}
+
+// Trace resolves name/qtype iteratively from the roots and returns every hop.
+// transport is "" (UDP with TCP fallback) or "tcp". Failures are reported in
+// Result.Error rather than as an error value so the partial path is always
+// available.
+func (t *Tracer) Trace(ctx context.Context, name string, qtype uint16, transport string) *Result {
+	st := &state{t: t, ctx: ctx, transport: transport, hops: 0}
+	result := &Result{
+		Name:   dns.Fqdn(name),
+		Type:   rrtype.ByCode(qtype).Name,
+		Rcode:  "",
+		Answer: nil,
+		Hops:   []Hop{},
+		Error:  "",
+	}
+	if _, err := st.resolve(result, 0); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// state is shared by a trace and its nested glue lookups so that MaxHops
+// bounds the total work.
+type state struct {
+	t         *Tracer
+	ctx       context.Context //nolint:containedctx // scoped to a single Trace call
+	transport string
+	hops      int
+}
+
+// resolve walks the delegation chain for result.Name, appends hops to
+// result.Hops and returns the final response.
+func (s *state) resolve(result *Result, depth int) (*dns.Msg, error) {
+	rr, _ := rrtype.Lookup(result.Type)
+	zone := "."
+	servers := s.t.Roots
+	for {
+		resp, err := s.queryZone(result, zone, servers, rr.Code)
+		if err != nil {
+			return nil, err
+		}
+		hop := &result.Hops[len(result.Hops)-1]
+		referral := referralOf(resp, zone)
+		if len(resp.Answer) > 0 || referral == nil || resp.Rcode != dns.RcodeSuccess {
+			result.Rcode = dns.RcodeToString[resp.Rcode]
+			result.Answer = hop.Answer
+			if result.Rcode == "NOERROR" && len(resp.Answer) == 0 && !resp.Authoritative {
+				return resp, fmt.Errorf("lame response from %s for zone %s", hop.Address, zone)
+			}
+			return resp, nil
+		}
+		servers = s.referralServers(referral, depth)
+		hop.Referral = referral
+		if len(servers) == 0 {
+			return nil, fmt.Errorf("referral to %s: %w", referral.Zone, ErrNoServers)
+		}
+		zone = referral.Zone
+	}
+}
+
+// queryZone sends the query to servers in order until one answers, recording
+// a hop per attempt.
+func (s *state) queryZone(result *Result, zone string, servers []Server, qtype uint16) (*dns.Msg, error) {
+	var lastErr error
+	for _, server := range servers {
+		if s.hops >= s.t.maxHops() {
+			return nil, ErrTooManyHops
+		}
+		if err := s.ctx.Err(); err != nil {
+			return nil, fmt.Errorf("trace aborted: %w", err)
+		}
+		s.hops++
+		resp, hop := s.query(zone, server, result.Name, qtype)
+		result.Hops = append(result.Hops, hop)
+		if resp != nil {
+			return resp, nil
+		}
+		lastErr = errors.New(hop.Error)
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("zone %s: %w", zone, ErrNoServers)
+	}
+	return nil, fmt.Errorf("all servers for zone %s failed, last error: %w", zone, lastErr)
+}
+
+func (s *state) query(zone string, server Server, name string, qtype uint16) (*dns.Msg, Hop) {
+	hop := Hop{
+		Zone:          zone,
+		Server:        server.Name,
+		Address:       server.Address,
+		Rcode:         "",
+		Authoritative: false,
+		LatencyMillis: 0,
+		Referral:      nil,
+		Answer:        nil,
+		Error:         "",
+	}
+	if s.t.Policy != nil {
+		if err := s.t.Policy.Check(s.ctx, server.Address, s.transport); err != nil {
+			hop.Error = err.Error()
+			return nil, hop
+		}
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.RecursionDesired = false
+	msg.SetEdns0(ednsUDPSize, false)
+
+	start := time.Now()
+	resp, err := s.t.Exchange.Exchange(s.ctx, msg, server.Address, s.transport)
+	hop.LatencyMillis = float64(time.Since(start).Microseconds()) / millisPerUsec
+	if err != nil {
+		hop.Error = err.Error()
+		return nil, hop
+	}
+	hop.Rcode = dns.RcodeToString[resp.Rcode]
+	hop.Authoritative = resp.Authoritative
+	for _, rr := range resp.Answer {
+		hop.Answer = append(hop.Answer, rr.String())
+	}
+	return resp, hop
+}
+
+func (t *Tracer) maxHops() int {
+	if t.MaxHops <= 0 {
+		return defaultMaxHops
+	}
+	return t.MaxHops
+}
+
+// referralOf extracts a delegation from resp. Only referrals to zones strictly
+// below the current zone are accepted, which also prevents loops.
+func referralOf(resp *dns.Msg, zone string) *Referral {
+	var referral *Referral
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+		child := dns.CanonicalName(ns.Hdr.Name)
+		if child == dns.CanonicalName(zone) || !dns.IsSubDomain(zone, child) {
+			continue
+		}
+		if referral == nil {
+			referral = &Referral{Zone: child, NS: nil, Glue: nil}
+		}
+		if child == referral.Zone {
+			referral.NS = append(referral.NS, dns.CanonicalName(ns.Ns))
+		}
+	}
+	if referral == nil {
+		return nil
+	}
+	for _, rr := range resp.Extra {
+		var addr string
+		switch rec := rr.(type) {
+		case *dns.A:
+			addr = rec.A.String()
+		case *dns.AAAA:
+			addr = rec.AAAA.String()
+		default:
+			continue
+		}
+		owner := dns.CanonicalName(rr.Header().Name)
+		for _, ns := range referral.NS {
+			if ns == owner {
+				referral.Glue = append(referral.Glue, Glue{Name: owner, Address: addr, Resolved: false})
+				break
+			}
+		}
+	}
+	return referral
+}
+
+// referralServers returns the servers to query next, preferring IPv4 glue.
+// Nameservers without glue are looked up with a nested trace and the result
+// recorded in referral.Glue.
+func (s *state) referralServers(referral *Referral, depth int) []Server {
+	if len(referral.Glue) == 0 && depth < maxGlueDepth {
+		for _, ns := range referral.NS {
+			for _, addr := range s.lookupAddrs(ns, depth+1) {
+				referral.Glue = append(referral.Glue, Glue{Name: ns, Address: addr, Resolved: true})
+			}
+			if len(referral.Glue) > 0 {
+				break
+			}
+		}
+	}
+	var v4, v6 []Server
+	for _, glue := range referral.Glue {
+		server := Server{Name: glue.Name, Address: glue.Address}
+		if strings.Contains(glue.Address, ":") {
+			v6 = append(v6, server)
+		} else {
+			v4 = append(v4, server)
+		}
+	}
+	return append(v4, v6...)
+}
+
+// lookupAddrs resolves the IPv4 addresses of a nameserver from the roots.
+func (s *state) lookupAddrs(name string, depth int) []string {
+	sub := &Result{Name: name, Type: "A", Rcode: "", Answer: nil, Hops: nil, Error: ""}
+	resp, err := s.resolve(sub, depth)
+	if err != nil {
+		return nil
+	}
+	var addrs []string
+	for _, rr := range resp.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			addrs = append(addrs, a.A.String())
+		}
+	}
+	return addrs
+}
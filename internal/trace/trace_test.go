@@ -0,0 +1,198 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/trace"
+)
+
+const (
+	rootAddr    = "198.51.100.1"
+	exampleAddr = "198.51.100.2"
+	deadAddr    = "198.51.100.99"
+	// metadataAddr is a link-local address the nameserver policy refuses.
+	metadataAddr = "169.254.169.254"
+)
+
+// fakeNet routes queries to in-memory authoritative servers by address.
+type fakeNet struct {
+	servers map[string]func(q dns.Question, resp *dns.Msg)
+}
+
+func (f *fakeNet) Exchange(_ context.Context, msg *dns.Msg, nameserver, _ string) (*dns.Msg, error) {
+	serve, ok := f.servers[nameserver]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	resp := new(dns.Msg)
+	resp.SetReply(msg)
+	serve(msg.Question[0], resp)
+	return resp, nil
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return rr
+}
+
+// newFakeNet serves a root delegating "example." with glue and "other."
+// without glue, and an "example." server that is also authoritative for
+// "other.".
+func newFakeNet(t *testing.T) *fakeNet {
+	t.Helper()
+	root := func(q dns.Question, resp *dns.Msg) {
+		switch {
+		case dns.IsSubDomain("example.", q.Name):
+			resp.Ns = []dns.RR{mustRR(t, "example. 172800 IN NS ns1.example.")}
+			resp.Extra = []dns.RR{mustRR(t, "ns1.example. 172800 IN A "+exampleAddr)}
+		case dns.IsSubDomain("other.", q.Name):
+			resp.Ns = []dns.RR{mustRR(t, "other. 172800 IN NS ns1.example.")}
+		case dns.IsSubDomain("lame.", q.Name):
+			resp.Ns = []dns.RR{mustRR(t, "lame. 172800 IN NS ns.lame.")}
+			resp.Extra = []dns.RR{mustRR(t, "ns.lame. 172800 IN A "+deadAddr)}
+		case dns.IsSubDomain("internal.", q.Name):
+			resp.Ns = []dns.RR{mustRR(t, "internal. 172800 IN NS ns.internal.")}
+			resp.Extra = []dns.RR{mustRR(t, "ns.internal. 172800 IN A "+metadataAddr)}
+		default:
+			resp.Rcode = dns.RcodeNameError
+		}
+	}
+	example := func(q dns.Question, resp *dns.Msg) {
+		resp.Authoritative = true
+		switch q.Name {
+		case "www.example.":
+			resp.Answer = []dns.RR{mustRR(t, "www.example. 300 IN A 192.0.2.1")}
+		case "ns1.example.":
+			resp.Answer = []dns.RR{mustRR(t, "ns1.example. 300 IN A "+exampleAddr)}
+		case "host.other.":
+			resp.Answer = []dns.RR{mustRR(t, "host.other. 300 IN A 192.0.2.2")}
+		default:
+			resp.Rcode = dns.RcodeNameError
+			resp.Ns = []dns.RR{mustRR(t, "example. 300 IN SOA ns1.example. admin.example. 1 2 3 4 5")}
+		}
+	}
+	return &fakeNet{servers: map[string]func(dns.Question, *dns.Msg){rootAddr: root, exampleAddr: example}}
+}
+
+func newTracer(t *testing.T, roots ...string) *trace.Tracer {
+	t.Helper()
+	if len(roots) == 0 {
+		roots = []string{rootAddr}
+	}
+	return trace.NewTracer(newFakeNet(t), trace.ParseRootHints(strings.Join(roots, ",")))
+}
+
+func TestTraceReferralChain(t *testing.T) {
+	result := newTracer(t).Trace(context.Background(), "www.example", dns.TypeA, "")
+	if result.Error != "" {
+		t.Fatalf("unexpected error: %s", result.Error)
+	}
+	if result.Rcode != "NOERROR" || len(result.Answer) != 1 || !strings.Contains(result.Answer[0], "192.0.2.1") {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(result.Hops) != 2 {
+		t.Fatalf("expected 2 hops, got %+v", result.Hops)
+	}
+	ref := result.Hops[0].Referral
+	if result.Hops[0].Zone != "." || ref == nil || ref.Zone != "example." || len(ref.Glue) != 1 {
+		t.Fatalf("unexpected root hop: %+v", result.Hops[0])
+	}
+	if hop := result.Hops[1]; hop.Zone != "example." || hop.Address != exampleAddr || !hop.Authoritative {
+		t.Fatalf("unexpected final hop: %+v", hop)
+	}
+}
+
+// qtypeRecorder records the question type of every exchanged message.
+type qtypeRecorder struct {
+	trace.Exchanger
+	qtypes []uint16
+}
+
+func (r *qtypeRecorder) Exchange(ctx context.Context, msg *dns.Msg, nameserver, transport string) (*dns.Msg, error) {
+	r.qtypes = append(r.qtypes, msg.Question[0].Qtype)
+	return r.Exchanger.Exchange(ctx, msg, nameserver, transport)
+}
+
+func TestTraceGenericType(t *testing.T) {
+	const qtype = 65400
+	recorder := &qtypeRecorder{Exchanger: newFakeNet(t), qtypes: nil}
+	tracer := trace.NewTracer(recorder, trace.ParseRootHints(rootAddr))
+	result := tracer.Trace(context.Background(), "www.example.", qtype, "")
+	if result.Type != "TYPE65400" || len(recorder.qtypes) != 2 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	for _, got := range recorder.qtypes {
+		if got != qtype {
+			t.Fatalf("queried type %d, want %d", got, qtype)
+		}
+	}
+}
+
+func TestTraceNXDOMAIN(t *testing.T) {
+	result := newTracer(t).Trace(context.Background(), "missing.example.", dns.TypeA, "")
+	if result.Error != "" || result.Rcode != "NXDOMAIN" {
+		t.Fatalf("expected NXDOMAIN, got %+v", result)
+	}
+}
+
+func TestTraceGluelessReferral(t *testing.T) {
+	result := newTracer(t).Trace(context.Background(), "host.other.", dns.TypeA, "")
+	if result.Error != "" || len(result.Answer) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	ref := result.Hops[0].Referral
+	if ref == nil || len(ref.Glue) != 1 || !ref.Glue[0].Resolved || ref.Glue[0].Address != exampleAddr {
+		t.Fatalf("expected resolved glue, got %+v", ref)
+	}
+}
+
+func TestTraceServerFallback(t *testing.T) {
+	result := newTracer(t, deadAddr, rootAddr).Trace(context.Background(), "www.example.", dns.TypeA, "")
+	if result.Error != "" {
+		t.Fatalf("unexpected error: %s", result.Error)
+	}
+	if len(result.Hops) != 3 || result.Hops[0].Error == "" || result.Hops[1].Address != rootAddr {
+		t.Fatalf("expected failed hop followed by fallback, got %+v", result.Hops)
+	}
+}
+
+func TestTraceUnreachableDelegation(t *testing.T) {
+	result := newTracer(t).Trace(context.Background(), "www.lame.", dns.TypeA, "")
+	if result.Error == "" || !strings.Contains(result.Error, "lame.") {
+		t.Fatalf("expected error for unreachable zone, got %+v", result)
+	}
+	if len(result.Hops) != 2 || result.Hops[0].Referral == nil {
+		t.Fatalf("expected partial path, got %+v", result.Hops)
+	}
+}
+
+func TestTraceMaxHops(t *testing.T) {
+	tracer := newTracer(t)
+	tracer.MaxHops = 1
+	result := tracer.Trace(context.Background(), "www.example.", dns.TypeA, "")
+	if !strings.Contains(result.Error, trace.ErrTooManyHops.Error()) {
+		t.Fatalf("expected too many hops, got %+v", result)
+	}
+}
+
+func TestTracePolicyRefusesGlue(t *testing.T) {
+	tracer := newTracer(t)
+	tracer.Policy = policy.New(policy.Config{AllowCIDRs: nil, DenyCIDRs: nil, AllowHosts: nil, DenyHosts: nil, Ports: nil})
+	result := tracer.Trace(context.Background(), "www.internal.", dns.TypeA, "")
+	if result.Error == "" || len(result.Hops) != 2 {
+		t.Fatalf("expected the glue hop to fail, got %+v", result)
+	}
+	if hop := result.Hops[1]; hop.Address != metadataAddr || !strings.Contains(hop.Error, policy.ErrRefused.Error()) {
+		t.Fatalf("expected a policy error for %s, got %+v", metadataAddr, hop)
+	}
+}
@@ -0,0 +1,246 @@
+// Package upstream provides named upstream resolver profiles that requests can
+// reference instead of raw nameserver strings.
+package upstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	portDNS   = 53
+	portHTTPS = 443
+	portDoT   = 853
+	maxPort   = 65535
+
+	defaultDoHURL = "https://{hostname}/dns-query"
+)
+
+// ErrInvalidProfile is wrapped by every validation error of a profile.
+var ErrInvalidProfile = errors.New("invalid upstream profile")
+
+// Profile describes one upstream resolver.
+type Profile struct {
+	// Name is referenced as "nameserver" in requests. It must not contain
+	// dots, colons, slashes or '#' so it cannot shadow a host or address.
+	Name string `json:"name"`
+	// Description is shown by GET /upstreams.
+	Description string `json:"description,omitempty"`
+	// Addresses are tried in order; kdig uses the first one.
+	Addresses []string `json:"addresses"`
+	// Port defaults to 53, 853 or 443 depending on Transport.
+	Port int `json:"port,omitempty"`
+	// Transport is empty (UDP), "tcp", "tls" or "https".
+	Transport string `json:"transport"`
+	// TLSHostname is the name verified in the server certificate and sent as
+	// SNI for "tls" and "https".
+	TLSHostname string `json:"tls_hostname,omitempty"`
+	// DoHURL is the DoH endpoint template for "https". "{address}", "{port}"
+	// and "{hostname}" are substituted; the default is
+	// "https://{hostname}/dns-query".
+	DoHURL string `json:"doh_url,omitempty"`
+	// DNSSEC sets the DO bit on every query unless the request asks for it
+	// anyway.
+	DNSSEC bool `json:"dnssec"`
+}
+
+// Target returns address joined with the profile port in kdig's
+// "address#port" notation.
+func (p Profile) Target(address string) string {
+	return address + "#" + strconv.Itoa(p.Port)
+}
+
+// Endpoint expands the DoH URL template for address.
+func (p Profile) Endpoint(address string) string {
+	host := address
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	hostname := p.TLSHostname
+	if hostname == "" {
+		hostname = host
+	}
+	tmpl := p.DoHURL
+	if tmpl == "" {
+		tmpl = defaultDoHURL
+	}
+	return strings.NewReplacer(
+		"{address}", host,
+		"{port}", strconv.Itoa(p.Port),
+		"{hostname}", hostname,
+	).Replace(tmpl)
+}
+
+// Catalog is a set of profiles indexed by name. Its contents can be swapped
+// with Replace while it is in use.
+type Catalog struct {
+	set atomic.Pointer[profileSet]
+}
+
+// profileSet is the immutable content of a Catalog.
+type profileSet struct {
+	profiles []Profile
+	byName   map[string]Profile
+}
+
+// NewCatalog validates profiles, fills in default ports and returns the
+// catalog.
+func NewCatalog(profiles []Profile) (*Catalog, error) {
+	set := &profileSet{profiles: make([]Profile, 0, len(profiles)), byName: make(map[string]Profile, len(profiles))}
+	for _, p := range profiles {
+		if err := normalize(&p); err != nil {
+			return nil, err
+		}
+		if _, dup := set.byName[p.Name]; dup {
+			return nil, fmt.Errorf("%w: duplicate name %q", ErrInvalidProfile, p.Name)
+		}
+		set.byName[p.Name] = p
+		set.profiles = append(set.profiles, p)
+	}
+	c := &Catalog{set: atomic.Pointer[profileSet]{}}
+	c.set.Store(set)
+	return c, nil
+}
+
+// Lookup returns the profile registered under name. It is safe to call on a
+// nil Catalog.
+func (c *Catalog) Lookup(name string) (Profile, bool) {
+	if c == nil {
+		var zero Profile
+		return zero, false
+	}
+	p, ok := c.set.Load().byName[name]
+	return p, ok
+}
+
+// Profiles returns all profiles in definition order.
+func (c *Catalog) Profiles() []Profile {
+	if c == nil {
+		return nil
+	}
+	profiles := c.set.Load().profiles
+	out := make([]Profile, len(profiles))
+	copy(out, profiles)
+	return out
+}
+
+// Replace atomically swaps the profiles of c for those of other, so that
+// every holder of c sees the new profiles.
+func (c *Catalog) Replace(other *Catalog) {
+	c.set.Store(other.set.Load())
+}
+
+// Load reads a JSON array of profiles from path.
+func Load(path string) (*Catalog, error) {
+	f, err := os.Open(path) //nolint:gosec // path comes from operator configuration
+	if err != nil {
+		return nil, fmt.Errorf("open upstreams file: %w", err)
+	}
+	defer f.Close()
+	catalog, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("upstreams file %s: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// Parse reads a JSON array of profiles.
+func Parse(r io.Reader) (*Catalog, error) {
+	var profiles []Profile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profiles); err != nil {
+		return nil, fmt.Errorf("decode upstream profiles: %w", err)
+	}
+	return NewCatalog(profiles)
+}
+
+// normalize validates p and applies the transport's default port.
+func normalize(p *Profile) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w %q: "+format, append([]any{ErrInvalidProfile, p.Name}, args...)...)
+	}
+	if p.Name == "" || strings.ContainsAny(p.Name, ".:/#@ ") {
+		return invalid("name must be non-empty and must not contain '.', ':', '/', '#', '@' or spaces")
+	}
+	if len(p.Addresses) == 0 {
+		return invalid("at least one address is required")
+	}
+	for _, addr := range p.Addresses {
+		if addr == "" || strings.ContainsAny(addr, "/#@ []") {
+			return invalid("address %q must be a bare IP address or hostname", addr)
+		}
+	}
+	switch p.Transport {
+	case "", "tcp":
+		p.Port = withDefault(p.Port, portDNS)
+	case "tls":
+		p.Port = withDefault(p.Port, portDoT)
+	case "https":
+		p.Port = withDefault(p.Port, portHTTPS)
+		if p.DoHURL != "" {
+			if u, err := url.Parse(p.Endpoint(p.Addresses[0])); err != nil || u.Scheme != "https" || u.Host == "" {
+				return invalid("doh_url %q must be an https:// URL", p.DoHURL)
+			}
+		}
+	default:
+		return invalid(`transport must be empty or "tcp" or "tls" or "https"`)
+	}
+	if p.Port <= 0 || p.Port > maxPort {
+		return invalid("port %d is out of range", p.Port)
+	}
+	if strings.ContainsAny(p.TLSHostname, "/:@ ") && net.ParseIP(p.TLSHostname) == nil {
+		return invalid("tls_hostname %q is not a hostname", p.TLSHostname)
+	}
+	return nil
+}
+
+func withDefault(port, def int) int {
+	if port == 0 {
+		return def
+	}
+	return port
+}
+
+// Defaults returns the built-in catalogue of well-known public resolvers used
+// when no upstreams file is configured.
+func Defaults() *Catalog {
+	quad9 := []string{"9.9.9.9", "149.112.112.112", "2620:fe::fe", "2620:fe::9"}
+	cloudflare := []string{"1.1.1.1", "1.0.0.1", "2606:4700:4700::1111", "2606:4700:4700::1001"}
+	google := []string{"8.8.8.8", "8.8.4.4", "2001:4860:4860::8888", "2001:4860:4860::8844"}
+	profile := func(name, desc string, addrs []string, transport, hostname string) Profile {
+		return Profile{
+			Name:        name,
+			Description: desc,
+			Addresses:   addrs,
+			Port:        0,
+			Transport:   transport,
+			TLSHostname: hostname,
+			DoHURL:      "",
+			DNSSEC:      false,
+		}
+	}
+	catalog, err := NewCatalog([]Profile{
+		profile("quad9", "Quad9 over UDP", quad9, "", ""),
+		profile("quad9-dot", "Quad9 over TLS", quad9, "tls", "dns.quad9.net"),
+		profile("quad9-doh", "Quad9 over HTTPS", quad9, "https", "dns.quad9.net"),
+		profile("cloudflare", "Cloudflare over UDP", cloudflare, "", ""),
+		profile("cloudflare-dot", "Cloudflare over TLS", cloudflare, "tls", "one.one.one.one"),
+		profile("cloudflare-doh", "Cloudflare over HTTPS", cloudflare, "https", "cloudflare-dns.com"),
+		profile("google", "Google Public DNS over UDP", google, "", ""),
+		profile("google-dot", "Google Public DNS over TLS", google, "tls", "dns.google"),
+		profile("google-doh", "Google Public DNS over HTTPS", google, "https", "dns.google"),
+	})
+	if err != nil {
+		panic(err) // the built-in profiles are static and valid
+	}
+	return catalog
+}
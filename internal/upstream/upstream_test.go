@@ -0,0 +1,84 @@
+package upstream_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+func TestParseAppliesDefaults(t *testing.T) {
+	catalog, err := upstream.Parse(strings.NewReader(`[
+		{"name":"corp","addresses":["10.0.0.53"],"transport":"tcp"},
+		{"name":"corp-dot","addresses":["10.0.0.53","10.0.1.53"],"transport":"tls","tls_hostname":"dns.corp.example","dnssec":true},
+		{"name":"corp-doh","addresses":["2001:db8::53"],"transport":"https","doh_url":"https://{address}:{port}/q"}
+	]`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	dot, ok := catalog.Lookup("corp-dot")
+	if !ok || dot.Port != 853 || !dot.DNSSEC || dot.Target(dot.Addresses[1]) != "10.0.1.53#853" {
+		t.Fatalf("unexpected profile: %+v", dot)
+	}
+	if p, _ := catalog.Lookup("corp"); p.Port != 53 {
+		t.Fatalf("expected default DNS port, got %d", p.Port)
+	}
+	doh, _ := catalog.Lookup("corp-doh")
+	if got := doh.Endpoint(doh.Addresses[0]); got != "https://[2001:db8::53]:443/q" {
+		t.Fatalf("unexpected DoH endpoint %q", got)
+	}
+	if names := len(catalog.Profiles()); names != 3 {
+		t.Fatalf("expected 3 profiles, got %d", names)
+	}
+	if _, ok := catalog.Lookup("10.0.0.53"); ok {
+		t.Fatalf("addresses must not be profile names")
+	}
+}
+
+func TestParseRejectsInvalidProfiles(t *testing.T) {
+	cases := []string{
+		`[{"name":"dns.example","addresses":["192.0.2.1"]}]`,
+		`[{"name":"a","addresses":[]}]`,
+		`[{"name":"a","addresses":["192.0.2.1#53"]}]`,
+		`[{"name":"a","addresses":["192.0.2.1"],"transport":"quic"}]`,
+		`[{"name":"a","addresses":["192.0.2.1"],"port":70000}]`,
+		`[{"name":"a","addresses":["192.0.2.1"],"transport":"https","doh_url":"http://{address}/dns-query"}]`,
+		`[{"name":"a","addresses":["192.0.2.1"]},{"name":"a","addresses":["192.0.2.2"]}]`,
+	}
+	for _, tc := range cases {
+		if _, err := upstream.Parse(strings.NewReader(tc)); !errors.Is(err, upstream.ErrInvalidProfile) {
+			t.Errorf("%s: expected ErrInvalidProfile, got %v", tc, err)
+		}
+	}
+	if _, err := upstream.Parse(strings.NewReader(`[{"name":"a","address":"192.0.2.1"}]`)); err == nil {
+		t.Errorf("expected unknown fields to be rejected")
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	catalog := upstream.Defaults()
+	p, ok := catalog.Lookup("cloudflare-doh")
+	if !ok || p.Endpoint(p.Addresses[0]) != "https://cloudflare-dns.com/dns-query" {
+		t.Fatalf("unexpected cloudflare-doh profile: %+v", p)
+	}
+	var nilCatalog *upstream.Catalog
+	if _, ok := nilCatalog.Lookup("quad9"); ok || nilCatalog.Profiles() != nil {
+		t.Fatalf("nil catalog must be empty")
+	}
+}
+
+func TestCatalogReplace(t *testing.T) {
+	catalog := upstream.Defaults()
+	next, err := upstream.Parse(strings.NewReader(`[{"name":"lab","addresses":["192.0.2.53"]}]`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	catalog.Replace(next)
+	if _, ok := catalog.Lookup("quad9"); ok {
+		t.Errorf("replaced profile still present")
+	}
+	if p, ok := catalog.Lookup("lab"); !ok || p.Port != 53 {
+		t.Errorf("new profile: got %+v, %v", p, ok)
+	}
+}
@@ -3,102 +3,555 @@ package wdns
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/exiguus/wdns/internal/api"
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/cache"
+	"github.com/exiguus/wdns/internal/coalesce"
 	"github.com/exiguus/wdns/internal/config"
+	"github.com/exiguus/wdns/internal/dnssec"
 	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/health"
+	"github.com/exiguus/wdns/internal/listener"
+	"github.com/exiguus/wdns/internal/metrics"
+	"github.com/exiguus/wdns/internal/policy"
+	"github.com/exiguus/wdns/internal/pool"
 	"github.com/exiguus/wdns/internal/ratelimit"
 	"github.com/exiguus/wdns/internal/resolver"
+	"github.com/exiguus/wdns/internal/telemetry"
+	"github.com/exiguus/wdns/internal/trace"
+	"github.com/exiguus/wdns/internal/upstream"
 )
 
 const (
-	defaultResolverTimeout = 5 * time.Second
-	defaultMaxOutput       = 32 * 1024
-	shutdownTimeout        = 10 * time.Second
-	readHeaderTimeout      = 5 * time.Second
-	readTimeout            = 10 * time.Second
-	writeTimeout           = 10 * time.Second
-	cleanupInterval        = 5 * time.Minute
+	traceFlushTimeout = 10 * time.Second
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 10 * time.Second
+	writeTimeout      = 10 * time.Second
+	cleanupInterval   = 5 * time.Minute
+	// traceHopTimeout bounds each query of a trace so that an unresponsive
+	// server leaves time to try the next one.
+	traceHopTimeout = 2 * time.Second
+	// backendCheckInterval is how long the readiness result of the resolver
+	// backend is reused, so that probes do not start kdig every time.
+	backendCheckInterval = 30 * time.Second
+	// adminSocketName is the FileDescriptorName= of systemd sockets serving
+	// the admin endpoints.
+	adminSocketName = "admin"
 )
 
 // Run starts the HTTP server and registers the application handlers.
 // It is exported so `cmd/wdns` can call into the package to produce the
 // executable while allowing the core logic to be imported by other code.
 func Run() {
-	port := "8080"
-	if p := os.Getenv("PORT"); p != "" {
-		port = p
+	cfg, ok := loadConfig()
+	if !ok {
+		return
 	}
 
 	// create logger early so we can log during startup
 	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	// create resolver runner
-	resolverRunner := resolver.NewRunner(defaultResolverTimeout, defaultMaxOutput)
+	// OpenTelemetry tracing; pending spans are flushed on exit
+	defer createTracing(cfg.Tracing)()
+	opts, stop := createOptions(cfg, logger)
+	defer stop()
+	apiListeners, adminListeners := createListeners(cfg.Server)
+	// SIGHUP and POST /admin/reload re-read the configuration
+	reloader := newReloader(os.Args[1:], cfg, opts, len(adminListeners) > 0)
+	tlsConfig, adminTLSConfig, protocols := createTLS(cfg.TLS)
 
-	mux := http.NewServeMux()
-	// initialize rate limiter
-	limiter, stopCleanup := createLimiter()
-	defer close(stopCleanup)
-	// load trusted proxies for header-based client IP extraction
-	trustedProxies, err := config.LoadTrustedProxies()
-	if err != nil {
-		log.Printf("warning: failed to parse TRUSTED_PROXIES: %v", err)
+	srv := newServer(reloader, protocols)
+	for _, ln := range apiListeners {
+		serve(srv, withProxyProtocol(ln, cfg.Server.ProxyProtocol, reloader.trustedProxies), tlsConfig)
 	}
-	// pass logger to handler for request-level logging
-	handler.Register(mux, resolverRunner, limiter, trustedProxies, logger)
+	servers := []*http.Server{srv}
+	if len(adminListeners) > 0 {
+		adminSrv := newServer(reloader.adminHandler(), protocols)
+		for _, ln := range adminListeners {
+			serve(adminSrv, ln, adminTLSConfig)
+		}
+		servers = append(servers, adminSrv)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			log.Printf("Received %s, shutting down", sig)
+			break
+		}
+		_, _ = reloader.reload(context.Background())
+	}
+	shutdown(servers, opts, cfg.Server, signals)
+}
 
-	srv := &http.Server{
-		Addr:              ":" + port,
-		Handler:           mux,
+// newServer returns an HTTP server for h. protocols restricts the protocols
+// served over TLS.
+func newServer(h http.Handler, protocols *http.Protocols) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		Protocols:         protocols,
 		ReadHeaderTimeout: readHeaderTimeout,
 		ReadTimeout:       readTimeout,
 		WriteTimeout:      writeTimeout,
 	}
+}
 
+// serve accepts connections from ln on srv in the background, terminating
+// TLS when tlsConfig is set. A serve error other than a shutdown is fatal.
+func serve(srv *http.Server, ln net.Listener, tlsConfig *tls.Config) {
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
 	go func() {
-		log.Printf("Server started on %s", srv.Addr)
-		if serr := srv.ListenAndServe(); serr != nil && serr != http.ErrServerClosed {
-			log.Fatalf("ListenAndServe: %v", serr)
+		log.Printf("Server started on %s %s", ln.Addr().Network(), ln.Addr())
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Serve: %v", err)
 		}
 	}()
+}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+// shutdown drains and stops servers. Health checks report 503 and keep-alive
+// connections are closed for the drain delay so that load balancers stop
+// routing new requests here; a further shutdown signal skips the wait. Then
+// queued queries are cancelled and in-flight requests, including running
+// kdig processes, get up to the shutdown timeout to complete.
+func shutdown(servers []*http.Server, opts handler.Options, cfg config.Server, signals <-chan os.Signal) {
+	log.Printf("Draining for %s", cfg.DrainDelay)
+	opts.Draining.Store(true)
+	for _, srv := range servers {
+		srv.SetKeepAlivesEnabled(false)
+	}
+	delay := time.NewTimer(cfg.DrainDelay)
+	defer delay.Stop()
+drain:
+	for {
+		select {
+		case <-delay.C:
+			break drain
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("Received %s, skipping the drain delay", sig)
+				break drain
+			}
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-	// call cancel before possible exit to ensure resources are released
-	if derr := srv.Shutdown(ctx); derr != nil {
-		cancel()
-		log.Printf("Server Shutdown failed:%+v", derr)
-		return
+	opts.Pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	var failed bool
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Server Shutdown failed:%+v", err)
+			failed = true
+		}
+	}
+	if !failed {
+		log.Println("Server exited properly")
+	}
+}
+
+// loadConfig loads and validates the configuration. It returns false when
+// the process should exit without serving, after -h or -print-config.
+func loadConfig() (config.Config, bool) {
+	cfg, printConfig, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return cfg, false
+	}
+	if err != nil {
+		log.Fatalf("configuration: %v", err)
+	}
+	if printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalf("print config: %v", err)
+		}
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// createOptions builds the resolver pipeline and the handler options from
+// cfg. The returned function stops background work.
+func createOptions(cfg config.Config, logger *slog.Logger) (handler.Options, func()) {
+	// Prometheus metrics, nil when disabled
+	appMetrics := createMetrics(cfg.Metrics)
+	// load the named upstream profiles requests may use as nameserver
+	upstreams, err := loadUpstreams(cfg.Resolver.UpstreamsFile)
+	if err != nil {
+		// requests referencing its profiles would otherwise be sent elsewhere
+		log.Fatalf("%v", err)
+	}
+	// create resolver backend (kdig runner or native Go client)
+	backend := createResolver(cfg.Resolver, upstreams, appMetrics)
+	resolverRunner := backend
+	// bound the number of concurrent executions (kdig processes)
+	executionPool := createPool(cfg.Resolver, resolverRunner)
+	resolverRunner = executionPool
+	// share one upstream execution among concurrent identical queries
+	coalescer := coalesce.New(resolverRunner)
+	resolverRunner = coalescer
+	// optionally wrap it with the response cache
+	responseCache := createCache(cfg.Cache, resolverRunner)
+	if responseCache != nil {
+		resolverRunner = responseCache
+	}
+
+	// initialize rate limiter
+	limiter, stopCleanup := createLimiter(cfg.RateLimit)
+	appMetrics.GaugeFunc("rate_limiters", "Clients tracked by the per-IP rate limiter.", func() float64 {
+		return float64(limiter.Len())
+	})
+	// trusted proxies for header-based client IP extraction, validated by Load
+	trustedProxies, _ := config.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	nameserverPolicy := policy.New(policyConfig(cfg.Policy))
+	// pass logger to handler for request-level logging
+	return handler.Options{
+		Resolver:         resolverRunner,
+		Limiter:          limiter,
+		TrustedProxies:   trustedProxies,
+		ClientIPHeaders:  cfg.Server.ClientIPHeaders,
+		Logger:           logger,
+		BatchConcurrency: cfg.Batch.Concurrency,
+		BatchMaxItems:    cfg.Batch.MaxItems,
+		Validator:        createValidator(cfg, upstreams),
+		Tracer:           createTracer(cfg, nameserverPolicy),
+		DoHUpstream:      cfg.DoH.Upstream,
+		DoHTransport:     cfg.DoH.Transport,
+		Exchanger:        createNativeClient(cfg.Resolver, upstreams),
+		Cache:            responseCache,
+		Coalescer:        coalescer,
+		Pool:             executionPool,
+		Policy:           nameserverPolicy,
+		Upstreams:        upstreams,
+		Keys:             createKeys(cfg.Auth, cfg.RateLimit),
+		Metrics:          appMetrics,
+		Reload:           nil,
+		Draining:         new(atomic.Bool),
+		Health:           createHealth(cfg.Health, backend, executionPool, resolverRunner),
+	}, func() { close(stopCleanup) }
+}
+
+// createListeners returns the listeners of the API and of the separate
+// admin endpoints. Sockets passed by systemd socket activation take
+// precedence: those named "admin" by FileDescriptorName= serve the admin
+// endpoints and all others the API. Without activated sockets for a role,
+// its configured address is listened on, which may be a "unix:" socket
+// path. No admin listeners means the admin endpoints are served by the API.
+func createListeners(cfg config.Server) ([]net.Listener, []net.Listener) {
+	activated, err := listener.Activated()
+	if err != nil {
+		log.Fatalf("socket activation: %v", err)
+	}
+	admin := activated[adminSocketName]
+	delete(activated, adminSocketName)
+	var api []net.Listener
+	for _, listeners := range activated {
+		api = append(api, listeners...)
+	}
+	if activated != nil {
+		log.Printf("Socket activation passed %d API and %d admin listeners", len(api), len(admin))
+	}
+
+	mode, _ := listener.ParseSocketMode(cfg.SocketMode) // validated by config.Load
+	opts := listener.SocketOptions{Mode: mode, User: cfg.SocketUser, Group: cfg.SocketGroup}
+	if len(api) == 0 {
+		ln, err := listener.Listen(cfg.Listen, opts)
+		if err != nil {
+			log.Fatalf("Listen: %v", err)
+		}
+		api = append(api, ln)
+	}
+	if len(admin) == 0 && cfg.AdminListen != "" {
+		ln, err := listener.Listen(cfg.AdminListen, opts)
+		if err != nil {
+			log.Fatalf("Listen: %v", err)
+		}
+		admin = append(admin, ln)
+	}
+	return api, admin
+}
+
+// withProxyProtocol returns ln accepting PROXY protocol v1/v2 headers from
+// trustedProxies when proxyProtocol is "optional" (or "on") or "required",
+// so that rate limiting and logs see the real client address. Unix socket
+// listeners are returned unchanged: their peers have no address that could
+// be trusted.
+//
+//nolint:ireturn // the listener is only wrapped when PROXY protocol is enabled
+func withProxyProtocol(ln net.Listener, proxyProtocol string, trustedProxies func() []*net.IPNet) net.Listener {
+	mode, err := listener.ParseProxyMode(proxyProtocol)
+	if err != nil {
+		log.Fatalf("PROXY_PROTOCOL: %v", err)
+	}
+	if mode == listener.ProxyOff {
+		return ln
+	}
+	if _, unix := ln.Addr().(*net.UnixAddr); unix {
+		log.Printf("PROXY protocol is not used on Unix socket %s", ln.Addr())
+		return ln
+	}
+	proxied, err := listener.WithProxyProtocol(ln, trustedProxies, mode, readHeaderTimeout)
+	if err != nil {
+		log.Fatalf("PROXY_PROTOCOL: %v", err)
 	}
-	cancel()
-	log.Println("Server exited properly")
+	log.Printf("PROXY protocol %s for %d trusted networks", mode, len(trustedProxies()))
+	return proxied
 }
 
-// createLimiter reads env vars and returns a configured rate limiter and a stop channel.
-func createLimiter() (*ratelimit.Manager, chan struct{}) {
-	rps := 10.0
-	burst := 20
-	if v := os.Getenv("RATE_LIMIT_RPS"); v != "" {
-		if parsed, err := strconv.ParseFloat(v, 64); err == nil {
-			rps = parsed
+// createTLS returns the TLS configurations of the API and admin listeners
+// and the HTTP protocols of the server, or nil when no certificate is
+// configured. The certificate is reloaded when its files change and client
+// certificates are verified against the client CA bundle when one is set,
+// in the client auth mode of each listener. Invalid files are fatal.
+func createTLS(cfg config.TLS) (*tls.Config, *tls.Config, *http.Protocols) {
+	if cfg.CertFile == "" {
+		return nil, nil, nil
+	}
+	cert, err := listener.LoadCertificate(cfg.CertFile, cfg.KeyFile, cfg.ReloadInterval)
+	if err != nil {
+		log.Fatalf("TLS: %v", err)
+	}
+	cert.OnReload = func(err error) {
+		if err != nil {
+			log.Printf("warning: %v, keeping the previous certificate", err)
+			return
+		}
+		log.Printf("TLS certificate reloaded from %s", cfg.CertFile)
+	}
+	var clientCAs *x509.CertPool
+	if cfg.ClientCAFile != "" {
+		if clientCAs, err = listener.LoadCertPool(cfg.ClientCAFile); err != nil {
+			log.Fatalf("TLS: %v", err)
+		}
+	}
+	newConfig := func(clientAuth string) *tls.Config {
+		tlsConfig, err := listener.NewTLSConfig(listener.TLSConfig{
+			Certificate:  cert,
+			MinVersion:   cfg.MinVersion,
+			CipherSuites: cfg.CipherSuites,
+			ClientCAs:    clientCAs,
+			ClientAuth:   clientAuth,
+			HTTP2:        cfg.HTTP2,
+		})
+		if err != nil {
+			log.Fatalf("TLS: %v", err)
 		}
+		return tlsConfig
 	}
-	if v := os.Getenv("RATE_LIMIT_BURST"); v != "" {
-		if parsed, err := strconv.Atoi(v); err == nil {
-			burst = parsed
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(cfg.HTTP2)
+	return newConfig(cfg.ClientAuth), newConfig(cfg.AdminClientAuth), protocols
+}
+
+// createResolver returns the configured resolver backend, falling back to the
+// kdig runner if it cannot be created. Executions are reported to m.
+//
+//nolint:ireturn // the backend is selected at runtime from configuration
+func createResolver(cfg config.Resolver, upstreams *upstream.Catalog, m *metrics.Metrics) resolver.Resolver {
+	res, err := resolver.New(cfg.Backend, cfg.Timeout, cfg.MaxOutput, upstreams)
+	if err != nil {
+		log.Printf("warning: %v, using %s", err, resolver.BackendKdig)
+		res = resolver.NewRunner(cfg.Timeout, cfg.MaxOutput)
+	}
+	switch backend := res.(type) {
+	case *resolver.Runner:
+		backend.Upstreams = upstreams
+		if m != nil {
+			backend.Observer = m
 		}
+	case *resolver.NativeClient:
+		if m != nil {
+			backend.Observer = m
+		}
+	default:
 	}
-	limiter := ratelimit.NewManager(rps, burst)
+	return res
+}
+
+// createHealth returns the readiness checks: the resolver backend (kdig
+// present and supported), execution pool saturation and, when a canary
+// nameserver is configured, a canary query through the full resolver stack.
+func createHealth(cfg config.Health, backend resolver.Resolver, p *pool.Pool, res resolver.Resolver) *health.Checker {
+	checker := health.NewChecker()
+	if b, ok := backend.(health.Backend); ok {
+		checker.Add("backend", health.BackendCheck(b, backendCheckInterval))
+	}
+	checker.Add("pool", health.PoolCheck(p))
+	if cfg.CanaryNameserver != "" {
+		req := api.RequestPayload{
+			Nameserver:       cfg.CanaryNameserver,
+			Name:             cfg.CanaryName,
+			Type:             "A",
+			Transport:        cfg.CanaryTransport,
+			DNSSEC:           false,
+			Short:            false,
+			AsJSON:           false,
+			Structured:       false,
+			ValidateDNSSEC:   false,
+			Trace:            false,
+			CheckingDisabled: false,
+			NoCache:          true,
+		}
+		checker.Add("canary", health.CanaryCheck(res, req, cfg.CanaryInterval))
+	}
+	return checker
+}
+
+// createTracing configures trace export and returns a function flushing
+// pending spans. A failing exporter is fatal.
+func createTracing(cfg config.Tracing) func() {
+	shutdown, err := telemetry.Setup(context.Background(), telemetry.Config{
+		Exporter:    cfg.Exporter,
+		Endpoint:    cfg.OTLPEndpoint,
+		File:        cfg.File,
+		SampleRatio: cfg.SampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Printf("trace shutdown: %v", err)
+		}
+	}
+}
+
+// createMetrics returns the Prometheus metrics served on /metrics, or nil
+// when they are disabled.
+func createMetrics(cfg config.Metrics) *metrics.Metrics {
+	if !cfg.Enabled {
+		return nil
+	}
+	return metrics.New()
+}
+
+// loadUpstreams loads the upstream profiles from path, or returns the
+// built-in profiles when it is empty.
+func loadUpstreams(path string) (*upstream.Catalog, error) {
+	if path == "" {
+		return upstream.Defaults(), nil
+	}
+	return upstream.Load(path) //nolint:wrapcheck // upstream.Load names the file
+}
+
+// createNativeClient returns a native client that expands upstream profiles.
+func createNativeClient(cfg config.Resolver, upstreams *upstream.Catalog) *resolver.NativeClient {
+	client := resolver.NewNativeClient(cfg.Timeout, cfg.MaxOutput)
+	client.Upstreams = upstreams
+	return client
+}
+
+// createPool wraps next with a bounded execution pool.
+func createPool(cfg config.Resolver, next resolver.Resolver) *pool.Pool {
+	return pool.New(next, pool.Config{
+		MaxRunning:   cfg.MaxConcurrency,
+		MaxQueued:    cfg.MaxQueue,
+		QueueTimeout: cfg.QueueTimeout,
+	})
+}
+
+// policyConfig converts the nameserver policy settings. Its CIDRs and ports
+// were validated by config.Load.
+func policyConfig(cfg config.Policy) policy.Config {
+	allow, _ := policy.ParseCIDRs(strings.Join(cfg.AllowCIDRs, ","))
+	deny, _ := policy.ParseCIDRs(strings.Join(cfg.DenyCIDRs, ","))
+	ports := make([]uint16, 0, len(cfg.AllowedPorts))
+	for _, port := range cfg.AllowedPorts {
+		ports = append(ports, uint16(port)) //nolint:gosec // range checked by config.Validate
+	}
+	return policy.Config{
+		AllowCIDRs: allow,
+		DenyCIDRs:  deny,
+		AllowHosts: cfg.AllowHosts,
+		DenyHosts:  cfg.DenyHosts,
+		Ports:      ports,
+	}
+}
+
+// createCache returns the response cache wrapping next when MaxEntries is
+// positive, or nil.
+func createCache(cfg config.Cache, next resolver.Resolver) *cache.Cache {
+	if cfg.MaxEntries <= 0 {
+		return nil
+	}
+	return cache.New(next, cache.Config{
+		MaxEntries: cfg.MaxEntries,
+		MaxBytes:   cfg.MaxBytes,
+		MaxTTL:     cfg.MaxTTL,
+	})
+}
+
+// createValidator returns a DNSSEC validator using the native client. Trust
+// anchors default to the root KSKs and can be replaced with a trust anchor
+// file; if that file cannot be loaded validation is disabled rather than
+// silently falling back to the root anchors.
+func createValidator(cfg config.Config, upstreams *upstream.Catalog) *dnssec.Validator {
+	var anchors []dnssec.TrustAnchor
+	if path := cfg.DNSSEC.TrustAnchorFile; path != "" {
+		loaded, err := dnssec.LoadTrustAnchors(path)
+		if err != nil {
+			log.Printf("warning: %v, dnssec validation disabled", err)
+			return nil
+		}
+		anchors = loaded
+	}
+	return dnssec.NewValidator(createNativeClient(cfg.Resolver, upstreams), anchors)
+}
+
+// createTracer returns the tracer used for "trace" requests. Root hints
+// override the root server addresses, e.g. to point at a lab root. Every hop
+// is checked against the nameserver policy, so lab roots at private addresses
+// must be allowed by NAMESERVER_ALLOW_CIDRS.
+func createTracer(cfg config.Config, nameserverPolicy *policy.Policy) *trace.Tracer {
+	roots := trace.ParseRootHints(strings.Join(cfg.Trace.RootHints, ","))
+	tracer := trace.NewTracer(resolver.NewNativeClient(traceHopTimeout, cfg.Resolver.MaxOutput), roots)
+	tracer.Policy = nameserverPolicy
+	return tracer
+}
+
+// createKeys loads the API keys from the keys file or the inline JSON. It
+// returns nil, leaving the API open, when neither is set. An invalid key
+// definition is fatal so that a typo cannot disable authentication. Keys
+// without their own limit use the client rate limit.
+func createKeys(cfg config.Auth, limits config.RateLimit) *auth.Keyring {
+	var keys []auth.Key
+	var err error
+	switch {
+	case cfg.KeysFile != "":
+		keys, err = auth.LoadKeys(cfg.KeysFile)
+	case cfg.Keys != "":
+		keys, err = auth.ParseKeys(strings.NewReader(cfg.Keys))
+	default:
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("API keys: %v", err)
+	}
+	return auth.NewKeyring(keys, auth.Limits{RPS: limits.RPS, Burst: limits.Burst})
+}
+
+// createLimiter returns a configured rate limiter and a stop channel.
+func createLimiter(cfg config.RateLimit) (*ratelimit.Manager, chan struct{}) {
+	limiter := ratelimit.NewManager(cfg.RPS, cfg.Burst)
 	stopCleanup := make(chan struct{})
 	go limiter.Cleanup(cleanupInterval, stopCleanup)
 	return limiter, stopCleanup
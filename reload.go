@@ -0,0 +1,181 @@
+package wdns
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/exiguus/wdns/internal/auth"
+	"github.com/exiguus/wdns/internal/config"
+	"github.com/exiguus/wdns/internal/handler"
+	"github.com/exiguus/wdns/internal/upstream"
+)
+
+// reloader serves the application handlers and swaps them, together with the
+// rate limits, nameserver policy and upstream profiles, when the
+// configuration is reloaded. Connections and in-flight requests are not
+// affected; requests started before a reload complete with the previous
+// settings.
+type reloader struct {
+	mu      sync.Mutex
+	args    []string
+	cfg     config.Config
+	loaded  config.Config
+	opts    handler.Options
+	split   bool
+	mux     atomic.Pointer[http.ServeMux]
+	admin   atomic.Pointer[http.ServeMux]
+	trusted atomic.Pointer[[]*net.IPNet]
+}
+
+// newReloader registers the handlers for opts, which must have been built
+// from cfg. args are the command-line arguments cfg was loaded from. With
+// split the admin endpoints are only served by adminHandler.
+func newReloader(args []string, cfg config.Config, opts handler.Options, split bool) *reloader {
+	r := &reloader{
+		mu:      sync.Mutex{},
+		args:    args,
+		cfg:     cfg,
+		loaded:  cfg,
+		opts:    opts,
+		split:   split,
+		mux:     atomic.Pointer[http.ServeMux]{},
+		admin:   atomic.Pointer[http.ServeMux]{},
+		trusted: atomic.Pointer[[]*net.IPNet]{},
+	}
+	r.opts.Reload = r.reload
+	r.swap(opts.TrustedProxies, opts.ClientIPHeaders)
+	return r
+}
+
+// ServeHTTP dispatches to the handlers of the current configuration.
+func (r *reloader) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	r.mux.Load().ServeHTTP(writer, req)
+}
+
+// adminHandler dispatches to the admin handlers of the current
+// configuration, for the separate admin listener.
+func (r *reloader) adminHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		r.admin.Load().ServeHTTP(writer, req)
+	})
+}
+
+// trustedProxies returns the networks currently trusted to send PROXY
+// protocol and forwarding headers.
+func (r *reloader) trustedProxies() []*net.IPNet {
+	return *r.trusted.Load()
+}
+
+// reload loads the configuration again from the same sources as at startup
+// and applies the settings that can change at runtime: trusted proxies and
+// client IP headers, rate limits, the nameserver policy and the upstream
+// profiles. The new configuration is validated as a whole, and the upstreams
+// file loaded, before any of it is applied, so a rejected reload leaves the
+// running configuration untouched. Changes to other settings are logged and
+// ignored. Changes are reported against the configuration loaded by the
+// previous reload, so an ignored change is reported once rather than on
+// every reload. It returns a description of every change.
+func (r *reloader) reload(ctx context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logger := r.opts.Logger
+
+	next, _, err := config.Load(r.args, os.LookupEnv)
+	if err != nil {
+		logger.ErrorContext(ctx, "configuration reload rejected", "err", err)
+		return nil, err //nolint:wrapcheck // config.Load errors name the setting
+	}
+	applied := r.cfg.Apply(next)
+	if err := applied.Validate(); err != nil {
+		logger.ErrorContext(ctx, "configuration reload rejected", "err", err)
+		return nil, err //nolint:wrapcheck // validation errors name the setting
+	}
+	catalog, err := loadUpstreams(applied.Resolver.UpstreamsFile)
+	if err != nil {
+		logger.ErrorContext(ctx, "configuration reload rejected", "err", err)
+		return nil, err
+	}
+
+	var changes []string
+	for _, change := range config.Diff(r.loaded, next) {
+		if !config.Reloadable(change.Setting) {
+			logger.WarnContext(ctx, "configuration change requires a restart, ignored", changeAttrs(change)...)
+			changes = append(changes, change.String()+" (requires a restart)")
+			continue
+		}
+		logger.InfoContext(ctx, "configuration changed", changeAttrs(change)...)
+		changes = append(changes, change.String())
+	}
+	if change, ok := diffUpstreams(r.opts.Upstreams, catalog); ok {
+		logger.InfoContext(ctx, "configuration changed", changeAttrs(change)...)
+		changes = append(changes, change.String())
+	}
+
+	trusted, _ := config.ParseTrustedProxies(applied.Server.TrustedProxies)
+	r.opts.Limiter.SetLimits(applied.RateLimit.RPS, applied.RateLimit.Burst)
+	if r.opts.Keys != nil {
+		r.opts.Keys.SetDefaults(auth.Limits{RPS: applied.RateLimit.RPS, Burst: applied.RateLimit.Burst})
+	}
+	r.opts.Policy.SetConfig(policyConfig(applied.Policy))
+	r.opts.Upstreams.Replace(catalog)
+	r.swap(trusted, applied.Server.ClientIPHeaders)
+	r.cfg = applied
+	r.loaded = next
+	logger.InfoContext(ctx, "configuration reloaded", "changes", len(changes))
+	return changes, nil
+}
+
+// swap registers the handlers with the given client identification settings
+// and atomically replaces the served ones.
+func (r *reloader) swap(trusted []*net.IPNet, clientIPHeaders []string) {
+	r.opts.TrustedProxies = trusted
+	r.opts.ClientIPHeaders = clientIPHeaders
+	mux, admin := http.NewServeMux(), http.NewServeMux()
+	if r.split {
+		handler.RegisterAPI(mux, r.opts)
+		handler.RegisterAdmin(admin, r.opts)
+	} else {
+		handler.Register(mux, r.opts)
+	}
+	r.trusted.Store(&trusted)
+	r.mux.Store(mux)
+	r.admin.Store(admin)
+}
+
+// changeAttrs returns the log attributes of change, without the values of
+// secret settings.
+func changeAttrs(change config.Change) []any {
+	if config.Secret(change.Setting) {
+		return []any{"setting", change.Setting}
+	}
+	return []any{"setting", change.Setting, "old", change.Old, "new", change.New}
+}
+
+// diffUpstreams reports a change of the upstream profiles, which the
+// configuration diff misses when only the file's content changed.
+func diffUpstreams(prev, next *upstream.Catalog) (config.Change, bool) {
+	if reflect.DeepEqual(prev.Profiles(), next.Profiles()) {
+		var zero config.Change
+		return zero, false
+	}
+	return config.Change{
+		Setting: "upstreams",
+		Old:     profileNames(prev),
+		New:     profileNames(next),
+	}, true
+}
+
+func profileNames(catalog *upstream.Catalog) string {
+	profiles := catalog.Profiles()
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ",")
+}